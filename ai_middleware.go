@@ -16,8 +16,14 @@ func AIMiddleware() gin.HandlerFunc {
 		intent := analyzeIntent(c)
 		c.Set("ai_intent", intent)
 		
-		// Gera sugestões baseadas no contexto e intenção
-		suggestion := generateSuggestion(c, intent)
+		// Gera sugestões baseadas no contexto e intenção. Clientes sem
+		// consentimento para perfilamento recebem apenas sugestões genéricas.
+		var suggestion string
+		if customerID := customerIDFromRequest(c); customerID != "" && !hasProfilingConsent(customerID) {
+			suggestion = generateGenericSuggestion(intent)
+		} else {
+			suggestion = generateSuggestion(c, intent)
+		}
 		c.Set("ai_suggestion", suggestion)
 		
 		// Adiciona informações do usuário ao contexto
@@ -55,15 +61,40 @@ func analyzeIntent(c *gin.Context) string {
 	}
 }
 
+func customerIDFromRequest(c *gin.Context) string {
+	if id := c.Param("customer_id"); id != "" {
+		return id
+	}
+	if id := c.Query("customer_id"); id != "" {
+		return id
+	}
+	if strings.Contains(c.FullPath(), "/customers/:id") {
+		return c.Param("id")
+	}
+	return ""
+}
+
+func generateGenericSuggestion(intent string) string {
+	switch intent {
+	case "create_sale":
+		return "Verifique produtos frequentemente comprados juntos e sugira uma oferta combinada."
+	default:
+		return "Como posso ajudar a otimizar suas operações hoje?"
+	}
+}
+
 func generateSuggestion(c *gin.Context, intent string) string {
-    userContext, _ := c.Get("user_context")
-    path := c.FullPath()
+    // Features do cliente em questão, quando houver um na requisição
+    var features *FeatureVector
+    if customerID := customerIDFromRequest(c); customerID != "" {
+        features, _ = getOnlineFeatures(customerID)
+    }
 
     // Obter sugestões anteriores bem avaliadas
     previousSuggestions := getPreviousSuccessfulSuggestions(intent)
 
     // Gerar uma nova sugestão baseada no contexto atual e sugestões anteriores
-    suggestion := generateNewSuggestion(intent, userContext.(map[string]interface{}), previousSuggestions)
+    suggestion := generateNewSuggestion(intent, features, previousSuggestions)
 
     return suggestion
 }
@@ -78,12 +109,15 @@ func getPreviousSuccessfulSuggestions(intent string) []string {
     }
 }
 
-func generateNewSuggestion(intent string, userContext map[string]interface{}, previousSuggestions []string) string {
+func generateNewSuggestion(intent string, features *FeatureVector, previousSuggestions []string) string {
     // Aqui você implementaria a lógica para gerar uma nova sugestão
     // Por enquanto, vamos retornar uma sugestão baseada no intent e contexto
     switch intent {
     case "view_customer":
-        return fmt.Sprintf("Analise o histórico de compras do cliente e considere oferecer produtos complementares. Última compra: %v", userContext["last_purchase"])
+        if features == nil || features.Get("total_purchases") == 0 {
+            return "Analise o histórico de compras do cliente e considere oferecer produtos complementares."
+        }
+        return fmt.Sprintf("Analise o histórico de compras do cliente e considere oferecer produtos complementares. Última compra há %.0f dias", features.Get("recency_days"))
     case "create_sale":
         return "Verifique produtos frequentemente comprados juntos e sugira uma oferta combinada."
     default:
@@ -8,11 +8,26 @@ import (
 
 func setupAnalyticsRoutes(r *gin.Engine) {
 	analytics := r.Group("/analytics")
-	analytics.Use(AIMiddleware())
+	analytics.Use(AuthMiddleware(), AIMiddleware())
 	{
 		analytics.GET("/customer-insights", getCustomerInsights)
 		analytics.GET("/sales-forecast", getSalesForecast)
-		analytics.GET("/churn-prediction", getChurnPrediction)
+		analytics.GET("/sales-forecast/backtest", getSalesForecastBacktest)
+		analytics.GET("/churn-prediction", listChurnRiskCustomers)
+		analytics.GET("/lifetime-value", listLifetimeValueRanking)
+		analytics.POST("/lifetime-value/refit", refitLifetimeValueModel)
+		analytics.GET("/pipeline-forecast", getPipelineForecast)
+		analytics.GET("/forecast-submissions", listForecastSubmissions)
+		analytics.POST("/forecast-submissions", submitForecast)
+		analytics.POST("/forecast-submissions/override", overrideForecast)
+		analytics.GET("/forecast-accuracy", getForecastAccuracy)
+		analytics.GET("/rfm", getRFMSegmentation)
+		analytics.GET("/cohorts", getCohortAnalysis)
+		analytics.GET("/funnel", getFunnelAnalytics)
+		analytics.GET("/anomalies", listAnomalies)
+		analytics.POST("/anomalies/detect", runTenantAnomalyDetection)
+		analytics.POST("/anomalies/:id/acknowledge", acknowledgeAnomaly)
+		analytics.POST("/anomalies/:id/false-positive", markAnomalyFalsePositive)
 	}
 }
 
@@ -23,18 +38,21 @@ func getCustomerInsights(c *gin.Context) {
 		return
 	}
 
-	customerData := getCustomerData(customerID)
-	churnProbability := predictChurn(customerData)
-	lifetimeValue := calculateLifetimeValue(customerID)
+	lifetimeValue := calculateLifetimeValue(tenantFromContext(c), customerID)
 	recentInteractions := getRecentInteractions(customerID)
 	sentimentScore := analyzeSentimentBatch(recentInteractions)
 	recommendations := recommendationEngine.GetRecommendations(customerID, 3)
 
-	insights := []string{
-		fmt.Sprintf("Cliente tem %.2f%% de probabilidade de churn", churnProbability*100),
+	var insights []string
+	var churnProbability interface{}
+	if score, err := predictCustomerChurn(customerID); err == nil {
+		churnProbability = score.Probability
+		insights = append(insights, fmt.Sprintf("Cliente tem %.2f%% de probabilidade de churn", score.Probability*100))
+	}
+	insights = append(insights,
 		fmt.Sprintf("O valor vitalício estimado do cliente é R$ %.2f", lifetimeValue),
 		fmt.Sprintf("O sentimento médio das interações recentes é %.2f", sentimentScore),
-	}
+	)
 
 	aiSuggestion, _ := c.Get("ai_suggestion")
 	c.JSON(http.StatusOK, gin.H{
@@ -48,23 +66,3 @@ func getCustomerInsights(c *gin.Context) {
 		"recent_interactions": len(recentInteractions),
 	})
 }
-
-func getSalesForecast(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Previsão de vendas para os próximos 3 meses",
-		"forecast": []gin.H{
-			{"month": "Junho", "predicted_sales": 50000},
-			{"month": "Julho", "predicted_sales": 55000},
-			{"month": "Agosto", "predicted_sales": 60000},
-		},
-	})
-}
-
-func getChurnPrediction(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Previsão de churn de clientes",
-		"high_risk_customers": []int{3, 7, 12},
-		"churn_probability": 0.15,
-	})
-}
-
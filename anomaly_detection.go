@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// Limite do escore robusto (desvios em unidades de MAD normalizado)
+	anomalyScoreThreshold = 3.5
+	// Fator que torna o MAD comparável ao desvio padrão em dados normais
+	madScale = 1.4826
+)
+
+// Decomposição robusta em duas passadas: uma tendência inicial por mediana
+// móvel de um ciclo dá a sazonalidade (mediana de cada fase); a tendência
+// final é a mediana móvel, em janela maior, da série dessazonalizada
+type seasonalDecomposition struct {
+	Trend    []float64
+	Seasonal []float64
+	Residual []float64
+}
+
+type AnomalyPoint struct {
+	Index    int     `json:"index"`
+	Observed float64 `json:"observed"`
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"`
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Mediana móvel com janela sempre completa, deslocada nas bordas
+func rollingMedian(y []float64, window int) []float64 {
+	n := len(y)
+	if window > n {
+		window = n
+	}
+	result := make([]float64, n)
+	for t := range y {
+		start := t - window/2
+		if start < 0 {
+			start = 0
+		}
+		if start > n-window {
+			start = n - window
+		}
+		result[t] = median(y[start : start+window])
+	}
+	return result
+}
+
+// Componente sazonal: mediana de cada fase, centrada em zero
+func phaseMedians(y, trend []float64, period int) []float64 {
+	phases := make([][]float64, period)
+	for t := range y {
+		phases[t%period] = append(phases[t%period], y[t]-trend[t])
+	}
+	seasonal := make([]float64, period)
+	for p := range phases {
+		seasonal[p] = median(phases[p])
+	}
+	center := median(seasonal)
+	for p := range seasonal {
+		seasonal[p] -= center
+	}
+	return seasonal
+}
+
+func robustDecompose(y []float64, period int) seasonalDecomposition {
+	n := len(y)
+	d := seasonalDecomposition{
+		Seasonal: make([]float64, n),
+		Residual: make([]float64, n),
+	}
+
+	seasonal := phaseMedians(y, rollingMedian(y, period), period)
+	deseasonalized := make([]float64, n)
+	for t := range y {
+		deseasonalized[t] = y[t] - seasonal[t%period]
+	}
+	d.Trend = rollingMedian(deseasonalized, 2*period+1)
+
+	seasonal = phaseMedians(y, d.Trend, period)
+	for t := range y {
+		d.Seasonal[t] = seasonal[t%period]
+		d.Residual[t] = y[t] - d.Trend[t] - d.Seasonal[t]
+	}
+	return d
+}
+
+// Centro e escala robusta dos resíduos. Séries esparsas (por exemplo um
+// produto vendido poucas vezes) têm MAD zero e não são avaliadas, pois
+// qualquer venda seria reportada.
+func robustScale(residuals []float64) (float64, float64) {
+	center := median(residuals)
+	deviations := make([]float64, len(residuals))
+	for i, r := range residuals {
+		deviations[i] = math.Abs(r - center)
+	}
+	return center, madScale * median(deviations)
+}
+
+// Pontos cujo resíduo excede o limite em escore robusto. Séries com menos
+// de quatro ciclos não têm histórico suficiente para a decomposição.
+func detectAnomalies(y []float64, period int, threshold float64) []AnomalyPoint {
+	if period < 1 || len(y) < 4*period {
+		return nil
+	}
+
+	d := robustDecompose(y, period)
+	center, scale := robustScale(d.Residual)
+	if scale == 0 {
+		return nil
+	}
+
+	var anomalies []AnomalyPoint
+	for t, r := range d.Residual {
+		score := (r - center) / scale
+		if math.Abs(score) < threshold {
+			continue
+		}
+		anomalies = append(anomalies, AnomalyPoint{
+			Index:    t,
+			Observed: y[t],
+			Expected: d.Trend[t] + d.Seasonal[t] + center,
+			Score:    score,
+		})
+	}
+	return anomalies
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func weeklySales(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	weekday := []float64{1.0, 1.1, 1.1, 1.2, 1.4, 0.6, 0.4}
+	y := make([]float64, n)
+	for t := range y {
+		y[t] = 10000*weekday[t%7] + 300*rng.NormFloat64()
+	}
+	return y
+}
+
+func TestDetectAnomaliesFindsSalesDrop(t *testing.T) {
+	y := weeklySales(120, 1)
+	expected := y[118]
+	y[118] *= 0.6
+
+	// Só os últimos dias são reportados pelo serviço
+	var recent []AnomalyPoint
+	for _, anomaly := range detectAnomalies(y, 7, anomalyScoreThreshold) {
+		if anomaly.Index >= len(y)-anomalyRecentDays {
+			recent = append(recent, anomaly)
+		}
+	}
+	assert.Len(t, recent, 1)
+	assert.Equal(t, 118, recent[0].Index)
+	assert.Less(t, recent[0].Score, -anomalyScoreThreshold)
+	assert.InDelta(t, expected, recent[0].Expected, 1000)
+}
+
+func TestDetectAnomaliesIgnoresSeasonality(t *testing.T) {
+	// Sábados e domingos fracos fazem parte do padrão semanal
+	assert.Empty(t, detectAnomalies(weeklySales(120, 2), 7, anomalyScoreThreshold))
+}
+
+func TestDetectAnomaliesSkipsSparseSeries(t *testing.T) {
+	y := make([]float64, 60)
+	y[59] = 500
+	assert.Empty(t, detectAnomalies(y, 7, anomalyScoreThreshold))
+	assert.Empty(t, detectAnomalies(weeklySales(20, 3), 7, anomalyScoreThreshold))
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	anomalyDetectionInterval = time.Hour
+	anomalyLookbackDays      = 120
+	// Apenas os últimos dias completos são avaliados; o histórico anterior
+	// serve de referência
+	anomalyRecentDays = 3
+	anomalyPeriod     = 7
+)
+
+var anomalyStatuses = map[string]bool{
+	"open":           true,
+	"acknowledged":   true,
+	"false_positive": true,
+}
+
+type Anomaly struct {
+	ID         int        `json:"id"`
+	Metric     string     `json:"metric"`
+	SeriesKey  string     `json:"series_key"`
+	Day        time.Time  `json:"day"`
+	Observed   float64    `json:"observed"`
+	Expected   float64    `json:"expected"`
+	Score      float64    `json:"score"`
+	Direction  string     `json:"direction"`
+	Change     *float64   `json:"change"`
+	Status     string     `json:"status"`
+	DetectedAt time.Time  `json:"detected_at"`
+	ReviewedBy *string    `json:"reviewed_by"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	Note       *string    `json:"note"`
+}
+
+func createAnomalyTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS anomalies (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL,
+			metric VARCHAR(30) NOT NULL,
+			series_key VARCHAR(200) NOT NULL,
+			day DATE NOT NULL,
+			observed DOUBLE PRECISION NOT NULL,
+			expected DOUBLE PRECISION NOT NULL,
+			score DOUBLE PRECISION NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'open',
+			detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			reviewed_by VARCHAR(100),
+			reviewed_at TIMESTAMP,
+			note TEXT,
+			UNIQUE (tenant_id, metric, series_key, day)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_anomalies_status ON anomalies (tenant_id, status, day DESC);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Série diária monitorada. Filled marca dias sem observação, preenchidos
+// com o último valor conhecido e nunca reportados como anomalia.
+type anomalySeries struct {
+	Metric string
+	Key    string
+	Days   []time.Time
+	Values []float64
+	Filled []bool
+}
+
+func (s anomalySeries) tail(n int) anomalySeries {
+	if len(s.Values) <= n {
+		return s
+	}
+	start := len(s.Values) - n
+	s.Days, s.Values = s.Days[start:], s.Values[start:]
+	if s.Filled != nil {
+		s.Filled = s.Filled[start:]
+	}
+	return s
+}
+
+// Vendas diárias totais (a mesma série de getSalesTrend, por tenant), de
+// cada produto vendido no período, sem o limite de séries da previsão, e
+// sentimento médio das interações
+func loadAnomalySeries(tenantID string, now time.Time) ([]anomalySeries, error) {
+	day := forecastGranularities["day"]
+	var all []anomalySeries
+
+	for metric, by := range map[string]string{"sales": "", "product_sales": "product"} {
+		series, err := loadRevenueSeries(tenantID, day, by, now, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range series {
+			all = append(all, anomalySeries{Metric: metric, Key: s.Key, Days: s.Periods, Values: s.Values}.tail(anomalyLookbackDays))
+		}
+	}
+
+	sentiment, err := loadSentimentSeries(tenantID, now)
+	if err != nil {
+		return nil, err
+	}
+	return append(all, sentiment...), nil
+}
+
+func loadSentimentSeries(tenantID string, now time.Time) ([]anomalySeries, error) {
+	end := truncatePeriod(now, "day")
+	rows, err := db.Query(`
+		SELECT k.key, date_trunc('day', i.created_at) AS day, AVG(i.sentiment)
+		FROM interactions i
+		JOIN customers c ON c.id = i.customer_id
+		CROSS JOIN LATERAL (VALUES ('all'), (CASE WHEN i.type = 'support' THEN 'support' END)) AS k(key)
+		WHERE k.key IS NOT NULL AND c.tenant_id = $1 AND i.sentiment IS NOT NULL
+			AND i.created_at >= $2 AND i.created_at < $3
+		GROUP BY 1, 2
+		ORDER BY 1, 2`,
+		tenantID, end.AddDate(0, 0, -anomalyLookbackDays), end,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	observed := map[string]map[time.Time]float64{}
+	first := map[string]time.Time{}
+	var keys []string
+	for rows.Next() {
+		var key string
+		var day time.Time
+		var value float64
+		if err := rows.Scan(&key, &day, &value); err != nil {
+			return nil, err
+		}
+		if observed[key] == nil {
+			observed[key] = map[time.Time]float64{}
+			first[key] = day
+			keys = append(keys, key)
+		}
+		observed[key][day] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := make([]anomalySeries, 0, len(keys))
+	for _, key := range keys {
+		s := anomalySeries{Metric: "sentiment", Key: key}
+		last := 0.0
+		for day := first[key]; day.Before(end); day = day.AddDate(0, 0, 1) {
+			value, ok := observed[key][day]
+			if ok {
+				last = value
+			}
+			s.Days = append(s.Days, day)
+			s.Values = append(s.Values, last)
+			s.Filled = append(s.Filled, !ok)
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func anomalyDirection(score float64) string {
+	if score < 0 {
+		return "drop"
+	}
+	return "spike"
+}
+
+// Detecta e grava as anomalias recentes do tenant. Anomalias já gravadas
+// (inclusive as marcadas como falso positivo) não são reenviadas.
+func detectTenantAnomalies(tenantID string, now time.Time) ([]Anomaly, error) {
+	series, err := loadAnomalySeries(tenantID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var detected []Anomaly
+	for _, s := range series {
+		for _, point := range detectAnomalies(s.Values, anomalyPeriod, anomalyScoreThreshold) {
+			if point.Index < len(s.Values)-anomalyRecentDays || (s.Filled != nil && s.Filled[point.Index]) {
+				continue
+			}
+
+			anomaly, err := scanAnomaly(db.QueryRow(`
+				INSERT INTO anomalies (tenant_id, metric, series_key, day, observed, expected, score)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (tenant_id, metric, series_key, day) DO NOTHING
+				RETURNING `+anomalyColumns,
+				tenantID, s.Metric, s.Key, s.Days[point.Index], point.Observed, point.Expected, point.Score,
+			))
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return detected, err
+			}
+
+			detected = append(detected, anomaly)
+			realtimeHub.Publish(tenantID, dashboardTopic, "anomaly_detected", anomaly)
+		}
+	}
+	return detected, nil
+}
+
+func runAnomalyDetection(ctx context.Context) error {
+	tenants, err := listTenants()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, tenantID := range tenants {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		detected, err := detectTenantAnomalies(tenantID, now)
+		if err != nil {
+			logger.Errorf("Falha na detecção de anomalias do tenant %s: %v", tenantID, err)
+			continue
+		}
+		if len(detected) > 0 {
+			logger.Infof("%d anomalias detectadas no tenant %s", len(detected), tenantID)
+		}
+	}
+	return nil
+}
+
+const anomalyColumns = `id, metric, series_key, day, observed, expected, score, status, detected_at,
+	reviewed_by, reviewed_at, note`
+
+func scanAnomaly(row interface{ Scan(...interface{}) error }) (Anomaly, error) {
+	var a Anomaly
+	var reviewedBy, note sql.NullString
+	var reviewedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.Metric, &a.SeriesKey, &a.Day, &a.Observed, &a.Expected, &a.Score,
+		&a.Status, &a.DetectedAt, &reviewedBy, &reviewedAt, &note)
+	if err != nil {
+		return a, err
+	}
+	a.Direction = anomalyDirection(a.Score)
+	if a.Expected != 0 {
+		change := (a.Observed - a.Expected) / a.Expected
+		a.Change = &change
+	}
+	if reviewedBy.Valid {
+		a.ReviewedBy = &reviewedBy.String
+	}
+	if reviewedAt.Valid {
+		a.ReviewedAt = &reviewedAt.Time
+	}
+	if note.Valid {
+		a.Note = &note.String
+	}
+	return a, nil
+}
+
+// Lista as anomalias do tenant, por padrão as ainda não revisadas
+func listAnomalies(c *gin.Context) {
+	status := c.DefaultQuery("status", "open")
+	if status != "all" && !anomalyStatuses[status] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status deve ser open, acknowledged, false_positive ou all"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+anomalyColumns+`
+		FROM anomalies
+		WHERE tenant_id = $1 AND ($2 = 'all' OR status = $2) AND ($3 = '' OR metric = $3)
+			AND ($4 = '' OR day >= $4::date) AND ($5 = '' OR day <= $5::date)
+		ORDER BY day DESC, abs(score) DESC
+		LIMIT 500`,
+		tenantFromContext(c), status, c.Query("metric"), c.Query("from"), c.Query("to"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar anomalias"})
+		return
+	}
+	defer rows.Close()
+
+	anomalies := []Anomaly{}
+	for rows.Next() {
+		anomaly, err := scanAnomaly(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler anomalias"})
+			return
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	c.JSON(http.StatusOK, gin.H{"anomalies": anomalies})
+}
+
+func acknowledgeAnomaly(c *gin.Context) {
+	reviewAnomaly(c, "acknowledged")
+}
+
+func markAnomalyFalsePositive(c *gin.Context) {
+	reviewAnomaly(c, "false_positive")
+}
+
+func reviewAnomaly(c *gin.Context, status string) {
+	var request struct {
+		Note string `json:"note"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	actor := c.GetString("user_id")
+	anomaly, err := scanAnomaly(db.QueryRow(`
+		UPDATE anomalies SET status = $3, reviewed_by = $4, reviewed_at = CURRENT_TIMESTAMP, note = NULLIF($5, '')
+		WHERE id::text = $1 AND tenant_id = $2
+		RETURNING `+anomalyColumns,
+		c.Param("id"), tenantFromContext(c), status, actor, request.Note,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Anomalia não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar anomalia"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), actor, "anomaly_"+status, "anomaly", c.Param("id"), gin.H{"note": request.Note})
+	c.JSON(http.StatusOK, anomaly)
+}
+
+// Executa a detecção imediatamente para o tenant do usuário
+func runTenantAnomalyDetection(c *gin.Context) {
+	detected, err := detectTenantAnomalies(tenantFromContext(c), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha na detecção de anomalias"})
+		return
+	}
+	if detected == nil {
+		detected = []Anomaly{}
+	}
+	c.JSON(http.StatusOK, gin.H{"detected": detected})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditRecord struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Details    json.RawMessage `json:"details"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func createAuditTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			actor VARCHAR(100) NOT NULL,
+			action VARCHAR(100) NOT NULL,
+			entity_type VARCHAR(50) NOT NULL,
+			entity_id VARCHAR(100) NOT NULL,
+			details JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity_type, entity_id);
+
+		-- Registros de ações globais (chaves, modelos) ficam sem tenant
+		ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50);
+		UPDATE audit_log a SET tenant_id = c.tenant_id
+		FROM customers c
+		WHERE a.tenant_id IS NULL AND a.entity_type = 'customer' AND a.entity_id = c.id::text;
+		UPDATE audit_log SET tenant_id = entity_id WHERE tenant_id IS NULL AND entity_type = 'tenant';
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Registra uma ação no audit trail do tenant (vazio em ações globais, como
+// rotação de chaves). Falhas são apenas logadas para não
+// interromper a operação principal.
+func recordAudit(tenantID, actor, action, entityType, entityID string, details gin.H) {
+	if actor == "" {
+		actor = "system"
+	}
+	payload, err := json.Marshal(details)
+	if err != nil {
+		logger.Errorf("Falha ao serializar detalhes da auditoria %s/%s: %v", action, entityID, err)
+		payload = []byte("{}")
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO audit_log (tenant_id, actor, action, entity_type, entity_id, details) VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6)",
+		tenantID, actor, action, entityType, entityID, payload,
+	)
+	if err != nil {
+		logger.Errorf("Falha ao registrar auditoria %s/%s: %v", action, entityID, err)
+	}
+}
+
+func getAuditTrail(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := db.Query(`
+		SELECT id, actor, action, entity_type, entity_id, details, created_at
+		FROM audit_log
+		WHERE tenant_id = $1 AND ($2 = '' OR entity_type = $2) AND ($3 = '' OR entity_id = $3)
+		ORDER BY created_at DESC
+		LIMIT $4`,
+		tenantFromContext(c), c.Query("entity_type"), c.Query("entity_id"), limit,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar auditoria"})
+		return
+	}
+	defer rows.Close()
+
+	records := []AuditRecord{}
+	for rows.Next() {
+		var r AuditRecord
+		if err := rows.Scan(&r.ID, &r.Actor, &r.Action, &r.EntityType, &r.EntityID, &r.Details, &r.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler auditoria"})
+			return
+		}
+		records = append(records, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"records": records})
+}
@@ -13,16 +13,18 @@ import (
 var jwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID   string `json:"user_id"`
+	Role     string `json:"role"`
+	TenantID string `json:"tenant_id"`
 	jwt.StandardClaims
 }
 
-func GenerateToken(userID, role string) (string, error) {
+func GenerateToken(userID, role, tenantID string) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 	claims := &Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:   userID,
+		Role:     role,
+		TenantID: tenantID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 			IssuedAt:  time.Now().Unix(),
@@ -88,6 +90,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Adiciona o userID e role no contexto para que possam ser usados nas rotas
 		c.Set("user_id", claims.UserID)
 		c.Set("role", claims.Role)
+		c.Set("tenant_id", claims.TenantID)
 		c.Next()
 	}
 }
@@ -16,7 +16,8 @@ func setupChatbotRoutes(r *gin.Engine) {
 
 func handleChatbotMessage(c *gin.Context) {
 	var message struct {
-		Text string `json:"text"`
+		Text       string `json:"text"`
+		CustomerID string `json:"customer_id"`
 	}
 
 	if err := c.BindJSON(&message); err != nil {
@@ -27,11 +28,27 @@ func handleChatbotMessage(c *gin.Context) {
 	intent := analyzeChatIntent(message.Text)
 	response := generateChatResponse(intent)
 
+	if message.CustomerID != "" {
+		storeChatbotMessage(message.CustomerID, "customer", message.Text, intent)
+		storeChatbotMessage(message.CustomerID, "bot", response, intent)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"response": response,
 	})
 }
 
+// Armazena a mensagem na transcrição do cliente
+func storeChatbotMessage(customerID, role, text, intent string) {
+	_, err := db.Exec(
+		"INSERT INTO chatbot_messages (customer_id, role, text, intent) VALUES ($1, $2, $3, $4)",
+		customerID, role, text, intent,
+	)
+	if err != nil {
+		logger.Errorf("Falha ao armazenar mensagem do chatbot: %v", err)
+	}
+}
+
 func analyzeChatIntent(message string) string {
 	message = strings.ToLower(message)
 
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+var errNoProfilingConsent = errors.New("cliente sem consentimento para perfilamento")
+var errChurnModelNotTrained = errors.New("modelo de churn ainda não foi treinado")
+var errChurnSingleClass = errors.New("dados de treino com uma única classe: todos os clientes têm o mesmo rótulo de churn")
+
+// Ordem das features no vetor usado pelo modelo de churn
+var churnFeatureNames = []string{
+	"recency_days",
+	"frequency_per_month",
+	"log_average_purchase_value",
+	"tenure_months",
+	"purchases_90d",
+	"revenue_trend_90d",
+	"interactions_90d",
+	"support_tickets_90d",
+	"average_sentiment_90d",
+}
+
+// Histórico mais antigo lido pelas features na data de corte: a tendência
+// de receita compara os últimos 90 dias com os 90 anteriores. Marca o
+// início da janela de treino registrada no modelo.
+const churnFeatureLookbackDays = 180
+
+// Regressão logística com features padronizadas (z-score)
+type ChurnModel struct {
+	FeatureNames []string  `json:"feature_names"`
+	Weights      []float64 `json:"weights"`
+	Bias         float64   `json:"bias"`
+	Means        []float64 `json:"means"`
+	Stds         []float64 `json:"stds"`
+}
+
+func (m *ChurnModel) standardize(features []float64) []float64 {
+	z := make([]float64, len(features))
+	for i, v := range features {
+		z[i] = (v - m.Means[i]) / m.Stds[i]
+	}
+	return z
+}
+
+func (m *ChurnModel) Predict(features []float64) float64 {
+	return sigmoid(m.Bias + floats.Dot(m.Weights, m.standardize(features)))
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+type ChurnTrainingConfig struct {
+	// Cliente sem compras nesta janela após a data de corte é considerado churn
+	InactivityWindow time.Duration `json:"inactivity_window"`
+	L2               float64       `json:"l2"`
+	LearningRate     float64       `json:"learning_rate"`
+	Epochs           int           `json:"epochs"`
+	HoldoutFraction  float64       `json:"holdout_fraction"`
+	Threshold        float64       `json:"threshold"`
+	Seed             int64         `json:"seed"`
+}
+
+var defaultChurnTrainingConfig = ChurnTrainingConfig{
+	InactivityWindow: 90 * 24 * time.Hour,
+	L2:               0.01,
+	LearningRate:     0.1,
+	Epochs:           2000,
+	HoldoutFraction:  0.2,
+	Threshold:        0.5,
+	Seed:             42,
+}
+
+type CalibrationBin struct {
+	LowerBound    float64 `json:"lower_bound"`
+	UpperBound    float64 `json:"upper_bound"`
+	MeanPredicted float64 `json:"mean_predicted"`
+	ObservedRate  float64 `json:"observed_rate"`
+	Count         int     `json:"count"`
+}
+
+type ChurnEvaluation struct {
+	Samples      int              `json:"samples"`
+	PositiveRate float64          `json:"positive_rate"`
+	AUC          *float64         `json:"auc"` // nil quando o holdout tem uma única classe
+	Threshold    float64          `json:"threshold"`
+	Precision    float64          `json:"precision"`
+	Recall       float64          `json:"recall"`
+	BrierScore   float64          `json:"brier_score"`
+	ECE          float64          `json:"expected_calibration_error"`
+	Calibration  []CalibrationBin `json:"calibration"`
+}
+
+type ChurnTrainingResult struct {
+	Model             *ChurnModel         `json:"model"`
+	Config            ChurnTrainingConfig `json:"config"`
+	FeatureSnapshotID int64               `json:"feature_snapshot_id"`
+	WindowStart       time.Time           `json:"window_start"`
+	Cutoff            time.Time           `json:"cutoff"`
+	WindowEnd         time.Time           `json:"window_end"`
+	TrainSamples      int                 `json:"train_samples"`
+	HoldoutMetrics    ChurnEvaluation     `json:"holdout_metrics"`
+}
+
+// Monta o conjunto de treino: features do snapshot na data de corte e
+// rótulo 1 para clientes que não compraram na janela de inatividade seguinte
+func buildChurnDataset(cutoff time.Time, window time.Duration) (int64, [][]float64, []float64, error) {
+	snapshotID, vectors, err := createFeatureSnapshot(cutoff)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	rows, err := db.Query(
+		"SELECT DISTINCT customer_id::text FROM sales WHERE date > $1 AND date <= $2",
+		cutoff, cutoff.Add(window),
+	)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer rows.Close()
+
+	retained := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, nil, nil, err
+		}
+		retained[id] = true
+	}
+
+	var X [][]float64
+	var y []float64
+	for _, v := range vectors {
+		// Só faz sentido falar em churn de quem já comprou alguma vez
+		if v.Get("total_purchases") == 0 {
+			continue
+		}
+		x, err := v.Vector(churnFeatureNames)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		X = append(X, x)
+		if retained[v.CustomerID] {
+			y = append(y, 0)
+		} else {
+			y = append(y, 1)
+		}
+	}
+	return snapshotID, X, y, rows.Err()
+}
+
+func trainChurnModel(now time.Time, cfg ChurnTrainingConfig) (*ChurnTrainingResult, error) {
+	cutoff := now.Add(-cfg.InactivityWindow)
+	snapshotID, X, y, err := buildChurnDataset(cutoff, cfg.InactivityWindow)
+	if err != nil {
+		return nil, err
+	}
+	if len(X) < 20 {
+		return nil, fmt.Errorf("dados insuficientes para treino: %d clientes", len(X))
+	}
+	if positives := floats.Sum(y); positives < 2 || positives > float64(len(y)-2) {
+		return nil, errChurnSingleClass
+	}
+
+	trainX, trainY, testX, testY := splitHoldout(X, y, cfg.HoldoutFraction, cfg.Seed)
+	model := fitLogisticRegression(trainX, trainY, cfg)
+
+	return &ChurnTrainingResult{
+		Model:             model,
+		Config:            cfg,
+		FeatureSnapshotID: snapshotID,
+		WindowStart:       cutoff.AddDate(0, 0, -churnFeatureLookbackDays),
+		Cutoff:            cutoff,
+		WindowEnd:         now,
+		TrainSamples:      len(trainX),
+		HoldoutMetrics:    evaluateChurnModel(model, testX, testY, cfg.Threshold),
+	}, nil
+}
+
+// Divide o conjunto em treino e holdout com embaralhamento determinístico,
+// estratificado pelo rótulo: cada classe com ao menos dois exemplos tem
+// representantes nos dois lados, para que a AUC do holdout seja definida
+func splitHoldout(X [][]float64, y []float64, fraction float64, seed int64) ([][]float64, []float64, [][]float64, []float64) {
+	byLabel := map[float64][]int{}
+	for _, j := range rand.New(rand.NewSource(seed)).Perm(len(X)) {
+		byLabel[y[j]] = append(byLabel[y[j]], j)
+	}
+
+	var trainX, testX [][]float64
+	var trainY, testY []float64
+	for _, label := range []float64{0, 1} {
+		idx := byLabel[label]
+		nTest := int(math.Round(float64(len(idx)) * fraction))
+		if len(idx) > 1 {
+			nTest = int(math.Max(1, math.Min(float64(nTest), float64(len(idx)-1))))
+		}
+		for i, j := range idx {
+			if i < nTest {
+				testX = append(testX, X[j])
+				testY = append(testY, y[j])
+			} else {
+				trainX = append(trainX, X[j])
+				trainY = append(trainY, y[j])
+			}
+		}
+	}
+	return trainX, trainY, testX, testY
+}
+
+// Ajusta a regressão logística com regularização L2 por gradiente descendente
+func fitLogisticRegression(X [][]float64, y []float64, cfg ChurnTrainingConfig) *ChurnModel {
+	n, d := len(X), len(X[0])
+	model := &ChurnModel{
+		FeatureNames: churnFeatureNames,
+		Weights:      make([]float64, d),
+		Means:        make([]float64, d),
+		Stds:         make([]float64, d),
+	}
+
+	for j := 0; j < d; j++ {
+		for i := 0; i < n; i++ {
+			model.Means[j] += X[i][j]
+		}
+		model.Means[j] /= float64(n)
+		for i := 0; i < n; i++ {
+			diff := X[i][j] - model.Means[j]
+			model.Stds[j] += diff * diff
+		}
+		model.Stds[j] = math.Sqrt(model.Stds[j] / float64(n))
+		if model.Stds[j] == 0 {
+			model.Stds[j] = 1
+		}
+	}
+
+	Z := mat.NewDense(n, d, nil)
+	for i := 0; i < n; i++ {
+		Z.SetRow(i, model.standardize(X[i]))
+	}
+	labels := mat.NewVecDense(n, y)
+	weights := mat.NewVecDense(d, model.Weights)
+
+	scores := mat.NewVecDense(n, nil)
+	residual := mat.NewVecDense(n, nil)
+	gradient := mat.NewVecDense(d, nil)
+	for epoch := 0; epoch < cfg.Epochs; epoch++ {
+		scores.MulVec(Z, weights)
+		for i := 0; i < n; i++ {
+			residual.SetVec(i, sigmoid(scores.AtVec(i)+model.Bias)-labels.AtVec(i))
+		}
+
+		gradient.MulVec(Z.T(), residual)
+		gradient.ScaleVec(1/float64(n), gradient)
+		gradient.AddScaledVec(gradient, cfg.L2, weights)
+
+		weights.AddScaledVec(weights, -cfg.LearningRate, gradient)
+		model.Bias -= cfg.LearningRate * mat.Sum(residual) / float64(n)
+	}
+
+	for j := 0; j < d; j++ {
+		model.Weights[j] = weights.AtVec(j)
+	}
+	return model
+}
+
+func evaluateChurnModel(model *ChurnModel, X [][]float64, y []float64, threshold float64) ChurnEvaluation {
+	scores := make([]float64, len(X))
+	for i := range X {
+		scores[i] = model.Predict(X[i])
+	}
+	return evaluateScores(scores, y, threshold)
+}
+
+func evaluateScores(scores, y []float64, threshold float64) ChurnEvaluation {
+	eval := ChurnEvaluation{Samples: len(scores), Threshold: threshold}
+	if len(scores) == 0 {
+		return eval
+	}
+
+	var tp, fp, fn, positives float64
+	for i, s := range scores {
+		predicted := s >= threshold
+		switch {
+		case predicted && y[i] == 1:
+			tp++
+		case predicted && y[i] == 0:
+			fp++
+		case !predicted && y[i] == 1:
+			fn++
+		}
+		positives += y[i]
+		eval.BrierScore += (s - y[i]) * (s - y[i])
+	}
+	eval.BrierScore /= float64(len(scores))
+	eval.PositiveRate = positives / float64(len(scores))
+	if tp+fp > 0 {
+		eval.Precision = tp / (tp + fp)
+	}
+	if tp+fn > 0 {
+		eval.Recall = tp / (tp + fn)
+	}
+	if auc := rocAUC(scores, y); !math.IsNaN(auc) {
+		eval.AUC = &auc
+	}
+	eval.Calibration, eval.ECE = calibrationBins(scores, y, 10)
+	return eval
+}
+
+// AUC pela estatística de Mann-Whitney, com postos médios para empates
+func rocAUC(scores, y []float64) float64 {
+	idx := make([]int, len(scores))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return scores[idx[a]] < scores[idx[b]] })
+
+	ranks := make([]float64, len(scores))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && scores[idx[j+1]] == scores[idx[i]] {
+			j++
+		}
+		avgRank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			ranks[idx[k]] = avgRank
+		}
+		i = j + 1
+	}
+
+	var nPos, nNeg, rankSum float64
+	for i, label := range y {
+		if label == 1 {
+			nPos++
+			rankSum += ranks[i]
+		} else {
+			nNeg++
+		}
+	}
+	if nPos == 0 || nNeg == 0 {
+		return math.NaN()
+	}
+	return (rankSum - nPos*(nPos+1)/2) / (nPos * nNeg)
+}
+
+func calibrationBins(scores, y []float64, nBins int) ([]CalibrationBin, float64) {
+	bins := make([]CalibrationBin, nBins)
+	for b := range bins {
+		bins[b].LowerBound = float64(b) / float64(nBins)
+		bins[b].UpperBound = float64(b+1) / float64(nBins)
+	}
+
+	for i, s := range scores {
+		b := int(s * float64(nBins))
+		if b >= nBins {
+			b = nBins - 1
+		}
+		bins[b].Count++
+		bins[b].MeanPredicted += s
+		bins[b].ObservedRate += y[i]
+	}
+
+	var ece float64
+	for b := range bins {
+		if bins[b].Count == 0 {
+			continue
+		}
+		bins[b].MeanPredicted /= float64(bins[b].Count)
+		bins[b].ObservedRate /= float64(bins[b].Count)
+		ece += float64(bins[b].Count) / float64(len(scores)) * math.Abs(bins[b].MeanPredicted-bins[b].ObservedRate)
+	}
+	return bins, ece
+}
+
+// Probabilidade de churn junto com a versão do modelo que a produziu
+type ChurnScore struct {
+	CustomerID   string       `json:"customer_id"`
+	Probability  float64      `json:"churn_probability"`
+	ModelVersion int          `json:"model_version"`
+	Explanation  *Explanation `json:"explanation,omitempty"`
+}
+
+func predictCustomerChurn(customerID string) (ChurnScore, error) {
+	registered, err := modelRegistry.Production(churnModelName)
+	if errors.Is(err, errModelNotFound) {
+		return ChurnScore{}, errChurnModelNotTrained
+	}
+	if err != nil {
+		return ChurnScore{}, err
+	}
+	if !hasProfilingConsent(customerID) {
+		return ChurnScore{}, errNoProfilingConsent
+	}
+
+	features, err := getOnlineFeatures(customerID)
+	if err != nil {
+		return ChurnScore{}, err
+	}
+	x, err := features.Vector(registered.Model.FeatureNames)
+	if err != nil {
+		return ChurnScore{}, err
+	}
+	return ChurnScore{
+		CustomerID:   customerID,
+		Probability:  registered.Model.Predict(x),
+		ModelVersion: registered.Version,
+		Explanation:  explainChurnModel(registered.Model, x),
+	}, nil
+}
+
+func churnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoProfilingConsent):
+		return http.StatusForbidden
+	case errors.Is(err, errChurnModelNotTrained):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errChurnSingleClass):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/floats"
+)
+
+func TestRocAUC(t *testing.T) {
+	assert.InDelta(t, 1.0, rocAUC([]float64{0.1, 0.2, 0.8, 0.9}, []float64{0, 0, 1, 1}), 1e-9)
+	assert.InDelta(t, 0.0, rocAUC([]float64{0.9, 0.8, 0.2, 0.1}, []float64{0, 0, 1, 1}), 1e-9)
+	assert.InDelta(t, 0.5, rocAUC([]float64{0.5, 0.5, 0.5, 0.5}, []float64{0, 1, 0, 1}), 1e-9)
+	assert.True(t, math.IsNaN(rocAUC([]float64{0.1, 0.2}, []float64{1, 1})))
+}
+
+func TestFitLogisticRegressionLearnsRecencyDirection(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	n := 600
+	X := make([][]float64, n)
+	y := make([]float64, n)
+	for i := range X {
+		X[i] = make([]float64, len(churnFeatureNames))
+		for j := range X[i] {
+			X[i][j] = rng.NormFloat64()
+		}
+		// Recência alta aumenta o churn, frequência alta diminui
+		X[i][0] = rng.Float64() * 180
+		logit := 0.03*(X[i][0]-90) - 1.5*X[i][1]
+		if rng.Float64() < sigmoid(logit) {
+			y[i] = 1
+		}
+	}
+
+	cfg := defaultChurnTrainingConfig
+	trainX, trainY, testX, testY := splitHoldout(X, y, cfg.HoldoutFraction, cfg.Seed)
+	assert.Len(t, testX, 120)
+	assert.Len(t, trainX, 480)
+	// Holdout estratificado: mesma taxa de churn do conjunto completo
+	assert.InDelta(t, floats.Sum(y)/600, floats.Sum(testY)/120, 0.01)
+
+	model := fitLogisticRegression(trainX, trainY, cfg)
+	assert.Greater(t, model.Weights[0], 0.0)
+	assert.Less(t, model.Weights[1], 0.0)
+
+	eval := evaluateChurnModel(model, testX, testY, cfg.Threshold)
+	if assert.NotNil(t, eval.AUC) {
+		assert.Greater(t, *eval.AUC, 0.8)
+	}
+	assert.Greater(t, eval.Precision, 0.6)
+	assert.Greater(t, eval.Recall, 0.6)
+	assert.Less(t, eval.ECE, 0.15)
+	assert.Len(t, eval.Calibration, 10)
+}
+
+func TestSplitHoldoutKeepsRareClassOnBothSides(t *testing.T) {
+	X := make([][]float64, 20)
+	y := make([]float64, 20)
+	for i := range X {
+		X[i] = []float64{float64(i)}
+	}
+	y[3], y[11] = 1, 1
+
+	_, trainY, _, testY := splitHoldout(X, y, 0.2, 1)
+	assert.Equal(t, 1.0, floats.Sum(testY))
+	assert.Equal(t, 1.0, floats.Sum(trainY))
+	assert.Len(t, testY, 5)
+}
+
+func TestApproximateShapleyRecoversAdditiveContributions(t *testing.T) {
+	// Para um modelo aditivo, o valor de Shapley é exatamente f_i(x_i) - f_i(b_i)
+	predict := func(x []float64) float64 { return 2*x[0] - 3*x[1] + x[2]*x[2] }
+	phi := approximateShapley(predict, []float64{1, 2, 3}, []float64{0, 0, 1}, 50, 7)
+
+	assert.InDelta(t, 2.0, phi[0], 1e-9)
+	assert.InDelta(t, -6.0, phi[1], 1e-9)
+	assert.InDelta(t, 8.0, phi[2], 1e-9)
+}
+
+func TestSameFeatureSchema(t *testing.T) {
+	assert.True(t, sameFeatureSchema([]string{"recency", "frequency"}, []string{"recency", "frequency"}))
+	// Mesma quantidade, ordem diferente: os pesos ficariam trocados
+	assert.False(t, sameFeatureSchema([]string{"frequency", "recency"}, []string{"recency", "frequency"}))
+	assert.False(t, sameFeatureSchema([]string{"recency"}, []string{"recency", "frequency"}))
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	churnScoringInterval = 24 * time.Hour
+	// Cruzar este limiar entre duas execuções gera alerta para o responsável
+	churnRiskAlertThreshold = 0.7
+	churnRiskDefaultPage    = 50
+	churnRiskMaxPage        = 500
+)
+
+func createChurnScoringTables() {
+	_, err := db.Exec(`
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS owner_id INTEGER;
+
+		CREATE TABLE IF NOT EXISTS churn_scoring_runs (
+			id BIGSERIAL PRIMARY KEY,
+			model_version INTEGER NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP,
+			scored INTEGER NOT NULL DEFAULT 0,
+			alerts INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE TABLE IF NOT EXISTS churn_scores (
+			id BIGSERIAL PRIMARY KEY,
+			run_id BIGINT REFERENCES churn_scoring_runs(id) ON DELETE CASCADE,
+			customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
+			probability DOUBLE PRECISION NOT NULL,
+			previous_probability DOUBLE PRECISION,
+			model_version INTEGER NOT NULL,
+			scored_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_churn_scores_customer ON churn_scores (customer_id, scored_at DESC);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Pontua todos os clientes ativos com o modelo em produção e guarda o
+// histórico. Clientes que cruzam o limiar de risco geram alerta.
+func runChurnBatchScoring(ctx context.Context) error {
+	registered, err := modelRegistry.Production(churnModelName)
+	if errors.Is(err, errModelNotFound) {
+		logger.Infof("Pontuação de churn ignorada: nenhum modelo em produção")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	startedAt := time.Now()
+	vectors, err := computeFeatureVectors(nil, startedAt)
+	if err != nil {
+		return err
+	}
+
+	previous, err := latestChurnScores()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var runID int64
+	err = tx.QueryRow(
+		"INSERT INTO churn_scoring_runs (model_version, started_at) VALUES ($1, $2) RETURNING id",
+		registered.Version, startedAt,
+	).Scan(&runID)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("churn_scores",
+		"run_id", "customer_id", "probability", "previous_probability", "model_version", "scored_at"))
+	if err != nil {
+		return err
+	}
+
+	var crossed []ChurnScore
+	scored := 0
+	for _, v := range vectors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Clientes sem nenhuma compra não são considerados ativos
+		if v.Get("total_purchases") == 0 {
+			continue
+		}
+		x, err := v.Vector(registered.Model.FeatureNames)
+		if err != nil {
+			return err
+		}
+		score := ChurnScore{
+			CustomerID:   v.CustomerID,
+			Probability:  registered.Model.Predict(x),
+			ModelVersion: registered.Version,
+		}
+
+		var prev interface{}
+		if p, ok := previous[v.CustomerID]; ok {
+			prev = p
+			if p < churnRiskAlertThreshold && score.Probability >= churnRiskAlertThreshold {
+				crossed = append(crossed, score)
+			}
+		}
+		if _, err := stmt.Exec(runID, v.CustomerID, score.Probability, prev, score.ModelVersion, startedAt); err != nil {
+			return err
+		}
+		scored++
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE churn_scoring_runs SET finished_at = CURRENT_TIMESTAMP, scored = $1, alerts = $2 WHERE id = $3",
+		scored, len(crossed), runID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, score := range crossed {
+		notifyChurnRiskIncreased(score, previous[score.CustomerID])
+	}
+	logger.Infof("Pontuação de churn v%d: %d clientes, %d alertas", registered.Version, scored, len(crossed))
+	return nil
+}
+
+// Última probabilidade registrada de cada cliente
+func latestChurnScores() (map[string]float64, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (customer_id) customer_id::text, probability
+		FROM churn_scores
+		ORDER BY customer_id, scored_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[string]float64)
+	for rows.Next() {
+		var id string
+		var probability float64
+		if err := rows.Scan(&id, &probability); err != nil {
+			return nil, err
+		}
+		scores[id] = probability
+	}
+	return scores, rows.Err()
+}
+
+func notifyChurnRiskIncreased(score ChurnScore, previous float64) {
+	var ownerID sql.NullString
+	var name, tenantID string
+	err := db.QueryRow("SELECT name, tenant_id, owner_id::text FROM customers WHERE id = $1", score.CustomerID).Scan(&name, &tenantID, &ownerID)
+	if err != nil {
+		logger.Errorf("Falha ao buscar responsável do cliente %s: %v", score.CustomerID, err)
+		return
+	}
+
+	event := gin.H{
+		"customer_id":          score.CustomerID,
+		"customer_name":        name,
+		"churn_probability":    score.Probability,
+		"previous_probability": previous,
+		"threshold":            churnRiskAlertThreshold,
+		"model_version":        score.ModelVersion,
+	}
+	realtimeHub.Publish(tenantID, customerTopic(score.CustomerID), "churn_risk_increased", event)
+	if ownerID.Valid {
+		realtimeHub.Publish(tenantID, userTopic(ownerID.String), "churn_risk_increased", event)
+	}
+}
+
+// Remove o histórico de pontuação do cliente (revogação de perfilamento)
+func forgetChurnScores(customerID string) {
+	_, err := db.Exec("DELETE FROM churn_scores WHERE customer_id::text = $1", customerID)
+	if err != nil {
+		logger.Errorf("Falha ao remover pontuações de churn do cliente %s: %v", customerID, err)
+	}
+}
+
+// Lista os clientes em risco segundo a última pontuação em lote
+func listChurnRiskCustomers(c *gin.Context) {
+	threshold := churnRiskAlertThreshold
+	if value := c.Query("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold deve estar entre 0 e 1"})
+			return
+		}
+		threshold = parsed
+	}
+
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(churnRiskDefaultPage)))
+	if pageSize < 1 || pageSize > churnRiskMaxPage {
+		pageSize = churnRiskDefaultPage
+	}
+
+	rows, err := db.Query(`
+		WITH latest AS (
+			SELECT DISTINCT ON (customer_id) customer_id, probability, previous_probability, model_version, scored_at
+			FROM churn_scores
+			ORDER BY customer_id, scored_at DESC
+		)
+		SELECT l.customer_id::text, c.name, c.owner_id::text, l.probability, l.previous_probability,
+			l.model_version, l.scored_at, COUNT(*) OVER ()
+		FROM latest l
+		JOIN customers c ON c.id = l.customer_id
+		WHERE l.probability >= $1 AND c.tenant_id = $2 AND c.anonymized_at IS NULL
+			AND ($3 = '' OR c.owner_id::text = $3)
+		ORDER BY l.probability DESC, l.customer_id
+		LIMIT $4 OFFSET $5`,
+		threshold, tenantFromContext(c), owner, pageSize, (page-1)*pageSize,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar clientes em risco"})
+		return
+	}
+	defer rows.Close()
+
+	customers := []gin.H{}
+	total := 0
+	for rows.Next() {
+		var id, name string
+		var ownerID sql.NullString
+		var probability float64
+		var previous sql.NullFloat64
+		var modelVersion int
+		var scoredAt time.Time
+		if err := rows.Scan(&id, &name, &ownerID, &probability, &previous, &modelVersion, &scoredAt, &total); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler clientes em risco"})
+			return
+		}
+
+		entry := gin.H{
+			"customer_id":       id,
+			"name":              name,
+			"owner_id":          nil,
+			"churn_probability": probability,
+			"change":            nil,
+			"model_version":     modelVersion,
+			"scored_at":         scoredAt,
+		}
+		if ownerID.Valid {
+			entry["owner_id"] = ownerID.String
+		}
+		if previous.Valid {
+			entry["change"] = probability - previous.Float64
+		}
+		customers = append(customers, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"threshold": threshold,
+		"page":      page,
+		"page_size": pageSize,
+		"total":     total,
+		"customers": customers,
+	})
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Finalidades de tratamento que exigem consentimento registrado
+const (
+	consentMarketingEmail = "marketing_email"
+	consentSMS            = "sms"
+	consentWhatsApp       = "whatsapp"
+	consentProfiling      = "profiling"
+)
+
+var consentPurposes = []string{consentMarketingEmail, consentSMS, consentWhatsApp, consentProfiling}
+
+// Bases legais do art. 7º da LGPD aceitas no registro
+var consentLegalBases = []string{"consent", "contract", "legal_obligation", "legitimate_interest"}
+
+// Canal de contato -> finalidade exigida para o envio
+var outreachChannelPurposes = map[string]string{
+	"email":    consentMarketingEmail,
+	"sms":      consentSMS,
+	"whatsapp": consentWhatsApp,
+}
+
+const consentCacheDuration = time.Minute
+
+type ConsentRecord struct {
+	ID           int64      `json:"id"`
+	CustomerID   string     `json:"customer_id"`
+	Purpose      string     `json:"purpose"`
+	LegalBasis   string     `json:"legal_basis"`
+	Source       string     `json:"source"`
+	RecordedBy   string     `json:"recorded_by"`
+	GrantedAt    time.Time  `json:"granted_at"`
+	WithdrawnAt  *time.Time `json:"withdrawn_at,omitempty"`
+	SupersededAt *time.Time `json:"superseded_at,omitempty"`
+	Active       bool       `json:"active"`
+}
+
+func createConsentTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS customer_consents (
+			id BIGSERIAL PRIMARY KEY,
+			customer_id INTEGER NOT NULL REFERENCES customers(id),
+			purpose VARCHAR(30) NOT NULL,
+			legal_basis VARCHAR(30) NOT NULL,
+			source VARCHAR(100) NOT NULL,
+			recorded_by VARCHAR(100) NOT NULL,
+			granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			withdrawn_at TIMESTAMP
+		);
+
+		-- Registro substituído por um novo consentimento da mesma finalidade,
+		-- distinto de uma revogação pelo titular
+		ALTER TABLE customer_consents ADD COLUMN IF NOT EXISTS superseded_at TIMESTAMP;
+
+		CREATE INDEX IF NOT EXISTS idx_customer_consents_lookup ON customer_consents (customer_id, purpose, granted_at DESC);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func listCustomerConsents(c *gin.Context) {
+	customerID := c.Param("id")
+	if !customerExists(tenantFromContext(c), customerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, customer_id, purpose, legal_basis, source, recorded_by, granted_at, withdrawn_at, superseded_at
+		FROM customer_consents
+		WHERE customer_id::text = $1 AND customer_id IN (SELECT id FROM customers WHERE tenant_id = $2)
+		ORDER BY granted_at DESC`,
+		customerID, tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar consentimentos"})
+		return
+	}
+	defer rows.Close()
+
+	history := []ConsentRecord{}
+	current := gin.H{}
+	for _, purpose := range consentPurposes {
+		current[purpose] = false
+	}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var r ConsentRecord
+		var withdrawnAt, supersededAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.CustomerID, &r.Purpose, &r.LegalBasis, &r.Source, &r.RecordedBy, &r.GrantedAt, &withdrawnAt, &supersededAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler consentimento"})
+			return
+		}
+		if withdrawnAt.Valid {
+			r.WithdrawnAt = &withdrawnAt.Time
+		}
+		if supersededAt.Valid {
+			r.SupersededAt = &supersededAt.Time
+		}
+		r.Active = !withdrawnAt.Valid && !supersededAt.Valid
+		if !seen[r.Purpose] {
+			seen[r.Purpose] = true
+			current[r.Purpose] = r.Active
+		}
+		history = append(history, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": customerID,
+		"current":     current,
+		"history":     history,
+	})
+}
+
+func grantCustomerConsent(c *gin.Context) {
+	customerID := c.Param("id")
+	var request struct {
+		Purpose    string `json:"purpose" binding:"required"`
+		LegalBasis string `json:"legal_basis" binding:"required"`
+		Source     string `json:"source" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !contains(consentPurposes, request.Purpose) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Finalidade inválida", "valid_purposes": consentPurposes})
+		return
+	}
+	if !contains(consentLegalBases, request.LegalBasis) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Base legal inválida", "valid_legal_bases": consentLegalBases})
+		return
+	}
+
+	if !customerExists(tenantFromContext(c), customerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	actor := c.GetString("user_id")
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar consentimento"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Um novo registro substitui o anterior, mantendo o histórico como prova
+	_, err = tx.Exec(`
+		UPDATE customer_consents SET superseded_at = CURRENT_TIMESTAMP
+		WHERE customer_id::text = $1 AND purpose = $2 AND withdrawn_at IS NULL AND superseded_at IS NULL
+			AND customer_id IN (SELECT id FROM customers WHERE tenant_id = $3)`,
+		customerID, request.Purpose, tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar consentimento"})
+		return
+	}
+
+	var id int64
+	err = tx.QueryRow(
+		"INSERT INTO customer_consents (customer_id, purpose, legal_basis, source, recorded_by) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		customerID, request.Purpose, request.LegalBasis, request.Source, actor,
+	).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar consentimento"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar consentimento"})
+		return
+	}
+
+	invalidateConsentCache(customerID, request.Purpose)
+	recordAudit(tenantFromContext(c), actor, "consent_granted", "customer", customerID, gin.H{
+		"consent_id":  id,
+		"purpose":     request.Purpose,
+		"legal_basis": request.LegalBasis,
+		"source":      request.Source,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Consentimento registrado com sucesso"})
+}
+
+func withdrawCustomerConsent(c *gin.Context) {
+	customerID := c.Param("id")
+	purpose := c.Param("purpose")
+	actor := c.GetString("user_id")
+
+	if !contains(consentPurposes, purpose) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Finalidade inválida", "valid_purposes": consentPurposes})
+		return
+	}
+	if !customerExists(tenantFromContext(c), customerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE customer_consents SET withdrawn_at = CURRENT_TIMESTAMP
+		WHERE customer_id::text = $1 AND purpose = $2 AND withdrawn_at IS NULL AND superseded_at IS NULL
+			AND customer_id IN (SELECT id FROM customers WHERE tenant_id = $3)`,
+		customerID, purpose, tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao revogar consentimento"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nenhum consentimento ativo para esta finalidade"})
+		return
+	}
+
+	invalidateConsentCache(customerID, purpose)
+	if purpose == consentProfiling {
+		forgetOnlineFeatures(customerID)
+		forgetChurnScores(customerID)
+		forgetLifetimeValueScores(customerID)
+		globalCache.Delete(rfmCacheKey(tenantFromContext(c)))
+	}
+	recordAudit(tenantFromContext(c), actor, "consent_withdrawn", "customer", customerID, gin.H{"purpose": purpose})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Consentimento revogado com sucesso"})
+}
+
+// Consulta o status de consentimento de vários clientes de uma vez. Com
+// channel, a resposta traz também os clientes que podem ser contatados pelo
+// canal, lista que os envios de campanha devem usar como destinatários.
+func getBulkConsentStatus(c *gin.Context) {
+	var request struct {
+		CustomerIDs []string `json:"customer_ids" binding:"required"`
+		Purposes    []string `json:"purposes"`
+		Channel     string   `json:"channel"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(request.CustomerIDs) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Máximo de 1000 clientes por consulta"})
+		return
+	}
+	if len(request.Purposes) == 0 {
+		request.Purposes = consentPurposes
+	}
+	channelPurpose, ok := outreachChannelPurposes[request.Channel]
+	if request.Channel != "" {
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Canal de contato desconhecido: " + request.Channel})
+			return
+		}
+		if !contains(request.Purposes, channelPurpose) {
+			request.Purposes = append(request.Purposes, channelPurpose)
+		}
+	}
+
+	status, err := loadConsentStatus(tenantFromContext(c), request.CustomerIDs, request.Purposes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao consultar consentimentos"})
+		return
+	}
+
+	response := gin.H{"consents": status}
+	if request.Channel != "" {
+		response["contactable"] = contactableCustomers(request.CustomerIDs, status, channelPurpose)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Retorna customer_id -> finalidade -> consentimento ativo. Clientes de
+// outro tenant aparecem sem consentimento; tenantID vazio é usado apenas
+// pelas verificações internas, que já partem de um cliente resolvido.
+func loadConsentStatus(tenantID string, customerIDs, purposes []string) (map[string]map[string]bool, error) {
+	status := make(map[string]map[string]bool, len(customerIDs))
+	for _, id := range customerIDs {
+		status[id] = make(map[string]bool, len(purposes))
+		for _, purpose := range purposes {
+			status[id][purpose] = false
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (customer_id, purpose) customer_id, purpose, withdrawn_at IS NULL AND superseded_at IS NULL
+		FROM customer_consents
+		WHERE customer_id::text = ANY($1) AND purpose = ANY($2)
+			AND ($3 = '' OR customer_id IN (SELECT id FROM customers WHERE tenant_id = $3))
+		ORDER BY customer_id, purpose, granted_at DESC`,
+		pq.Array(customerIDs), pq.Array(purposes), tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var customerID, purpose string
+		var active bool
+		if err := rows.Scan(&customerID, &purpose, &active); err != nil {
+			return nil, err
+		}
+		if _, ok := status[customerID]; ok {
+			status[customerID][purpose] = active
+		}
+	}
+	return status, rows.Err()
+}
+
+func consentCacheKey(customerID, purpose string) string {
+	return fmt.Sprintf("consent:%s:%s", customerID, purpose)
+}
+
+func invalidateConsentCache(customerID, purpose string) {
+	globalCache.Delete(consentCacheKey(customerID, purpose))
+}
+
+// Verifica se o cliente possui consentimento ativo para a finalidade.
+// Na dúvida (erro de consulta), o tratamento é negado.
+func hasConsent(customerID, purpose string) bool {
+	key := consentCacheKey(customerID, purpose)
+	if value, found := globalCache.Get(key); found {
+		return value.(bool)
+	}
+
+	status, err := loadConsentStatus("", []string{customerID}, []string{purpose})
+	if err != nil {
+		logger.Errorf("Falha ao verificar consentimento de %s para %s: %v", customerID, purpose, err)
+		return false
+	}
+
+	granted := status[customerID][purpose]
+	globalCache.Set(key, granted, consentCacheDuration)
+	return granted
+}
+
+func hasProfilingConsent(customerID string) bool {
+	return hasConsent(customerID, consentProfiling)
+}
+
+// Mantém, na ordem recebida, apenas os clientes com consentimento ativo para a finalidade
+func contactableCustomers(customerIDs []string, status map[string]map[string]bool, purpose string) []string {
+	allowed := make([]string, 0, len(customerIDs))
+	for _, id := range customerIDs {
+		if status[id][purpose] {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed
+}
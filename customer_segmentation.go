@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	segmentationCacheDuration = time.Hour
+	defaultCohortMonths       = 12
+	maxCohortMonths           = 36
+)
+
+// Segmentos RFM na ordem de exibição, com o nome usado pelo time comercial
+var rfmSegmentOrder = []string{
+	"champions", "loyal", "potential_loyalists", "new_customers", "promising",
+	"need_attention", "about_to_sleep", "at_risk", "cant_lose", "hibernating", "lost",
+}
+
+var rfmSegmentLabels = map[string]string{
+	"champions":           "Champions",
+	"loyal":               "Loyal Customers",
+	"potential_loyalists": "Potential Loyalists",
+	"new_customers":       "New Customers",
+	"promising":           "Promising",
+	"need_attention":      "Need Attention",
+	"about_to_sleep":      "About to Sleep",
+	"at_risk":             "At Risk",
+	"cant_lose":           "Can't Lose Them",
+	"hibernating":         "Hibernating",
+	"lost":                "Lost",
+}
+
+type RFMScore struct {
+	CustomerID  string  `json:"customer_id"`
+	RecencyDays float64 `json:"recency_days"`
+	Frequency   float64 `json:"frequency"`
+	Monetary    float64 `json:"monetary"`
+	R           int     `json:"r"`
+	F           int     `json:"f"`
+	M           int     `json:"m"`
+	Segment     string  `json:"segment"`
+}
+
+type RFMSegmentSummary struct {
+	Segment            string  `json:"segment"`
+	Label              string  `json:"label"`
+	Customers          int     `json:"customers"`
+	Share              float64 `json:"share"`
+	Revenue            float64 `json:"revenue"`
+	AverageRecencyDays float64 `json:"average_recency_days" graphql:"averageRecencyDays"`
+	AverageFrequency   float64 `json:"average_frequency" graphql:"averageFrequency"`
+	AverageMonetary    float64 `json:"average_monetary" graphql:"averageMonetary"`
+}
+
+type RFMAnalysis struct {
+	Customers  int                 `json:"customers"`
+	ComputedAt time.Time           `json:"computed_at" graphql:"computedAt"`
+	Segments   []RFMSegmentSummary `json:"segments"`
+	scores     []RFMScore
+}
+
+// Notas de 1 a 5 pela posição no ranking (quintis). Valores empatados
+// recebem a mesma nota; com lowerIsBetter a escala é invertida.
+func quintileScores(values []float64, lowerIsBetter bool) []int {
+	n := len(values)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	scores := make([]int, n)
+	first := 0
+	for position, index := range order {
+		if position > 0 && values[index] != values[order[position-1]] {
+			first = position
+		}
+		score := 1 + 5*first/n
+		if lowerIsBetter {
+			score = 6 - score
+		}
+		scores[index] = score
+	}
+	return scores
+}
+
+// Grade clássica de recência contra a média de frequência e valor
+func rfmSegment(r, f, m int) string {
+	fm := (f + m + 1) / 2
+	switch {
+	case r >= 4 && fm >= 4:
+		return "champions"
+	case r == 5 && fm == 1:
+		return "new_customers"
+	case r == 4 && fm == 1:
+		return "promising"
+	case r >= 4:
+		return "potential_loyalists"
+	case r == 3 && fm >= 4:
+		return "loyal"
+	case r == 3 && fm == 3:
+		return "need_attention"
+	case r == 3:
+		return "about_to_sleep"
+	case fm == 5:
+		return "cant_lose"
+	case fm >= 3:
+		return "at_risk"
+	case r == 1 && fm == 1:
+		return "lost"
+	default:
+		return "hibernating"
+	}
+}
+
+func scoreRFM(scores []RFMScore) {
+	recency := make([]float64, len(scores))
+	frequency := make([]float64, len(scores))
+	monetary := make([]float64, len(scores))
+	for i, s := range scores {
+		recency[i], frequency[i], monetary[i] = s.RecencyDays, s.Frequency, s.Monetary
+	}
+	r := quintileScores(recency, true)
+	f := quintileScores(frequency, false)
+	m := quintileScores(monetary, false)
+	for i := range scores {
+		scores[i].R, scores[i].F, scores[i].M = r[i], f[i], m[i]
+		scores[i].Segment = rfmSegment(r[i], f[i], m[i])
+	}
+}
+
+func summarizeRFM(scores []RFMScore) []RFMSegmentSummary {
+	bySegment := map[string]*RFMSegmentSummary{}
+	for _, s := range scores {
+		summary, ok := bySegment[s.Segment]
+		if !ok {
+			summary = &RFMSegmentSummary{Segment: s.Segment, Label: rfmSegmentLabels[s.Segment]}
+			bySegment[s.Segment] = summary
+		}
+		summary.Customers++
+		summary.Revenue += s.Monetary
+		summary.AverageRecencyDays += s.RecencyDays
+		summary.AverageFrequency += s.Frequency
+	}
+
+	summaries := make([]RFMSegmentSummary, 0, len(rfmSegmentOrder))
+	for _, segment := range rfmSegmentOrder {
+		summary, ok := bySegment[segment]
+		if !ok {
+			summary = &RFMSegmentSummary{Segment: segment, Label: rfmSegmentLabels[segment]}
+		} else {
+			n := float64(summary.Customers)
+			summary.Share = n / float64(len(scores))
+			summary.AverageMonetary = summary.Revenue / n
+			summary.AverageRecencyDays /= n
+			summary.AverageFrequency /= n
+		}
+		summaries = append(summaries, *summary)
+	}
+	return summaries
+}
+
+func rfmCacheKey(tenantID string) string {
+	return "rfm:" + tenantID
+}
+
+// Segmentação RFM do tenant a partir das features online (recência, total
+// de compras e receita), apenas clientes com compras e consentimento ativo
+// para perfilamento
+func computeRFMAnalysis(tenantID string) (*RFMAnalysis, error) {
+	key := rfmCacheKey(tenantID)
+	if value, found := globalCache.Get(key); found {
+		return value.(*RFMAnalysis), nil
+	}
+
+	rows, err := db.Query(`
+		SELECT f.customer_id::text,
+			(f.features->>'recency_days')::float8,
+			(f.features->>'total_purchases')::float8,
+			(f.features->>'total_revenue')::float8
+		FROM customer_features_online f
+		JOIN customers c ON c.id = f.customer_id
+		WHERE c.tenant_id = $1 AND c.anonymized_at IS NULL
+			AND (f.features->>'total_purchases')::float8 > 0
+			AND (
+				SELECT withdrawn_at IS NULL AND superseded_at IS NULL FROM customer_consents
+				WHERE customer_id = c.id AND purpose = $2
+				ORDER BY granted_at DESC LIMIT 1
+			)`,
+		tenantID, consentProfiling,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scores []RFMScore
+	for rows.Next() {
+		var s RFMScore
+		if err := rows.Scan(&s.CustomerID, &s.RecencyDays, &s.Frequency, &s.Monetary); err != nil {
+			return nil, err
+		}
+		scores = append(scores, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scoreRFM(scores)
+	analysis := &RFMAnalysis{
+		Customers:  len(scores),
+		ComputedAt: time.Now(),
+		Segments:   summarizeRFM(scores),
+		scores:     scores,
+	}
+	globalCache.Set(key, analysis, segmentationCacheDuration)
+	return analysis, nil
+}
+
+// Resumo por segmento; com ?segment= lista também os clientes do segmento
+func getRFMSegmentation(c *gin.Context) {
+	segment := c.Query("segment")
+	if _, ok := rfmSegmentLabels[segment]; segment != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Segmento inválido"})
+		return
+	}
+
+	analysis, err := computeRFMAnalysis(tenantFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular segmentação RFM"})
+		return
+	}
+	if segment == "" {
+		c.JSON(http.StatusOK, analysis)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 50
+	}
+
+	members := []RFMScore{}
+	for _, s := range analysis.scores {
+		if s.Segment == segment {
+			members = append(members, s)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Monetary > members[j].Monetary })
+	total := len(members)
+	start := (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"segment":     segment,
+		"label":       rfmSegmentLabels[segment],
+		"computed_at": analysis.ComputedAt,
+		"page":        page,
+		"page_size":   pageSize,
+		"total":       total,
+		"customers":   members[start:end],
+	})
+}
+
+type CohortRow struct {
+	Cohort    string    `json:"cohort"`
+	Customers int       `json:"customers"`
+	Retention []float64 `json:"retention"`
+	Revenue   []float64 `json:"revenue"`
+	// Receita acumulada por cliente da coorte até cada mês
+	CumulativeRevenuePerCustomer []float64 `json:"cumulative_revenue_per_customer" graphql:"cumulativeRevenuePerCustomer"`
+}
+
+type CohortAnalysis struct {
+	Months     int         `json:"months"`
+	ComputedAt time.Time   `json:"computed_at" graphql:"computedAt"`
+	Cohorts    []CohortRow `json:"cohorts"`
+}
+
+type cohortCell struct {
+	Offset  int
+	Active  int
+	Revenue float64
+}
+
+// Monta as linhas da matriz: o mês 0 é o de aquisição e cada coorte vai
+// até o mês corrente
+func buildCohortRow(cohort time.Time, customers int, cells []cohortCell, now time.Time) CohortRow {
+	elapsed := (now.Year()-cohort.Year())*12 + int(now.Month()) - int(cohort.Month())
+	row := CohortRow{
+		Cohort:                       cohort.Format("2006-01"),
+		Customers:                    customers,
+		Retention:                    make([]float64, elapsed+1),
+		Revenue:                      make([]float64, elapsed+1),
+		CumulativeRevenuePerCustomer: make([]float64, elapsed+1),
+	}
+	for _, cell := range cells {
+		if cell.Offset < 0 || cell.Offset > elapsed {
+			continue
+		}
+		if customers > 0 {
+			row.Retention[cell.Offset] = float64(cell.Active) / float64(customers)
+		}
+		row.Revenue[cell.Offset] = cell.Revenue
+	}
+
+	cumulative := 0.0
+	for k, revenue := range row.Revenue {
+		cumulative += revenue
+		if customers > 0 {
+			row.CumulativeRevenuePerCustomer[k] = cumulative / float64(customers)
+		}
+	}
+	return row
+}
+
+// Matrizes de retenção e receita por mês de aquisição (customers.created_at)
+func computeCohortAnalysis(tenantID string, months int) (*CohortAnalysis, error) {
+	key := fmt.Sprintf("cohorts:%s:%d", tenantID, months)
+	if value, found := globalCache.Get(key); found {
+		return value.(*CohortAnalysis), nil
+	}
+
+	now := time.Now()
+	since := truncatePeriod(now, "month").AddDate(0, -(months - 1), 0)
+	rows, err := db.Query(`
+		WITH cohort AS (
+			SELECT id, date_trunc('month', created_at) AS month
+			FROM customers
+			WHERE tenant_id = $1 AND created_at >= $2
+		), sizes AS (
+			SELECT month, COUNT(*) AS customers FROM cohort GROUP BY month
+		), activity AS (
+			SELECT c.month,
+				((date_part('year', s.date) - date_part('year', c.month)) * 12
+					+ date_part('month', s.date) - date_part('month', c.month))::int AS month_offset,
+				COUNT(DISTINCT s.customer_id) AS active,
+				SUM(s.amount) AS revenue
+			FROM cohort c
+			JOIN sales s ON s.customer_id = c.id AND s.date >= c.month
+			GROUP BY 1, 2
+		)
+		SELECT sz.month, sz.customers, a.month_offset, COALESCE(a.active, 0), COALESCE(a.revenue, 0)
+		FROM sizes sz
+		LEFT JOIN activity a ON a.month = sz.month
+		ORDER BY sz.month, a.month_offset`,
+		tenantID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cohortMonths []time.Time
+	sizes := map[time.Time]int{}
+	cells := map[time.Time][]cohortCell{}
+	for rows.Next() {
+		var month time.Time
+		var customers int
+		var offset *int
+		var cell cohortCell
+		if err := rows.Scan(&month, &customers, &offset, &cell.Active, &cell.Revenue); err != nil {
+			return nil, err
+		}
+		if _, ok := sizes[month]; !ok {
+			cohortMonths = append(cohortMonths, month)
+			sizes[month] = customers
+		}
+		if offset != nil {
+			cell.Offset = *offset
+			cells[month] = append(cells[month], cell)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	analysis := &CohortAnalysis{Months: months, ComputedAt: now, Cohorts: []CohortRow{}}
+	for _, month := range cohortMonths {
+		analysis.Cohorts = append(analysis.Cohorts, buildCohortRow(month, sizes[month], cells[month], now))
+	}
+	globalCache.Set(key, analysis, segmentationCacheDuration)
+	return analysis, nil
+}
+
+func parseCohortMonths(value string) (int, error) {
+	if value == "" {
+		return defaultCohortMonths, nil
+	}
+	months, err := strconv.Atoi(value)
+	if err != nil || months < 1 || months > maxCohortMonths {
+		return 0, fmt.Errorf("months deve estar entre 1 e %d", maxCohortMonths)
+	}
+	return months, nil
+}
+
+func getCohortAnalysis(c *gin.Context) {
+	months, err := parseCohortMonths(c.Query("months"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	analysis, err := computeCohortAnalysis(tenantFromContext(c), months)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular coortes"})
+		return
+	}
+	c.JSON(http.StatusOK, analysis)
+}
+
+// Bloco do dashboard com a distribuição RFM e a retenção das coortes
+func getDashboardSegments(c *gin.Context) {
+	tenantID := tenantFromContext(c)
+	rfm, err := computeRFMAnalysis(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular segmentação RFM"})
+		return
+	}
+	cohorts, err := computeCohortAnalysis(tenantID, defaultCohortMonths)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular coortes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rfm": rfm, "cohorts": cohorts})
+}
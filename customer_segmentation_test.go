@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuintileScores(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	assert.Equal(t, []int{1, 1, 2, 2, 3, 3, 4, 4, 5, 5}, quintileScores(values, false))
+	assert.Equal(t, []int{5, 5, 4, 4, 3, 3, 2, 2, 1, 1}, quintileScores(values, true))
+
+	// Empates recebem a mesma nota
+	assert.Equal(t, []int{1, 1, 1, 1, 5}, quintileScores([]float64{1, 1, 1, 1, 9}, false))
+}
+
+func TestRFMSegment(t *testing.T) {
+	assert.Equal(t, "champions", rfmSegment(5, 5, 4))
+	assert.Equal(t, "new_customers", rfmSegment(5, 1, 1))
+	assert.Equal(t, "at_risk", rfmSegment(2, 4, 3))
+	assert.Equal(t, "cant_lose", rfmSegment(1, 5, 5))
+	assert.Equal(t, "hibernating", rfmSegment(2, 1, 2))
+	assert.Equal(t, "lost", rfmSegment(1, 1, 1))
+}
+
+func TestBuildCohortRow(t *testing.T) {
+	cohort := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	row := buildCohortRow(cohort, 10, []cohortCell{
+		{Offset: 0, Active: 10, Revenue: 1000},
+		{Offset: 2, Active: 4, Revenue: 600},
+	}, now)
+
+	assert.Equal(t, "2026-07", row.Cohort)
+	assert.Equal(t, []float64{1, 0, 0.4, 0}, row.Retention)
+	assert.Equal(t, []float64{100, 100, 160, 160}, row.CumulativeRevenuePerCustomer)
+}
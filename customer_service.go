@@ -6,49 +6,15 @@ import (
 	"gonum.org/v1/gonum/mat"
 	"github.com/sirupsen/logrus"
 	"database/sql"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"strconv"
 	"your-project/logger"
 	"your-project/validator"
 	"your-project/auth"
 	"github.com/google/uuid"
 	"time"
-	"math"
 )
 
-type ChurnModel struct{}
-
-func (m *ChurnModel) Predict(features []float64) float64 {
-	// Modelo simplificado de previsão de churn
-	// Baseado em: recência da última compra, frequência de compras e valor monetário
-	recency := features[0]
-	frequency := features[1]
-	monetary := features[2]
-
-	score := 0.3*recency + 0.3*frequency + 0.4*monetary
-	return 1 / (1 + math.Exp(-score))
-}
-
-var churnModel = &ChurnModel{}
-
-func predictCustomerChurn(customerID string) float64 {
-	// Obter dados do cliente
-	customerData := getCustomerData(customerID)
-
-	// Extrair features
-	recency := float64(customerData["days_since_last_purchase"].(int))
-	frequency := float64(customerData["total_purchases"].(int)) / float64(customerData["months_active"].(int))
-	monetary := customerData["average_purchase_value"].(float64)
-
-	// Normalizar features (simplificado)
-	recency = recency / 365 // Assumindo que 365 dias é o máximo
-	frequency = frequency / 10 // Assumindo que 10 compras por mês é o máximo
-	monetary = monetary / 1000 // Assumindo que $1000 é o valor máximo de compra
-
-	features := []float64{recency, frequency, monetary}
-	return churnModel.Predict(features)
-}
-
 var db *sql.DB
 
 func initDB() {
@@ -95,16 +61,28 @@ func setupCustomerRoutes(r *gin.Engine) {
 	{
 		customerGroup.GET("", listCustomers)
 		customerGroup.POST("", createCustomer)
+		customerGroup.GET("/lookup", lookupCustomers)
 		customerGroup.GET("/:id", getCustomer)
 		customerGroup.PUT("/:id", updateCustomer)
-		customerGroup.DELETE("/:id", deleteCustomer)
+		customerGroup.PUT("/:id/owner", assignCustomerOwner)
+		customerGroup.PUT("/:id/lifecycle", setCustomerLifecycleStage)
+		customerGroup.DELETE("/:id", roleAuthorization("admin", "dpo"), deleteCustomer)
 		customerGroup.GET("/:id/insights", getCustomerInsights)
+		customerGroup.GET("/:id/churn", getChurnPrediction)
+		customerGroup.GET("/:id/lifetime-value", getLifetimeValue)
 		customerGroup.POST("/:id/interaction", recordCustomerInteraction)
+		customerGroup.GET("/:id/personal-data", roleAuthorization("admin", "dpo"), exportCustomerPersonalData)
+		customerGroup.POST("/:id/erase", roleAuthorization("admin", "dpo"), eraseCustomerPersonalData)
+		customerGroup.GET("/:id/consents", listCustomerConsents)
+		customerGroup.POST("/:id/consents", grantCustomerConsent)
+		customerGroup.DELETE("/:id/consents/:purpose", withdrawCustomerConsent)
+		customerGroup.GET("/:id/custom-fields", listCustomerCustomFields)
+		customerGroup.PUT("/:id/custom-fields", setCustomerCustomField)
 	}
 }
 
 func listCustomers(c *gin.Context) {
-	rows, err := db.Query("SELECT id, name, email FROM customers")
+	rows, err := db.Query("SELECT id, name, email, email_enc FROM customers WHERE tenant_id = $1", tenantFromContext(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar clientes"})
 		return
@@ -113,12 +91,13 @@ func listCustomers(c *gin.Context) {
 
 	var customers []gin.H
 	for rows.Next() {
-		var id, name, email string
-		if err := rows.Scan(&id, &name, &email); err != nil {
+		var id, name string
+		var email, emailEnc sql.NullString
+		if err := rows.Scan(&id, &name, &email, &emailEnc); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler dados do cliente"})
 			return
 		}
-		customers = append(customers, gin.H{"id": id, "name": name, "email": email})
+		customers = append(customers, gin.H{"id": id, "name": name, "email": readProtectedField(emailEnc, email)})
 	}
 
 	c.JSON(http.StatusOK, customers)
@@ -128,6 +107,8 @@ func createCustomer(c *gin.Context) {
 	var newCustomer struct {
 		Name  string `json:"name" binding:"required"`
 		Email string `json:"email" binding:"required"`
+		Phone string `json:"phone"`
+		TaxID string `json:"tax_id"`
 	}
 
 	if err := c.ShouldBindJSON(&newCustomer); err != nil {
@@ -135,13 +116,37 @@ func createCustomer(c *gin.Context) {
 		return
 	}
 
+	if newCustomer.TaxID != "" && !validator.IsValidTaxID(newCustomer.TaxID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CPF/CNPJ inválido"})
+		return
+	}
+
+	// Email, telefone e CPF/CNPJ são gravados apenas cifrados
+	pii, err := protectCustomerPII(newCustomer.Email, newCustomer.Phone, newCustomer.TaxID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao proteger dados do cliente"})
+		return
+	}
+
 	var id string
-	err := db.QueryRow("INSERT INTO customers (name, email) VALUES ($1, $2) RETURNING id", newCustomer.Name, newCustomer.Email).Scan(&id)
+	err = db.QueryRow(`
+		INSERT INTO customers (name, tenant_id, email_enc, email_bidx, phone_enc, phone_bidx, tax_id_enc, tax_id_bidx)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''))
+		RETURNING id`,
+		newCustomer.Name, tenantFromContext(c),
+		pii.EmailEnc, pii.EmailBidx, pii.PhoneEnc, pii.PhoneBidx, pii.TaxIDEnc, pii.TaxIDBidx,
+	).Scan(&id)
+	// O índice cego do email é único por tenant
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Já existe um cliente com esse email"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar cliente"})
 		return
 	}
 
+	metricsAggregator.Record(DomainEvent{Type: eventCustomerCreated, TenantID: tenantFromContext(c), UserID: c.GetString("user_id")})
 	c.JSON(http.StatusCreated, gin.H{"id": id, "name": newCustomer.Name, "email": newCustomer.Email})
 }
 
@@ -150,13 +155,33 @@ type Customer struct {
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Phone     string    `json:"phone"`
+	TaxID     string    `json:"tax_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+func fetchCustomerFromDB(tenantID, id string) (Customer, error) {
+	var customer Customer
+	var email, emailEnc, phone, phoneEnc, taxID, taxIDEnc sql.NullString
+	err := db.QueryRow(`
+		SELECT id, name, email, email_enc, phone, phone_enc, tax_id, tax_id_enc, created_at
+		FROM customers WHERE id::text = $1 AND tenant_id = $2`,
+		id, tenantID,
+	).Scan(&customer.ID, &customer.Name, &email, &emailEnc, &phone, &phoneEnc, &taxID, &taxIDEnc, &customer.CreatedAt)
+	if err != nil {
+		return customer, err
+	}
+
+	customer.Email = readProtectedField(emailEnc, email)
+	customer.Phone = readProtectedField(phoneEnc, phone)
+	customer.TaxID = readProtectedField(taxIDEnc, taxID)
+	customer.UpdatedAt = customer.CreatedAt
+	return customer, nil
+}
+
 func getCustomer(c *gin.Context) {
 	id := c.Param("id")
-	customer, err := fetchCustomerFromDB(id)
+	customer, err := fetchCustomerFromDB(tenantFromContext(c), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
 		return
@@ -169,6 +194,34 @@ func getCustomer(c *gin.Context) {
 	})
 }
 
+// Define o usuário responsável pela conta do cliente
+func assignCustomerOwner(c *gin.Context) {
+	customerID := c.Param("id")
+	var request struct {
+		OwnerID int `json:"owner_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := db.Exec(
+		"UPDATE customers SET owner_id = $1 WHERE id = $2 AND tenant_id = $3",
+		request.OwnerID, customerID, tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao definir responsável"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "owner_assigned", "customer", customerID, gin.H{"owner_id": request.OwnerID})
+	c.JSON(http.StatusOK, gin.H{"message": "Responsável definido com sucesso"})
+}
+
 func updateCustomer(c *gin.Context) {
 	customerID := c.Param("id")
 	var updatedCustomer Customer
@@ -195,12 +248,10 @@ func updateCustomer(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedCustomer)
 }
 
+// A exclusão de clientes segue o fluxo de eliminação de dados pessoais:
+// o registro é anonimizado e mantido para os relatórios agregados de vendas
 func deleteCustomer(c *gin.Context) {
-	customerID := c.Param("id")
-
-	// Aqui você deve deletar o cliente do banco de dados
-	// Por enquanto, vamos apenas retornar uma mensagem de sucesso
-	c.JSON(http.StatusOK, gin.H{"message": "Cliente deletado com sucesso"})
+	eraseCustomerPersonalData(c)
 }
 
 func recordCustomerInteraction(c *gin.Context) {
@@ -221,32 +272,24 @@ func recordCustomerInteraction(c *gin.Context) {
 		return
 	}
 	
-	// Armazenar interação e sentimento (simulado)
+	// Armazenar interação e sentimento
 	storeInteraction(id, interaction.Type, interaction.Content, sentiment)
+	if interaction.Type == "support" {
+		metricsAggregator.Record(DomainEvent{Type: eventTicketOpened, TenantID: tenantFromContext(c), UserID: c.GetString("user_id")})
+	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Interaction recorded successfully"})
 }
 
 func getChurnPrediction(c *gin.Context) {
 	customerID := c.Param("id")
-	churnProbability := predictCustomerChurn(customerID)
-
-	c.JSON(http.StatusOK, gin.H{
-		"customer_id":        customerID,
-		"churn_probability":  churnProbability,
-	})
-}
-
-func getCustomerData(customerID string) map[string]interface{} {
-	// Implementação simplificada
-	return map[string]interface{}{
-		"total_purchases": 50,
-		"months_active": 24,
-		"support_tickets": 3,
-		"average_sentiment": 0.7,
-		"days_since_last_purchase": 15,
-		"average_purchase_value": 100.0,
+	score, err := predictCustomerChurn(customerID)
+	if err != nil {
+		c.JSON(churnErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
+
+	c.JSON(http.StatusOK, score)
 }
 
 func getRecentInteractions(id string) []string {
@@ -259,11 +302,24 @@ func getRecentInteractions(id string) []string {
 }
 
 func storeInteraction(id, interactionType, content string, sentiment float64) {
-	// Simula o armazenamento da interação
+	contentEnc, err := encryptField(content)
+	if err != nil {
+		logrus.WithError(err).WithField("customer_id", id).Error("Falha ao cifrar interação")
+		return
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO interactions (customer_id, type, content_enc, sentiment) VALUES ($1, $2, NULLIF($3, ''), $4)",
+		id, interactionType, contentEnc, sentiment,
+	)
+	if err != nil {
+		logrus.WithError(err).WithField("customer_id", id).Error("Falha ao armazenar interação")
+		return
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"customer_id": id,
 		"type": interactionType,
-		"content": content,
 		"sentiment": sentiment,
 	}).Info("Customer interaction recorded")
 }
@@ -1,371 +1,433 @@
 package main
 
 import (
-	"github.com/gin-gonic/gin"
+	"fmt"
 	"net/http"
 	"time"
-	"sync"
-	"github.com/patrickmn/go-cache"
-	"math"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
-var (
-	dashboardCache gin.H
-	dashboardCacheMutex sync.RWMutex
-	dashboardCacheExpiration time.Time
-	dataCache *cache.Cache
+const (
+	dashboardCacheDuration = 5 * time.Minute
+	dashboardDefaultDays   = 30
+	dashboardMaxDays       = 731
+	dashboardTopProducts   = 5
 )
 
 func setupDashboardRoutes(r *gin.Engine) {
 	dashboard := r.Group("/dashboard")
 	{
-		dashboard.GET("/summary", func(c *gin.Context) {
-			c.JSON(http.StatusOK, getDashboardSummary())
-		})
-		dashboard.GET("/realtime", getDashboardRealtime)
+		dashboard.GET("/summary", AuthMiddleware(), getDashboardSummaryHandler)
+		dashboard.GET("/realtime", AuthMiddleware(), getDashboardRealtime)
+		dashboard.GET("/segments", AuthMiddleware(), getDashboardSegments)
+		dashboard.GET("/funnel", AuthMiddleware(), getDashboardFunnel)
 	}
 }
 
-func getCachedDashboardSummary() gin.H {
-	dashboardCacheMutex.RLock()
-	defer dashboardCacheMutex.RUnlock()
-
-	if time.Now().Before(dashboardCacheExpiration) {
-		return dashboardCache
-	}
-
-	return nil
+// Filtros do resumo do dashboard. To é exclusivo; a data final informada
+// na query string é inclusiva.
+type DashboardFilters struct {
+	From    time.Time
+	To      time.Time
+	Compare string
+	Owner   string
+	Segment string
 }
 
-func setCachedDashboardSummary(summary gin.H) {
-	dashboardCacheMutex.Lock()
-	defer dashboardCacheMutex.Unlock()
+var dashboardComparisons = map[string]bool{
+	"":                true,
+	"previous_period": true,
+	"previous_year":   true,
+}
 
-	dashboardCache = summary
-	dashboardCacheExpiration = time.Now().Add(5 * time.Minute)
+func parseDashboardFilters(c *gin.Context) (DashboardFilters, error) {
+	return newDashboardFilters(c.Query("from"), c.Query("to"), c.Query("compare"), c.Query("owner"), c.Query("segment"), c.GetString("user_id"))
 }
 
-func getDashboardSummary() gin.H {
-	if cachedSummary := getCachedDashboardSummary(); cachedSummary != nil {
-		return cachedSummary
+// Valida os filtros recebidos como texto; owner "me" vira o usuário atual
+func newDashboardFilters(from, to, compare, owner, segment, userID string) (DashboardFilters, error) {
+	today := truncatePeriod(time.Now(), "day")
+	f := DashboardFilters{
+		From:    today.AddDate(0, 0, -(dashboardDefaultDays - 1)),
+		To:      today.AddDate(0, 0, 1),
+		Compare: compare,
+		Owner:   owner,
+		Segment: segment,
 	}
 
-	totalCustomers := getTotalCustomers()
-	activeCustomers := getActiveCustomers()
-	totalRevenue := getTotalRevenue()
-	averageSentiment := getAverageSentiment()
-	churnRate := calculateChurnRate()
-
-	summary := gin.H{
-		"totalCustomers":       totalCustomers,
-		"activeCustomers":      activeCustomers,
-		"churnRate":            churnRate,
-		"totalRevenue":         totalRevenue,
-		"averageTicket":        totalRevenue / float64(activeCustomers),
-		"customerSatisfaction": averageSentiment,
-		"topProducts":          getTopProducts(5),
-		"salesTrend":           getSalesTrend(),
+	if from != "" {
+		parsed, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return f, fmt.Errorf("from deve estar no formato AAAA-MM-DD")
+		}
+		f.From = parsed
+	}
+	if to != "" {
+		parsed, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return f, fmt.Errorf("to deve estar no formato AAAA-MM-DD")
+		}
+		f.To = parsed.AddDate(0, 0, 1)
 	}
+	return f.resolve(userID)
+}
 
-	setCachedDashboardSummary(summary)
-	return summary
+// Valida os filtros já com os limites em time.Time, preservando o fuso de
+// quem os montou; owner "me" vira o usuário atual
+func (f DashboardFilters) resolve(userID string) (DashboardFilters, error) {
+	if !f.From.Before(f.To) {
+		return f, fmt.Errorf("from deve ser anterior ou igual a to")
+	}
+	if f.To.Sub(f.From) > dashboardMaxDays*24*time.Hour {
+		return f, fmt.Errorf("o intervalo máximo é de %d dias", dashboardMaxDays)
+	}
+	if !dashboardComparisons[f.Compare] {
+		return f, fmt.Errorf("compare deve ser previous_period ou previous_year")
+	}
+	if _, ok := rfmSegmentLabels[f.Segment]; f.Segment != "" && !ok {
+		return f, fmt.Errorf("segmento inválido")
+	}
+	if f.Owner == "me" {
+		f.Owner = userID
+	}
+	return f, nil
 }
 
-func getDashboardRealtime(c *gin.Context) {
-	// Simula dados em tempo real
-	go func() {
-		for {
-			event := gin.H{
-				"timestamp": time.Now().Unix(),
-				"active_users": 1000 + rand.Intn(500),
-				"sales_per_minute": 10 + rand.Intn(20),
-				"support_tickets_open": 50 + rand.Intn(30),
-			}
-			realtimeHub.BroadcastEvent("dashboard_update", event)
-			time.Sleep(5 * time.Second)
-		}
-	}()
-	c.JSON(http.StatusOK, gin.H{"message": "Realtime dashboard initialized"})
+// Intervalo de referência da comparação: o período imediatamente anterior
+// com a mesma duração, ou o mesmo intervalo no ano anterior
+func (f DashboardFilters) comparison() (DashboardFilters, bool) {
+	previous := f
+	previous.Compare = ""
+	switch f.Compare {
+	case "previous_period":
+		// Em dias de calendário, para não deslocar o período na troca de horário de verão
+		days := int(f.To.Sub(f.From).Round(24*time.Hour) / (24 * time.Hour))
+		previous.From, previous.To = f.From.AddDate(0, 0, -days), f.From
+	case "previous_year":
+		previous.From, previous.To = f.From.AddDate(-1, 0, 0), f.To.AddDate(-1, 0, 0)
+	default:
+		return f, false
+	}
+	return previous, true
 }
 
-func calculateChurnRate() float64 {
-	totalCustomers := float64(getTotalCustomers())
-	activeCustomers := float64(getActiveCustomers())
-	return (totalCustomers - activeCustomers) / totalCustomers
+func (f DashboardFilters) cacheKey(tenantID string) string {
+	return fmt.Sprintf("dashboard:%s:%d:%d:%s:%s:%s", tenantID, f.From.Unix(), f.To.Unix(), f.Compare, f.Owner, f.Segment)
 }
 
-func getTotalCustomers() int {
-	// Implementação real: consultar banco de dados
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count)
-	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao contar clientes: %v", err)
-		return 0
+func (f DashboardFilters) describe() gin.H {
+	described := gin.H{
+		"from":    f.From.Format("2006-01-02"),
+		"to":      f.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		"compare": f.Compare,
+		"owner":   f.Owner,
+		"segment": f.Segment,
+	}
+	if previous, ok := f.comparison(); ok {
+		described["compare_from"] = previous.From.Format("2006-01-02")
+		described["compare_to"] = previous.To.AddDate(0, 0, -1).Format("2006-01-02")
 	}
-	return count
+	return described
 }
 
-func getActiveCustomers() int {
-	// Implementação real: consultar banco de dados
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE last_activity > $1", time.Now().AddDate(0, -1, 0)).Scan(&count)
-	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao contar clientes ativos: %v", err)
-		return 0
+// Indicador do dashboard. Com comparação, traz o valor anterior e as
+// variações absoluta e percentual; sem valor anterior a variação
+// percentual fica nula.
+type KPI struct {
+	Value         float64  `json:"value"`
+	Previous      *float64 `json:"previous"`
+	Delta         *float64 `json:"delta"`
+	PercentChange *float64 `json:"percent_change" graphql:"percentChange"`
+}
+
+func newKPI(value float64, previous *float64) KPI {
+	kpi := KPI{Value: value}
+	if previous == nil {
+		return kpi
 	}
-	return count
+	delta := value - *previous
+	kpi.Previous, kpi.Delta = previous, &delta
+	if *previous != 0 {
+		change := delta / *previous
+		kpi.PercentChange = &change
+	}
+	return kpi
 }
 
-func getTotalRevenue() float64 {
-	// Implementação real: consultar banco de dados
-	var total float64
-	err := db.QueryRow("SELECT SUM(amount) FROM sales").Scan(&total)
-	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao calcular receita total: %v", err)
+// Divisão que retorna zero com denominador zero, para não expor NaN ou Inf
+func safeDivide(numerator, denominator float64) float64 {
+	if denominator == 0 {
 		return 0
 	}
-	return total
+	return numerator / denominator
 }
 
-func getAverageSentiment() float64 {
-	// Implementação real: consultar serviço de análise de sentimentos
-	return 4.2
+type dashboardMetrics struct {
+	TotalCustomers       float64
+	ActiveCustomers      float64
+	NewCustomers         float64
+	TotalRevenue         float64
+	Orders               float64
+	AverageTicket        float64
+	ChurnRate            float64
+	CustomerSatisfaction float64
 }
 
-func getTopProducts(limit int) []gin.H {
-	// Implementação real: consultar banco de dados
-	return []gin.H{
-		{"name": "Produto A", "sales": 1000},
-		{"name": "Produto B", "sales": 800},
-		{"name": "Produto C", "sales": 600},
-		{"name": "Produto D", "sales": 400},
-		{"name": "Produto E", "sales": 200},
+func (m dashboardMetrics) values() map[string]float64 {
+	return map[string]float64{
+		"totalCustomers":       m.TotalCustomers,
+		"activeCustomers":      m.ActiveCustomers,
+		"newCustomers":         m.NewCustomers,
+		"totalRevenue":         m.TotalRevenue,
+		"orders":               m.Orders,
+		"averageTicket":        m.AverageTicket,
+		"churnRate":            m.ChurnRate,
+		"customerSatisfaction": m.CustomerSatisfaction,
 	}
 }
 
-func getSalesTrend() []gin.H {
-	// Implementação real: consultar banco de dados
-	rows, err := db.Query("SELECT DATE(date) as sale_date, SUM(amount) as total_sales FROM sales GROUP BY DATE(date) ORDER BY sale_date DESC LIMIT 7")
+// IDs dos clientes do segmento RFM (nil quando não há filtro de segmento)
+func segmentCustomerIDs(tenantID, segment string) ([]string, error) {
+	if segment == "" {
+		return nil, nil
+	}
+	analysis, err := computeRFMAnalysis(tenantID)
 	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao buscar tendência de vendas: %v", err)
-		return []gin.H{}
+		return nil, err
 	}
-	defer rows.Close()
-
-	var trend []gin.H
-	for rows.Next() {
-		var date string
-		var sales float64
-		if err := rows.Scan(&date, &sales); err != nil {
-			logger.ErrorLogger.Printf("Erro ao ler linha de tendência de vendas: %v", err)
-			continue
+	ids := []string{}
+	for _, s := range analysis.scores {
+		if s.Segment == segment {
+			ids = append(ids, s.CustomerID)
 		}
-		trend = append(trend, gin.H{"date": date, "sales": sales})
 	}
-	return trend
+	return ids, nil
 }
 
-func getDashboardData(c *gin.Context) {
-	cacheKey := "dashboard_data"
-	if cachedData, found := getCachedData(cacheKey); found {
-		c.JSON(http.StatusOK, cachedData)
-		return
+// Clientes dentro do escopo dos filtros, usado como CTE pelas consultas do
+// dashboard. Parâmetros: $1 tenant, $2 from, $3 to, $4 owner, $5 segmento,
+// $6 clientes do segmento.
+const dashboardScopeCTE = `
+	scope AS (
+		SELECT id, created_at FROM customers
+		WHERE tenant_id = $1
+			AND ($4 = '' OR owner_id::text = $4)
+			AND ($5 = '' OR id::text = ANY($6))
+	)`
+
+// Clientes ativos são os que compraram ou interagiram no período; o churn
+// é a fração dos clientes existentes ao fim do período sem atividade
+func computeDashboardMetrics(tenantID string, f DashboardFilters, segmentIDs []string) (dashboardMetrics, error) {
+	var m dashboardMetrics
+	var sentiment *float64
+	err := db.QueryRow(`
+		WITH`+dashboardScopeCTE+`,
+		period_sales AS (
+			SELECT customer_id, amount FROM sales
+			WHERE customer_id IN (SELECT id FROM scope) AND date >= $2 AND date < $3
+		),
+		period_interactions AS (
+			SELECT customer_id, sentiment FROM interactions
+			WHERE customer_id IN (SELECT id FROM scope) AND created_at >= $2 AND created_at < $3
+		)
+		SELECT
+			(SELECT COUNT(*) FROM scope WHERE created_at < $3),
+			(SELECT COUNT(*) FROM (
+				SELECT customer_id FROM period_sales
+				UNION
+				SELECT customer_id FROM period_interactions
+			) active),
+			(SELECT COUNT(*) FROM scope WHERE created_at >= $2 AND created_at < $3),
+			(SELECT COALESCE(SUM(amount), 0) FROM period_sales),
+			(SELECT COUNT(*) FROM period_sales),
+			(SELECT AVG(sentiment) FROM period_interactions)`,
+		tenantID, f.From.UTC(), f.To.UTC(), f.Owner, f.Segment, pq.Array(segmentIDs),
+	).Scan(&m.TotalCustomers, &m.ActiveCustomers, &m.NewCustomers, &m.TotalRevenue, &m.Orders, &sentiment)
+	if err != nil {
+		return m, err
 	}
 
-	totalCustomers := getTotalCustomers()
-	activeCustomers := getActiveCustomers()
-	totalRevenue := getTotalRevenue()
-	averageSentiment := getAverageSentiment()
-	salesTrend := getSalesTrend()
-
-	data := gin.H{
-		"totalCustomers": totalCustomers,
-		"activeCustomers": activeCustomers,
-		"totalRevenue": totalRevenue,
-		"averageSentiment": averageSentiment,
-		"salesTrend": salesTrend,
+	m.AverageTicket = safeDivide(m.TotalRevenue, m.Orders)
+	m.ChurnRate = safeDivide(m.TotalCustomers-m.ActiveCustomers, m.TotalCustomers)
+	if sentiment != nil {
+		m.CustomerSatisfaction = *sentiment
 	}
-
-	setCachedData(cacheKey, data, 5*time.Minute)
-
-	c.JSON(http.StatusOK, data)
+	return m, nil
 }
 
-type ChurnModel struct{}
-
-func (m *ChurnModel) Predict(features []float64) float64 {
-	// Modelo simplificado de previsão de churn
-	// Baseado em: recência da última compra, frequência de compras e valor monetário
-	recency := features[0]
-	frequency := features[1]
-	monetary := features[2]
-
-	score := 0.3*recency + 0.3*frequency + 0.4*monetary
-	return 1 / (1 + math.Exp(-score))
-}
-
-var churnModel = &ChurnModel{}
-
-func predictCustomerChurn(customerID string) float64 {
-package main
-
-import (
-	"github.com/gin-gonic/gin"
-	"net/http"
-	"time"
-	"sync"
-	"github.com/patrickmn/go-cache"
-)
-
-var (
-	dashboardCache gin.H
-	dashboardCacheMutex sync.RWMutex
-	dashboardCacheExpiration time.Time
-	dataCache *cache.Cache
-)
+func getTopProducts(tenantID string, f DashboardFilters, segmentIDs []string, limit int) ([]gin.H, error) {
+	rows, err := db.Query(`
+		WITH`+dashboardScopeCTE+`
+		SELECT product_name, COUNT(*), SUM(amount)
+		FROM sales
+		WHERE customer_id IN (SELECT id FROM scope) AND date >= $2 AND date < $3
+		GROUP BY product_name
+		ORDER BY 3 DESC
+		LIMIT $7`,
+		tenantID, f.From.UTC(), f.To.UTC(), f.Owner, f.Segment, pq.Array(segmentIDs), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-func setupDashboardRoutes(r *gin.Engine) {
-	dashboard := r.Group("/dashboard")
-	{
-		dashboard.GET("/summary", func(c *gin.Context) {
-			c.JSON(http.StatusOK, getDashboardSummary())
-		})
-		dashboard.GET("/realtime", getDashboardRealtime)
+	products := []gin.H{}
+	for rows.Next() {
+		var name string
+		var sales int
+		var revenue float64
+		if err := rows.Scan(&name, &sales, &revenue); err != nil {
+			return nil, err
+		}
+		products = append(products, gin.H{"name": name, "sales": sales, "revenue": revenue})
 	}
+	return products, rows.Err()
 }
 
-func getCachedDashboardSummary() gin.H {
-	dashboardCacheMutex.RLock()
-	defer dashboardCacheMutex.RUnlock()
-
-	if time.Now().Before(dashboardCacheExpiration) {
-		return dashboardCache
+// Vendas diárias no período, com os dias sem venda preenchidos com zero
+func getSalesTrend(tenantID string, f DashboardFilters, segmentIDs []string) ([]gin.H, error) {
+	rows, err := db.Query(`
+		WITH`+dashboardScopeCTE+`
+		SELECT date_trunc('day', date), SUM(amount)
+		FROM sales
+		WHERE customer_id IN (SELECT id FROM scope) AND date >= $2 AND date < $3
+		GROUP BY 1`,
+		tenantID, f.From.UTC(), f.To.UTC(), f.Owner, f.Segment, pq.Array(segmentIDs),
+	)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return nil
-}
-
-func setCachedDashboardSummary(summary gin.H) {
-	dashboardCacheMutex.Lock()
-	defer dashboardCacheMutex.Unlock()
+	byDay := map[string]float64{}
+	for rows.Next() {
+		var day time.Time
+		var sales float64
+		if err := rows.Scan(&day, &sales); err != nil {
+			return nil, err
+		}
+		byDay[day.Format("2006-01-02")] = sales
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	dashboardCache = summary
-	dashboardCacheExpiration = time.Now().Add(5 * time.Minute)
+	trend := []gin.H{}
+	for day := f.From; day.Before(f.To); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		trend = append(trend, gin.H{"date": date, "sales": byDay[date]})
+	}
+	return trend, nil
 }
 
-func getDashboardSummary() gin.H {
-	if cachedSummary := getCachedDashboardSummary(); cachedSummary != nil {
-		return cachedSummary
+// Resumo do dashboard do tenant para os filtros informados. O cache é
+// separado por tenant e por combinação de parâmetros.
+func getDashboardSummary(tenantID string, f DashboardFilters) (gin.H, error) {
+	key := f.cacheKey(tenantID)
+	if cached, found := globalCache.Get(key); found {
+		return cached.(gin.H), nil
 	}
 
-	totalCustomers := getTotalCustomers()
-	activeCustomers := getActiveCustomers()
-	totalRevenue := getTotalRevenue()
-	averageSentiment := getAverageSentiment()
-	churnRate := calculateChurnRate()
-
-	summary := gin.H{
-		"totalCustomers":       totalCustomers,
-		"activeCustomers":      activeCustomers,
-		"churnRate":            churnRate,
-		"totalRevenue":         totalRevenue,
-		"averageTicket":        totalRevenue / float64(activeCustomers),
-		"customerSatisfaction": averageSentiment,
-		"topProducts":          getTopProducts(5),
-		"salesTrend":           getSalesTrend(),
+	segmentIDs, err := segmentCustomerIDs(tenantID, f.Segment)
+	if err != nil {
+		return nil, err
+	}
+	current, err := computeDashboardMetrics(tenantID, f, segmentIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	setCachedDashboardSummary(summary)
-	return summary
-}
-
-func getDashboardRealtime(c *gin.Context) {
-	// Simula dados em tempo real
-	go func() {
-		for {
-			event := gin.H{
-				"timestamp": time.Now().Unix(),
-				"active_users": 1000 + rand.Intn(500),
-				"sales_per_minute": 10 + rand.Intn(20),
-				"support_tickets_open": 50 + rand.Intn(30),
-			}
-			realtimeHub.BroadcastEvent("dashboard_update", event)
-			time.Sleep(5 * time.Second)
+	var previousValues map[string]float64
+	if previous, ok := f.comparison(); ok {
+		metrics, err := computeDashboardMetrics(tenantID, previous, segmentIDs)
+		if err != nil {
+			return nil, err
 		}
-	}()
-	c.JSON(http.StatusOK, gin.H{"message": "Realtime dashboard initialized"})
-}
-
-func calculateChurnRate() float64 {
-	totalCustomers := float64(getTotalCustomers())
-	activeCustomers := float64(getActiveCustomers())
-	return (totalCustomers - activeCustomers) / totalCustomers
-}
-
-func getTotalCustomers() int {
-	// Implementação real: consultar banco de dados
-	return 10000
-}
-
-func getActiveCustomers() int {
-	// Implementação real: consultar banco de dados
-	return 8500
-}
-
-func getTotalRevenue() float64 {
-	// Implementação real: consultar banco de dados
-	return 1000000.0
-}
-
-func getAverageSentiment() float64 {
-	// Implementação real: consultar serviço de análise de sentimentos
-	return 4.2
-}
+		previousValues = metrics.values()
+	}
 
-func getTopProducts(limit int) []gin.H {
-	// Implementação real: consultar banco de dados
-	return []gin.H{
-		{"name": "Produto A", "sales": 1000},
-		{"name": "Produto B", "sales": 800},
-		{"name": "Produto C", "sales": 600},
-		{"name": "Produto D", "sales": 400},
-		{"name": "Produto E", "sales": 200},
+	summary := gin.H{"filters": f.describe()}
+	for name, value := range current.values() {
+		var previous *float64
+		if previousValues != nil {
+			p := previousValues[name]
+			previous = &p
+		}
+		summary[name] = newKPI(value, previous)
 	}
-}
 
-func getSalesTrend() []gin.H {
-	// Implementação real: consultar banco de dados
-	return []gin.H{
-		{"date": "2023-05-01", "sales": 10000},
-		{"date": "2023-05-02", "sales": 12000},
-		{"date": "2023-05-03", "sales": 11000},
-		{"date": "2023-05-04", "sales": 13000},
-		{"date": "2023-05-05", "sales": 15000},
+	if summary["topProducts"], err = getTopProducts(tenantID, f, segmentIDs, dashboardTopProducts); err != nil {
+		return nil, err
+	}
+	if summary["salesTrend"], err = getSalesTrend(tenantID, f, segmentIDs); err != nil {
+		return nil, err
 	}
+
+	globalCache.Set(key, summary, dashboardCacheDuration)
+	return summary, nil
 }
 
-func getDashboardData(c *gin.Context) {
-	cacheKey := "dashboard_data"
-	if cachedData, found := getCachedData(cacheKey); found {
-		c.JSON(http.StatusOK, cachedData)
+// Parâmetros: from e to (AAAA-MM-DD, padrão últimos 30 dias),
+// compare (previous_period ou previous_year), owner (ID ou "me") e
+// segment (segmento RFM)
+func getDashboardSummaryHandler(c *gin.Context) {
+	filters, err := parseDashboardFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	totalCustomers := getTotalCustomers()
-	activeCustomers := getActiveCustomers()
-	totalRevenue := getTotalRevenue()
-	averageSentiment := getAverageSentiment()
-	salesTrend := getSalesTrend()
+	summary, err := getDashboardSummary(tenantFromContext(c), filters)
+	if err != nil {
+		logger.Errorf("Erro ao calcular resumo do dashboard: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular resumo do dashboard"})
+		return
+	}
 
-	data := gin.H{
-		"totalCustomers": totalCustomers,
-		"activeCustomers": activeCustomers,
-		"totalRevenue": totalRevenue,
-		"averageSentiment": averageSentiment,
-		"salesTrend": salesTrend,
+	// O resumo em cache é compartilhado pelo tenant; as metas são do usuário.
+	// Uma falha nas metas não derruba o resumo.
+	goals, err := userGoalAttainment(tenantFromContext(c), c.GetString("user_id"), time.Now())
+	if err != nil {
+		logger.Errorf("Erro ao apurar metas do usuário: %v", err)
+		goals = []GoalWithAttainment{}
+	}
+	response := gin.H{"goals": goals}
+	for key, value := range summary {
+		response[key] = value
 	}
+	c.JSON(http.StatusOK, response)
+}
 
-	setCachedData(cacheKey, data, 5*time.Minute)
+// Widget de funil: oportunidades e ciclo de vida dos últimos 90 dias
+func getDashboardFunnel(c *gin.Context) {
+	now := time.Now()
+	scope := funnelScope{From: truncatePeriod(now.Add(-defaultFunnelWindow), "day"), To: truncatePeriod(now, "day").AddDate(0, 0, 1)}
+
+	widget := gin.H{"scope": scope}
+	for funnelType := range funnelDefinitions {
+		funnel, err := computeTenantFunnel(tenantFromContext(c), funnelType, scope)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular funil"})
+			return
+		}
+		widget[funnelType] = gin.H{
+			"entities":               funnel.Entities,
+			"overall_conversion":     funnel.OverallConversion,
+			"median_days_to_convert": funnel.MedianDaysToConvert,
+			"steps":                  funnel.Steps,
+		}
+	}
+	c.JSON(http.StatusOK, widget)
+}
 
-	c.JSON(http.StatusOK, data)
+// Estado atual das métricas em tempo real do tenant; as atualizações
+// seguintes chegam como deltas no evento dashboard_update
+func getDashboardRealtime(c *gin.Context) {
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"metrics":   metricsAggregator.Snapshot(tenantFromContext(c), now),
+		"timestamp": now.Unix(),
+	})
 }
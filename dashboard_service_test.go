@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKPI(t *testing.T) {
+	kpi := newKPI(150, nil)
+	assert.Nil(t, kpi.Delta)
+	assert.Nil(t, kpi.PercentChange)
+
+	previous := 100.0
+	kpi = newKPI(150, &previous)
+	assert.InDelta(t, 50, *kpi.Delta, 1e-9)
+	assert.InDelta(t, 0.5, *kpi.PercentChange, 1e-9)
+
+	// Sem valor anterior a variação percentual não é definida
+	zero := 0.0
+	kpi = newKPI(10, &zero)
+	assert.InDelta(t, 10, *kpi.Delta, 1e-9)
+	assert.Nil(t, kpi.PercentChange)
+
+	assert.Equal(t, 0.0, safeDivide(100, 0))
+}
+
+func TestDashboardFilters(t *testing.T) {
+	f, err := newDashboardFilters("2026-09-01", "2026-09-30", "previous_period", "me", "", "42")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", f.Owner)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), f.To)
+
+	previous, ok := f.comparison()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2026, 8, 2, 0, 0, 0, 0, time.UTC), previous.From)
+	assert.Equal(t, f.From, previous.To)
+
+	f.Compare = "previous_year"
+	previous, _ = f.comparison()
+	assert.Equal(t, time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC), previous.From)
+
+	_, err = newDashboardFilters("2026-09-30", "2026-09-01", "", "", "", "")
+	assert.Error(t, err)
+	_, err = newDashboardFilters("", "", "yesterday", "", "", "")
+	assert.Error(t, err)
+	_, err = newDashboardFilters("", "", "", "", "vip", "")
+	assert.Error(t, err)
+}
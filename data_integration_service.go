@@ -58,14 +58,23 @@ func getCustomer360View(c *gin.Context) {
 
 	aiSuggestion, _ := c.Get("ai_suggestion")
 
-	customerData := getCustomerData(customerID)
+	customerData, _ := getOnlineFeatures(customerID)
 	salesData := getCustomerSalesData(customerID)
 	analyticsData := getCustomerAnalytics(customerID)
 	recommendationData := getCustomerRecommendations(customerID)
 	interactionHistory := getCustomerInteractionHistory(customerID)
 	sentimentHistory := getCustomerSentimentHistory(customerID)
-	churnProbability := predictChurn(customerData)
-	lifetimeValue := calculateLifetimeValue(customerID)
+	var churnProbability, churnExplanation interface{}
+	if score, err := predictCustomerChurn(customerID); err == nil {
+		churnProbability = score.Probability
+		churnExplanation = score.Explanation
+	}
+	var lifetimeValue float64
+	var lifetimeValueExplanation interface{}
+	if prediction, err := predictLifetimeValue(tenantFromContext(c), customerID, defaultLifetimeValueHorizonMonths); err == nil {
+		lifetimeValue = prediction.Value
+		lifetimeValueExplanation = prediction.Explanation
+	}
 
 	view := gin.H{
 		"customer_id":        customerID,
@@ -76,7 +85,9 @@ func getCustomer360View(c *gin.Context) {
 		"interaction_history": interactionHistory,
 		"sentiment_history":   sentimentHistory,
 		"churn_probability":   churnProbability,
+		"churn_explanation":   churnExplanation,
 		"lifetime_value":      lifetimeValue,
+		"lifetime_value_explanation": lifetimeValueExplanation,
 		"ai_suggestion":       aiSuggestion,
 	}
 
@@ -6,8 +6,8 @@ import (
     "github.com/gin-gonic/gin"
     "github.com/stretchr/testify/assert"
     "net/http"
+    "net/http/httptest"
     "encoding/json"
-    "github.com/stretchr/testify/mock"
 )
 
 func TestGetCachedCustomer360View(t *testing.T) {
@@ -64,38 +64,42 @@ func TestGetCustomerSentimentHistory(t *testing.T) {
 }
 
 func TestCalculateLifetimeValue(t *testing.T) {
-    customerID := "131415"
-    ltv := calculateLifetimeValue(customerID)
-    
-    assert.InDelta(t, 5000.0, ltv, 0.01)
+    // Parâmetros e exemplo publicados por Fader, Hardie e Lee (2005) para a base CDNOW
+    params := CLVParameters{R: 0.243, Alpha: 4.414, A: 0.793, B: 2.426, P: 6.25, Q: 3.74, Gamma: 15.44}
+    customer := CustomerRFM{Frequency: 2, Recency: 30.43, Age: 38.86, MonetaryValue: 20}
+
+    assert.InDelta(t, 1.226, params.ExpectedPurchases(customer, 39), 0.001)
+    assert.Equal(t, 1.0, params.ProbabilityAlive(CustomerRFM{Frequency: 0, Age: 10}))
+
+    // Valor esperado por compra fica entre a média do cliente e a da população
+    average := params.ExpectedAverageValue(customer)
+    assert.True(t, average > 20 && average < params.P*params.Gamma/(params.Q-1))
+    assert.InDelta(t, params.ExpectedPurchases(customer, 39)*average, params.LifetimeValue(customer, 39), 1e-9)
 }
 
 func TestGetCustomer360View(t *testing.T) {
-    // Configurar o router e o contexto de teste
-    router := gin.New()
+    // A probabilidade de churn da visão vem de predictCustomerChurn; sem
+    // modelo em produção, ela falha antes de consultar o banco
+    previous := modelRegistry
+    modelRegistry = NewModelRegistry()
+    modelRegistry.checkedAt[churnModelName] = time.Now()
+    defer func() { modelRegistry = previous }()
+    _, err := predictCustomerChurn("123")
+    assert.ErrorIs(t, err, errChurnModelNotTrained)
+
+    // A visão montada é servida do cache
+    customer360Cache = make(map[string]gin.H)
+    customer360CacheExpiration = make(map[string]time.Time)
+    setCachedCustomer360View("123", gin.H{"customer_id": "123", "churn_probability": nil, "ai_suggestion": "Mock AI Suggestion"})
+
     w := httptest.NewRecorder()
     c, _ := gin.CreateTestContext(w)
-
-    // Configurar o mock do middleware de IA
-    c.Set("ai_suggestion", "Mock AI Suggestion")
-
-    // Substituir funções reais por mocks
-    getCustomerData = mockGetCustomerData
-    predictChurn = mockPredictChurn
-    getCustomerRecommendations = mockGetCustomerRecommendations
-
-    // Chamar a função
+    c.Params = gin.Params{{Key: "customer_id", Value: "123"}}
     getCustomer360View(c)
-
-    // Verificar o resultado
     assert.Equal(t, http.StatusOK, w.Code)
 
     var response map[string]interface{}
-    err := json.Unmarshal(w.Body.Bytes(), &response)
-    assert.NoError(t, err)
-
-    assert.Equal(t, "Mock Customer", response["customer_data"].(map[string]interface{})["name"])
-    assert.InDelta(t, 0.3, response["churn_probability"], 0.01)
-    assert.Equal(t, []interface{}{"Product A", "Product B"}, response["recommendations"])
+    assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+    assert.Nil(t, response["churn_probability"])
     assert.Equal(t, "Mock AI Suggestion", response["ai_suggestion"])
 }
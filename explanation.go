@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+const (
+	explanationTopFeatures = 5
+	shapleySamples         = 200
+)
+
+// Contribuição de uma feature para uma previsão. Impact está na unidade da
+// saída (pontos de probabilidade para churn, reais para valor vitalício).
+type FeatureContribution struct {
+	Feature string  `json:"feature"`
+	Value   float64 `json:"value"`
+	Impact  float64 `json:"impact"`
+	// Contribuição exata em log-odds, apenas para modelos lineares
+	LogOdds *float64 `json:"log_odds,omitempty"`
+	Reason  string   `json:"reason"`
+}
+
+type Explanation struct {
+	Method        string                `json:"method"`
+	BaseValue     float64               `json:"base_value" graphql:"baseValue"`
+	Contributions []FeatureContribution `json:"contributions"`
+	Summary       string                `json:"summary"`
+}
+
+// Explicação exata para a regressão logística: cada feature contribui
+// w·z em log-odds em relação ao cliente médio (z = 0). O impacto em
+// probabilidade é a variação ao trocar a feature pelo valor médio.
+func explainChurnModel(m *ChurnModel, x []float64) *Explanation {
+	z := m.standardize(x)
+	logit := m.Bias + floats.Dot(m.Weights, z)
+	p := sigmoid(logit)
+
+	contributions := make([]FeatureContribution, len(x))
+	for i := range x {
+		logOdds := m.Weights[i] * z[i]
+		contributions[i] = FeatureContribution{
+			Feature: m.FeatureNames[i],
+			Value:   x[i],
+			Impact:  p - sigmoid(logit-logOdds),
+			LogOdds: &logOdds,
+		}
+	}
+
+	return buildExplanation("linear", sigmoid(m.Bias), contributions, formatProbabilityImpact)
+}
+
+// Valores de Shapley aproximados por amostragem de permutações, para
+// modelos arbitrários. A soma dos impactos aproxima predict(x) - predict(baseline).
+func explainWithShapley(names []string, predict func([]float64) float64, x, baseline []float64, format func(float64) string) *Explanation {
+	phi := approximateShapley(predict, x, baseline, shapleySamples, 42)
+
+	contributions := make([]FeatureContribution, len(x))
+	for i := range x {
+		contributions[i] = FeatureContribution{
+			Feature: names[i],
+			Value:   x[i],
+			Impact:  phi[i],
+		}
+	}
+	return buildExplanation("shapley_sampling", predict(baseline), contributions, format)
+}
+
+func approximateShapley(predict func([]float64) float64, x, baseline []float64, samples int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	phi := make([]float64, len(x))
+	z := make([]float64, len(x))
+
+	for s := 0; s < samples; s++ {
+		copy(z, baseline)
+		previous := predict(z)
+		for _, j := range rng.Perm(len(x)) {
+			z[j] = x[j]
+			current := predict(z)
+			phi[j] += current - previous
+			previous = current
+		}
+	}
+
+	for j := range phi {
+		phi[j] /= float64(samples)
+	}
+	return phi
+}
+
+// Ordena por impacto absoluto, mantém as principais e gera os motivos
+func buildExplanation(method string, base float64, contributions []FeatureContribution, format func(float64) string) *Explanation {
+	sort.SliceStable(contributions, func(i, j int) bool {
+		return math.Abs(contributions[i].Impact) > math.Abs(contributions[j].Impact)
+	})
+	if len(contributions) > explanationTopFeatures {
+		contributions = contributions[:explanationTopFeatures]
+	}
+
+	reasons := make([]string, 0, len(contributions))
+	for i := range contributions {
+		contributions[i].Reason = fmt.Sprintf("%s (%s)",
+			describeFeatureValue(contributions[i].Feature, contributions[i].Value),
+			format(contributions[i].Impact))
+		reasons = append(reasons, contributions[i].Reason)
+	}
+
+	return &Explanation{
+		Method:        method,
+		BaseValue:     base,
+		Contributions: contributions,
+		Summary:       strings.Join(reasons, "; "),
+	}
+}
+
+func formatProbabilityImpact(impact float64) string {
+	return fmt.Sprintf("%+.0f%%", impact*100)
+}
+
+func formatCurrencyImpact(impact float64) string {
+	sign := "+"
+	if impact < 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%sR$ %.2f", sign, math.Abs(impact))
+}
+
+// Descreve o valor da feature em linguagem de negócio
+func describeFeatureValue(feature string, value float64) string {
+	switch feature {
+	case "recency_days":
+		return fmt.Sprintf("%.0f dias sem compra", value)
+	case "frequency_per_month":
+		return fmt.Sprintf("%.1f compras por mês", value)
+	case "log_average_purchase_value":
+		return fmt.Sprintf("ticket médio de R$ %.2f", math.Expm1(value))
+	case "average_purchase_value":
+		return fmt.Sprintf("ticket médio de R$ %.2f", value)
+	case "tenure_months":
+		return fmt.Sprintf("cliente há %.0f meses", value)
+	case "purchases_90d":
+		return fmt.Sprintf("%.0f compras nos últimos 90 dias", value)
+	case "revenue_trend_90d":
+		change := math.Expm1(value) * 100
+		if change < 0 {
+			return fmt.Sprintf("receita do trimestre em queda (%.0f%%)", change)
+		}
+		return fmt.Sprintf("receita do trimestre em alta (+%.0f%%)", change)
+	case "interactions_90d":
+		return fmt.Sprintf("%.0f interações nos últimos 90 dias", value)
+	case "support_tickets_90d":
+		return fmt.Sprintf("%.0f chamados de suporte nos últimos 90 dias", value)
+	case "average_sentiment_90d":
+		return fmt.Sprintf("sentimento médio de %.2f", value)
+	case "total_purchases":
+		return fmt.Sprintf("%.0f compras no total", value)
+	case "repeat_purchases":
+		return fmt.Sprintf("%.0f compras repetidas", value)
+	case "recency_weeks":
+		return fmt.Sprintf("última compra na semana %.0f do relacionamento", value)
+	case "customer_age_weeks":
+		return fmt.Sprintf("primeira compra há %.0f semanas", value)
+	case "average_repeat_value":
+		return fmt.Sprintf("valor médio de R$ %.2f nas compras repetidas", value)
+	case "total_revenue":
+		return fmt.Sprintf("receita total de R$ %.2f", value)
+	default:
+		return fmt.Sprintf("%s = %.2f", feature, value)
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	featureRefreshBatchSize = 500
+	// Features como recência dependem do relógio; mesmo sem eventos novos,
+	// os valores online são recalculados diariamente
+	featureFullRefreshInterval = 24 * time.Hour
+)
+
+// Agregados de vendas e interações de um cliente, considerando apenas
+// registros até AsOf. É a base de todas as features do registro.
+type CustomerAggregates struct {
+	CustomerID          string
+	AsOf                time.Time
+	CreatedAt           time.Time
+	LastPurchase        sql.NullTime
+	TotalPurchases      float64
+	TotalRevenue        float64
+	AveragePurchase     float64
+	Purchases90d        float64
+	Revenue90d          float64
+	RevenuePrevious90d  float64
+	Interactions90d     float64
+	SupportTickets90d   float64
+	AverageSentiment90d sql.NullFloat64
+}
+
+// Definição de uma feature: nome estável, descrição e cálculo em Go
+// sobre os agregados do cliente
+type FeatureDefinition struct {
+	Name        string
+	Description string
+	Compute     func(a CustomerAggregates) float64
+}
+
+type FeatureRegistry struct {
+	definitions []FeatureDefinition
+	byName      map[string]FeatureDefinition
+}
+
+func NewFeatureRegistry(definitions ...FeatureDefinition) *FeatureRegistry {
+	r := &FeatureRegistry{byName: make(map[string]FeatureDefinition)}
+	for _, d := range definitions {
+		if _, exists := r.byName[d.Name]; exists {
+			panic("feature duplicada: " + d.Name)
+		}
+		r.definitions = append(r.definitions, d)
+		r.byName[d.Name] = d
+	}
+	return r
+}
+
+func (r *FeatureRegistry) Names() []string {
+	names := make([]string, len(r.definitions))
+	for i, d := range r.definitions {
+		names[i] = d.Name
+	}
+	return names
+}
+
+func (r *FeatureRegistry) Compute(a CustomerAggregates) FeatureVector {
+	v := FeatureVector{
+		CustomerID: a.CustomerID,
+		AsOf:       a.AsOf,
+		ComputedAt: time.Now(),
+		Values:     make(map[string]float64, len(r.definitions)),
+	}
+	for _, d := range r.definitions {
+		v.Values[d.Name] = d.Compute(a)
+	}
+	return v
+}
+
+func tenureMonths(a CustomerAggregates) float64 {
+	return math.Max(1, a.AsOf.Sub(a.CreatedAt).Hours()/24/30)
+}
+
+var featureRegistry = NewFeatureRegistry(
+	FeatureDefinition{"recency_days", "Dias desde a última compra (ou desde o cadastro)", func(a CustomerAggregates) float64 {
+		if a.LastPurchase.Valid {
+			return a.AsOf.Sub(a.LastPurchase.Time).Hours() / 24
+		}
+		return a.AsOf.Sub(a.CreatedAt).Hours() / 24
+	}},
+	FeatureDefinition{"frequency_per_month", "Compras por mês de relacionamento", func(a CustomerAggregates) float64 {
+		return a.TotalPurchases / tenureMonths(a)
+	}},
+	FeatureDefinition{"log_average_purchase_value", "Log do ticket médio", func(a CustomerAggregates) float64 {
+		return math.Log1p(a.AveragePurchase)
+	}},
+	FeatureDefinition{"tenure_months", "Meses desde o cadastro", tenureMonths},
+	FeatureDefinition{"purchases_90d", "Compras nos últimos 90 dias", func(a CustomerAggregates) float64 {
+		return a.Purchases90d
+	}},
+	FeatureDefinition{"revenue_trend_90d", "Variação logarítmica da receita contra os 90 dias anteriores", func(a CustomerAggregates) float64 {
+		return math.Log1p(a.Revenue90d) - math.Log1p(a.RevenuePrevious90d)
+	}},
+	FeatureDefinition{"interactions_90d", "Interações nos últimos 90 dias", func(a CustomerAggregates) float64 {
+		return a.Interactions90d
+	}},
+	FeatureDefinition{"support_tickets_90d", "Chamados de suporte nos últimos 90 dias", func(a CustomerAggregates) float64 {
+		return a.SupportTickets90d
+	}},
+	FeatureDefinition{"average_sentiment_90d", "Sentimento médio nos últimos 90 dias (0,5 sem interações)", func(a CustomerAggregates) float64 {
+		if a.AverageSentiment90d.Valid {
+			return a.AverageSentiment90d.Float64
+		}
+		return 0.5
+	}},
+	FeatureDefinition{"total_purchases", "Total de compras", func(a CustomerAggregates) float64 {
+		return a.TotalPurchases
+	}},
+	FeatureDefinition{"total_revenue", "Receita total", func(a CustomerAggregates) float64 {
+		return a.TotalRevenue
+	}},
+	FeatureDefinition{"average_purchase_value", "Ticket médio", func(a CustomerAggregates) float64 {
+		return a.AveragePurchase
+	}},
+)
+
+// Valores de features de um cliente calculados em AsOf
+type FeatureVector struct {
+	CustomerID string             `json:"customer_id"`
+	AsOf       time.Time          `json:"as_of"`
+	ComputedAt time.Time          `json:"computed_at"`
+	Values     map[string]float64 `json:"values"`
+}
+
+// Retorna os valores na ordem pedida, falhando se alguma feature não
+// estiver presente (por exemplo, vetor calculado por uma versão antiga)
+func (v *FeatureVector) Vector(names []string) ([]float64, error) {
+	result := make([]float64, len(names))
+	for i, name := range names {
+		value, ok := v.Values[name]
+		if !ok {
+			return nil, fmt.Errorf("feature %q ausente para o cliente %s", name, v.CustomerID)
+		}
+		result[i] = value
+	}
+	return result, nil
+}
+
+func (v *FeatureVector) Get(name string) float64 {
+	return v.Values[name]
+}
+
+func createFeatureStoreTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS customer_features_online (
+			customer_id INTEGER PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+			features JSONB NOT NULL,
+			computed_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS customer_features_dirty (
+			customer_id INTEGER PRIMARY KEY,
+			marked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS feature_snapshots (
+			id BIGSERIAL PRIMARY KEY,
+			as_of TIMESTAMP NOT NULL,
+			feature_names JSONB NOT NULL,
+			row_count INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS feature_snapshot_rows (
+			snapshot_id BIGINT REFERENCES feature_snapshots(id) ON DELETE CASCADE,
+			customer_id INTEGER NOT NULL,
+			features JSONB NOT NULL,
+			PRIMARY KEY (snapshot_id, customer_id)
+		);
+
+		CREATE OR REPLACE FUNCTION mark_customer_features_dirty() RETURNS trigger AS $$
+		DECLARE
+			affected INTEGER;
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				affected := OLD.customer_id;
+			ELSE
+				affected := NEW.customer_id;
+			END IF;
+			IF affected IS NOT NULL THEN
+				INSERT INTO customer_features_dirty (customer_id) VALUES (affected)
+				ON CONFLICT (customer_id) DO UPDATE SET marked_at = CURRENT_TIMESTAMP;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS sales_features_dirty ON sales;
+		CREATE TRIGGER sales_features_dirty AFTER INSERT OR UPDATE OR DELETE ON sales
+			FOR EACH ROW EXECUTE FUNCTION mark_customer_features_dirty();
+
+		DROP TRIGGER IF EXISTS interactions_features_dirty ON interactions;
+		CREATE TRIGGER interactions_features_dirty AFTER INSERT OR UPDATE OR DELETE ON interactions
+			FOR EACH ROW EXECUTE FUNCTION mark_customer_features_dirty();
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Calcula os agregados como estavam em asOf. Sem IDs, considera todos os
+// clientes. Apenas clientes não anonimizados e com consentimento ativo para
+// perfilamento entram no resultado.
+func computeCustomerAggregates(customerIDs []string, asOf time.Time) ([]CustomerAggregates, error) {
+	rows, err := db.Query(`
+		WITH s AS (
+			SELECT customer_id,
+				MAX(date) AS last_purchase,
+				COUNT(*) AS purchases,
+				SUM(amount) AS revenue,
+				AVG(amount) AS average_amount,
+				COUNT(*) FILTER (WHERE date > $1::timestamp - INTERVAL '90 days') AS purchases_90d,
+				COALESCE(SUM(amount) FILTER (WHERE date > $1::timestamp - INTERVAL '90 days'), 0) AS revenue_90d,
+				COALESCE(SUM(amount) FILTER (WHERE date <= $1::timestamp - INTERVAL '90 days'
+					AND date > $1::timestamp - INTERVAL '180 days'), 0) AS revenue_prev_90d
+			FROM sales
+			WHERE date <= $1
+			GROUP BY customer_id
+		), i AS (
+			SELECT customer_id,
+				COUNT(*) AS interactions_90d,
+				COUNT(*) FILTER (WHERE type = 'support') AS tickets_90d,
+				AVG(sentiment) AS average_sentiment
+			FROM interactions
+			WHERE created_at <= $1 AND created_at > $1::timestamp - INTERVAL '90 days'
+			GROUP BY customer_id
+		)
+		SELECT c.id, c.created_at, s.last_purchase,
+			COALESCE(s.purchases, 0), COALESCE(s.revenue, 0), COALESCE(s.average_amount, 0),
+			COALESCE(s.purchases_90d, 0), COALESCE(s.revenue_90d, 0), COALESCE(s.revenue_prev_90d, 0),
+			COALESCE(i.interactions_90d, 0), COALESCE(i.tickets_90d, 0), i.average_sentiment
+		FROM customers c
+		LEFT JOIN s ON s.customer_id = c.id
+		LEFT JOIN i ON i.customer_id = c.id
+		WHERE c.created_at <= $1 AND c.anonymized_at IS NULL
+			AND (cardinality($2::text[]) = 0 OR c.id::text = ANY($2))
+			AND EXISTS (
+				SELECT 1 FROM customer_consents cc
+				WHERE cc.customer_id = c.id AND cc.purpose = 'profiling'
+					AND cc.withdrawn_at IS NULL AND cc.superseded_at IS NULL
+			)`,
+		asOf, pq.Array(customerIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []CustomerAggregates
+	for rows.Next() {
+		a := CustomerAggregates{AsOf: asOf}
+		err := rows.Scan(&a.CustomerID, &a.CreatedAt, &a.LastPurchase,
+			&a.TotalPurchases, &a.TotalRevenue, &a.AveragePurchase,
+			&a.Purchases90d, &a.Revenue90d, &a.RevenuePrevious90d,
+			&a.Interactions90d, &a.SupportTickets90d, &a.AverageSentiment90d)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+func computeFeatureVectors(customerIDs []string, asOf time.Time) ([]FeatureVector, error) {
+	aggregates, err := computeCustomerAggregates(customerIDs, asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([]FeatureVector, len(aggregates))
+	for i, a := range aggregates {
+		vectors[i] = featureRegistry.Compute(a)
+	}
+	return vectors, nil
+}
+
+// Valores mais recentes das features do cliente. Se ainda não houver
+// valores na loja online, eles são calculados e gravados na hora.
+func getOnlineFeatures(customerID string) (*FeatureVector, error) {
+	v := &FeatureVector{CustomerID: customerID}
+	var features []byte
+	err := db.QueryRow(
+		"SELECT features, computed_at FROM customer_features_online WHERE customer_id::text = $1",
+		customerID,
+	).Scan(&features, &v.ComputedAt)
+	if err == nil {
+		v.AsOf = v.ComputedAt
+		if err := json.Unmarshal(features, &v.Values); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	vectors, err := refreshOnlineFeatures([]string{customerID})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &vectors[0], nil
+}
+
+// Recalcula e grava as features dos clientes informados. Clientes que
+// deixaram de ser elegíveis (sem consentimento, anonimizados) têm seus
+// valores removidos.
+func refreshOnlineFeatures(customerIDs []string) ([]FeatureVector, error) {
+	now := time.Now()
+	vectors, err := computeFeatureVectors(customerIDs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	computed := make([]string, 0, len(vectors))
+	for _, v := range vectors {
+		features, err := json.Marshal(v.Values)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO customer_features_online (customer_id, features, computed_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (customer_id) DO UPDATE SET features = EXCLUDED.features, computed_at = EXCLUDED.computed_at`,
+			v.CustomerID, features, v.ComputedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		computed = append(computed, v.CustomerID)
+	}
+
+	if len(customerIDs) > 0 {
+		_, err = tx.Exec(
+			"DELETE FROM customer_features_online WHERE customer_id::text = ANY($1) AND NOT (customer_id::text = ANY($2))",
+			pq.Array(customerIDs), pq.Array(computed),
+		)
+	} else {
+		_, err = tx.Exec(
+			"DELETE FROM customer_features_online WHERE NOT (customer_id::text = ANY($1))",
+			pq.Array(computed),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vectors, tx.Commit()
+}
+
+// Remove os valores online do cliente, usado quando ele revoga o
+// consentimento para perfilamento
+func forgetOnlineFeatures(customerID string) {
+	_, err := db.Exec("DELETE FROM customer_features_online WHERE customer_id::text = $1", customerID)
+	if err != nil {
+		logger.Errorf("Falha ao remover features do cliente %s: %v", customerID, err)
+	}
+}
+
+// Processa os clientes marcados pelos gatilhos de vendas e interações
+func refreshDirtyFeatures(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rows, err := db.Query(`
+			DELETE FROM customer_features_dirty
+			WHERE customer_id IN (
+				SELECT customer_id FROM customer_features_dirty ORDER BY marked_at LIMIT $1
+			)
+			RETURNING customer_id::text`,
+			featureRefreshBatchSize,
+		)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if _, err := refreshOnlineFeatures(ids); err != nil {
+			// Devolve os clientes para a fila para a próxima execução
+			db.Exec(`
+				INSERT INTO customer_features_dirty (customer_id)
+				SELECT unnest($1::text[])::integer
+				ON CONFLICT (customer_id) DO NOTHING`,
+				pq.Array(ids),
+			)
+			return err
+		}
+	}
+}
+
+func refreshAllFeatures(ctx context.Context) error {
+	vectors, err := refreshOnlineFeatures(nil)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Features recalculadas para %d clientes", len(vectors))
+	return nil
+}
+
+// Grava um snapshot offline com as features como eram em asOf. Os agregados
+// só usam registros até asOf, evitando vazamento de dados futuros no treino.
+func createFeatureSnapshot(asOf time.Time) (int64, []FeatureVector, error) {
+	vectors, err := computeFeatureVectors(nil, asOf)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	names, _ := json.Marshal(featureRegistry.Names())
+	var snapshotID int64
+	err = tx.QueryRow(
+		"INSERT INTO feature_snapshots (as_of, feature_names, row_count) VALUES ($1, $2, $3) RETURNING id",
+		asOf, names, len(vectors),
+	).Scan(&snapshotID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("feature_snapshot_rows", "snapshot_id", "customer_id", "features"))
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, v := range vectors {
+		features, err := json.Marshal(v.Values)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := stmt.Exec(snapshotID, v.CustomerID, string(features)); err != nil {
+			return 0, nil, err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return 0, nil, err
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, nil, err
+	}
+
+	return snapshotID, vectors, tx.Commit()
+}
+
+func loadFeatureSnapshot(snapshotID int64) ([]FeatureVector, error) {
+	rows, err := db.Query(`
+		SELECT r.customer_id::text, s.as_of, s.created_at, r.features
+		FROM feature_snapshot_rows r
+		JOIN feature_snapshots s ON s.id = r.snapshot_id
+		WHERE r.snapshot_id = $1
+		ORDER BY r.customer_id`,
+		snapshotID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vectors []FeatureVector
+	for rows.Next() {
+		var v FeatureVector
+		var features []byte
+		if err := rows.Scan(&v.CustomerID, &v.AsOf, &v.ComputedAt, &features); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(features, &v.Values); err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, rows.Err()
+}
+
+func listFeatureDefinitions(c *gin.Context) {
+	definitions := make([]gin.H, len(featureRegistry.definitions))
+	for i, d := range featureRegistry.definitions {
+		definitions[i] = gin.H{"name": d.Name, "description": d.Description}
+	}
+	c.JSON(http.StatusOK, gin.H{"features": definitions})
+}
+
+func getCustomerFeatures(c *gin.Context) {
+	customerID := c.Param("id")
+	if !hasProfilingConsent(customerID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": errNoProfilingConsent.Error()})
+		return
+	}
+
+	features, err := getOnlineFeatures(customerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar features"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": features.CustomerID,
+		"features":    features.Values,
+		"computed_at": features.ComputedAt,
+		"age_seconds": int(time.Since(features.ComputedAt).Seconds()),
+	})
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Formato dos valores cifrados: enc:v1:<key_id>:<chave de dados cifrada>:<nonce+texto cifrado>
+const encryptedFieldPrefix = "enc:v1:"
+
+const reencryptionBatchSize = 500
+
+// Nome da tarefa de recifragem, também usado como chave do advisory lock
+// quando a rotação de chave dispara a recifragem imediata
+const fieldReencryptionJob = "field_reencryption"
+
+// Coluna cifrada e, opcionalmente, seu índice cego. Plain é a coluna legada
+// em texto claro, esvaziada quando o valor é cifrado.
+type encryptedColumn struct {
+	Table      string
+	Plain      string
+	Encrypted  string
+	BlindIndex string
+	Field      string
+	// Condição para cifrar valores legados em texto claro
+	BackfillFilter string
+}
+
+var encryptedColumns = []encryptedColumn{
+	{Table: "customers", Plain: "email", Encrypted: "email_enc", BlindIndex: "email_bidx", Field: "email", BackfillFilter: "anonymized_at IS NULL"},
+	{Table: "customers", Plain: "phone", Encrypted: "phone_enc", BlindIndex: "phone_bidx", Field: "phone", BackfillFilter: "TRUE"},
+	{Table: "customers", Plain: "tax_id", Encrypted: "tax_id_enc", BlindIndex: "tax_id_bidx", Field: "tax_id", BackfillFilter: "TRUE"},
+	{Table: "interactions", Plain: "content", Encrypted: "content_enc", BackfillFilter: "TRUE"},
+	{Table: "customer_custom_fields", Plain: "value", Encrypted: "value_enc", BackfillFilter: "sensitive"},
+}
+
+func createEncryptionTables() {
+	_, err := db.Exec(`
+		ALTER TABLE customers ALTER COLUMN email DROP NOT NULL;
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_id VARCHAR(20);
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS email_enc TEXT;
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS email_bidx VARCHAR(64);
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS phone_enc TEXT;
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS phone_bidx VARCHAR(64);
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_id_enc TEXT;
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_id_bidx VARCHAR(64);
+		ALTER TABLE interactions ADD COLUMN IF NOT EXISTS content_enc TEXT;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_email_bidx ON customers (tenant_id, email_bidx);
+		CREATE INDEX IF NOT EXISTS idx_customers_phone_bidx ON customers (tenant_id, phone_bidx);
+		CREATE INDEX IF NOT EXISTS idx_customers_tax_id_bidx ON customers (tenant_id, tax_id_bidx);
+
+		CREATE TABLE IF NOT EXISTS customer_custom_fields (
+			id SERIAL PRIMARY KEY,
+			customer_id INTEGER NOT NULL REFERENCES customers(id),
+			name VARCHAR(100) NOT NULL,
+			value TEXT,
+			value_enc TEXT,
+			sensitive BOOLEAN NOT NULL DEFAULT FALSE,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (customer_id, name)
+		);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupEncryptionRoutes(privacy *gin.RouterGroup) {
+	encryption := privacy.Group("/encryption")
+	encryption.Use(roleAuthorization("admin"))
+	{
+		encryption.GET("/status", getEncryptionStatus)
+		encryption.POST("/rotate", rotateEncryptionKey)
+	}
+}
+
+// Cifra o valor com uma chave de dados nova, protegida pela chave ativa do KMS
+func encryptField(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	keyID := keyManager.ActiveKeyID()
+	wrapped, err := keyManager.WrapKey(keyID, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealAESGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedFieldPrefix + keyID + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptField(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(ciphertext, encryptedFieldPrefix) {
+		return "", errors.New("formato de campo cifrado desconhecido")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ciphertext, encryptedFieldPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("campo cifrado malformado")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := keyManager.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openAESGCM(dataKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Lê um campo que pode estar cifrado ou, em registros legados, em texto claro
+func readProtectedField(encrypted, plain sql.NullString) string {
+	if encrypted.Valid && encrypted.String != "" {
+		value, err := decryptField(encrypted.String)
+		if err != nil {
+			logger.Errorf("Falha ao decifrar campo: %v", err)
+			return ""
+		}
+		return value
+	}
+	return plain.String
+}
+
+// Normaliza o valor para que o índice cego encontre variações de formatação
+func normalizeForBlindIndex(field, value string) string {
+	switch field {
+	case "email":
+		return strings.ToLower(strings.TrimSpace(value))
+	case "phone", "tax_id":
+		var digits strings.Builder
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				digits.WriteRune(r)
+			}
+		}
+		return digits.String()
+	default:
+		return strings.TrimSpace(value)
+	}
+}
+
+// Índice cego determinístico (HMAC-SHA256) que permite buscas exatas sem
+// expor o valor em texto claro
+func blindIndex(field, value string) string {
+	normalized := normalizeForBlindIndex(field, value)
+	if normalized == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, keyManager.BlindIndexKey())
+	mac.Write([]byte(field + ":" + normalized))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Campos sensíveis do cliente já cifrados e indexados para gravação
+type protectedCustomerPII struct {
+	EmailEnc, EmailBidx string
+	PhoneEnc, PhoneBidx string
+	TaxIDEnc, TaxIDBidx string
+}
+
+func protectCustomerPII(email, phone, taxID string) (protectedCustomerPII, error) {
+	var p protectedCustomerPII
+	var err error
+	if p.EmailEnc, err = encryptField(email); err != nil {
+		return p, err
+	}
+	if p.PhoneEnc, err = encryptField(phone); err != nil {
+		return p, err
+	}
+	if p.TaxIDEnc, err = encryptField(taxID); err != nil {
+		return p, err
+	}
+	p.EmailBidx = blindIndex("email", email)
+	p.PhoneBidx = blindIndex("phone", phone)
+	p.TaxIDBidx = blindIndex("tax_id", taxID)
+	return p, nil
+}
+
+// Busca exata de clientes por email, telefone ou CPF/CNPJ via índice cego
+func lookupCustomers(c *gin.Context) {
+	var field, value string
+	for _, candidate := range []string{"email", "phone", "tax_id"} {
+		if v := c.Query(candidate); v != "" {
+			field, value = candidate, v
+			break
+		}
+	}
+	if field == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe email, phone ou tax_id"})
+		return
+	}
+
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT id, name FROM customers WHERE tenant_id = $1 AND %s_bidx = $2", field),
+		tenantFromContext(c), blindIndex(field, value),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar clientes"})
+		return
+	}
+	defer rows.Close()
+
+	customers := []gin.H{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler dados do cliente"})
+			return
+		}
+		customers = append(customers, gin.H{"id": id, "name": name})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customers": customers})
+}
+
+func listCustomerCustomFields(c *gin.Context) {
+	if !customerExists(tenantFromContext(c), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT name, value, value_enc, sensitive, updated_at FROM customer_custom_fields
+		WHERE customer_id::text = $1 AND customer_id IN (SELECT id FROM customers WHERE tenant_id = $2)
+		ORDER BY name`,
+		c.Param("id"), tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar campos personalizados"})
+		return
+	}
+	defer rows.Close()
+
+	fields := []gin.H{}
+	for rows.Next() {
+		var name string
+		var value, valueEnc sql.NullString
+		var sensitive bool
+		var updatedAt time.Time
+		if err := rows.Scan(&name, &value, &valueEnc, &sensitive, &updatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler campo personalizado"})
+			return
+		}
+		fields = append(fields, gin.H{
+			"name":       name,
+			"value":      readProtectedField(valueEnc, value),
+			"sensitive":  sensitive,
+			"updated_at": updatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"custom_fields": fields})
+}
+
+func setCustomerCustomField(c *gin.Context) {
+	var request struct {
+		Name      string `json:"name" binding:"required"`
+		Value     string `json:"value"`
+		Sensitive bool   `json:"sensitive"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !customerExists(tenantFromContext(c), c.Param("id")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	var plain, encrypted sql.NullString
+	if request.Sensitive {
+		value, err := encryptField(request.Value)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao cifrar campo"})
+			return
+		}
+		encrypted = sql.NullString{String: value, Valid: value != ""}
+	} else {
+		plain = sql.NullString{String: request.Value, Valid: true}
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO customer_custom_fields (customer_id, name, value, value_enc, sensitive, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (customer_id, name) DO UPDATE
+		SET value = EXCLUDED.value, value_enc = EXCLUDED.value_enc,
+			sensitive = EXCLUDED.sensitive, updated_at = EXCLUDED.updated_at`,
+		c.Param("id"), request.Name, plain, encrypted, request.Sensitive,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao salvar campo personalizado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Campo personalizado salvo com sucesso"})
+}
+
+func getEncryptionStatus(c *gin.Context) {
+	status := []gin.H{}
+	for _, col := range encryptedColumns {
+		rows, err := db.Query(fmt.Sprintf(`
+			SELECT COALESCE(split_part(%[1]s, ':', 3), 'plaintext'), COUNT(*)
+			FROM %[2]s
+			WHERE %[1]s IS NOT NULL OR (%[3]s IS NOT NULL AND %[4]s)
+			GROUP BY 1`,
+			col.Encrypted, col.Table, col.Plain, col.BackfillFilter,
+		))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao consultar status da criptografia"})
+			return
+		}
+
+		byKey := gin.H{}
+		for rows.Next() {
+			var keyID string
+			var count int64
+			if err := rows.Scan(&keyID, &count); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao consultar status da criptografia"})
+				return
+			}
+			byKey[keyID] = count
+		}
+		rows.Close()
+
+		status = append(status, gin.H{"table": col.Table, "column": col.Plain, "rows_by_key": byKey})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"active_key_id": keyManager.ActiveKeyID(), "columns": status})
+}
+
+// Gera uma nova chave mestra e recifra os dados em segundo plano
+func rotateEncryptionKey(c *gin.Context) {
+	rotating, ok := keyManager.(RotatingKeyManager)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "O KMS configurado não permite rotação pela API"})
+		return
+	}
+
+	previous := keyManager.ActiveKeyID()
+	keyID, err := rotating.RotateKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao gerar nova chave"})
+		return
+	}
+
+	recordAudit("", c.GetString("user_id"), "encryption_key_rotated", "keyring", keyID, gin.H{"previous_key_id": previous})
+
+	// Sob o mesmo lock da tarefa agendada: se ela já estiver em execução, esta
+	// é ignorada e a própria tarefa conclui a recifragem
+	go runScheduledJob(context.Background(), ScheduledJob{Name: fieldReencryptionJob, Run: reencryptAllColumns})
+
+	c.JSON(http.StatusAccepted, gin.H{"active_key_id": keyID, "message": "Recifragem iniciada em segundo plano"})
+}
+
+// Recifra, em lotes, valores protegidos por chaves antigas e cifra valores
+// legados ainda em texto claro. Registros que não podem ser decifrados ou
+// gravados são pulados e reportados no log e no audit trail.
+func reencryptAllColumns(ctx context.Context) error {
+	failures := gin.H{}
+	for _, col := range encryptedColumns {
+		total, failed, err := reencryptColumn(ctx, col)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", col.Table, col.Encrypted, err)
+		}
+		if total > 0 {
+			logger.Infof("Recifrados %d valores de %s.%s", total, col.Table, col.Encrypted)
+		}
+		if len(failed) > 0 {
+			logger.Errorf("Recifragem de %s.%s: %d registros pulados", col.Table, col.Encrypted, len(failed))
+			failures[col.Table+"."+col.Encrypted] = failed
+		}
+	}
+
+	if len(failures) > 0 {
+		recordAudit("", "system", "reencryption_failures", "keyring", keyManager.ActiveKeyID(), gin.H{"failed_rows": failures})
+	}
+	return nil
+}
+
+// Retorna o total recifrado e os IDs dos registros pulados por falha
+func reencryptColumn(ctx context.Context, col encryptedColumn) (int, []int64, error) {
+	total := 0
+	failed := []int64{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, failed, err
+		}
+
+		activeKeyID := keyManager.ActiveKeyID()
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+			SELECT id, %[1]s, %[2]s FROM %[3]s
+			WHERE ((%[1]s IS NOT NULL AND split_part(%[1]s, ':', 3) <> $1)
+				OR (%[1]s IS NULL AND %[2]s IS NOT NULL AND %[4]s))
+				AND id <> ALL($3)
+			LIMIT $2`,
+			col.Encrypted, col.Plain, col.Table, col.BackfillFilter,
+		), activeKeyID, reencryptionBatchSize, pq.Array(failed))
+		if err != nil {
+			return total, failed, err
+		}
+
+		type pending struct {
+			id               int64
+			value            string
+			encrypted, plain sql.NullString
+		}
+		var batch []pending
+		read := 0
+		for rows.Next() {
+			read++
+			var id int64
+			var encrypted, plain sql.NullString
+			if err := rows.Scan(&id, &encrypted, &plain); err != nil {
+				rows.Close()
+				return total, failed, err
+			}
+			value := plain.String
+			if encrypted.Valid {
+				if value, err = decryptField(encrypted.String); err != nil {
+					logger.Errorf("Recifragem de %s.%s, registro %d: %v", col.Table, col.Encrypted, id, err)
+					failed = append(failed, id)
+					continue
+				}
+			}
+			batch = append(batch, pending{id, value, encrypted, plain})
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return total, failed, err
+		}
+
+		if read == 0 {
+			return total, failed, nil
+		}
+
+		for _, p := range batch {
+			encrypted, err := encryptField(p.value)
+			if err != nil {
+				return total, failed, err
+			}
+
+			// Só grava se o registro não mudou desde a leitura; uma escrita
+			// concorrente já usa a chave ativa ou é relida no próximo lote
+			set := fmt.Sprintf("%s = NULLIF($1, ''), %s = NULL", col.Encrypted, col.Plain)
+			args := []interface{}{encrypted, p.id, p.encrypted, p.plain}
+			if col.BlindIndex != "" {
+				set += fmt.Sprintf(", %s = NULLIF($5, '')", col.BlindIndex)
+				args = append(args, blindIndex(col.Field, p.value))
+			}
+			query := fmt.Sprintf("UPDATE %s SET %s WHERE id = $2 AND %s IS NOT DISTINCT FROM $3 AND %s IS NOT DISTINCT FROM $4",
+				col.Table, set, col.Encrypted, col.Plain)
+			result, err := db.ExecContext(ctx, query, args...)
+			if err != nil {
+				if ctx.Err() != nil {
+					return total, failed, ctx.Err()
+				}
+				logger.Errorf("Recifragem de %s.%s, registro %d: %v", col.Table, col.Encrypted, p.id, err)
+				failed = append(failed, p.id)
+				continue
+			}
+			if affected, _ := result.RowsAffected(); affected == 0 {
+				continue
+			}
+			total++
+		}
+	}
+}
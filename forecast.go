@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	z80 = 1.2816
+	z95 = 1.9600
+
+	forecastBacktestFolds = 3
+)
+
+var errSeriesTooShort = errors.New("série histórica curta demais para previsão")
+
+// Previsão pontual com intervalos de predição de 80% e 95%
+type ForecastPoint struct {
+	Value   float64 `json:"value"`
+	Lower80 float64 `json:"lower_80"`
+	Upper80 float64 `json:"upper_80"`
+	Lower95 float64 `json:"lower_95"`
+	Upper95 float64 `json:"upper_95"`
+}
+
+type ForecastModel interface {
+	Name() string
+	Fit(y []float64) error
+	Forecast(h int) []ForecastPoint
+	Parameters() map[string]float64
+}
+
+// Suavização exponencial aditiva (família ETS): com Trend=false e
+// Seasonal=false é a suavização simples, com Trend é o método de Holt
+// (amortecido) e com Seasonal é o Holt-Winters aditivo
+type AdditiveETS struct {
+	Trend    bool
+	Seasonal bool
+	Period   int
+
+	alpha, beta, gamma, phi float64
+	level, trend            float64
+	season                  []float64
+	sigma                   float64
+	seriesLength            int
+}
+
+func (m *AdditiveETS) Name() string {
+	switch {
+	case m.Seasonal:
+		return "holt_winters"
+	case m.Trend:
+		return "holt_damped"
+	default:
+		return "simple_exponential_smoothing"
+	}
+}
+
+func (m *AdditiveETS) Parameters() map[string]float64 {
+	return map[string]float64{"alpha": m.alpha, "beta": m.beta, "gamma": m.gamma, "phi": m.phi, "sigma": m.sigma}
+}
+
+func (m *AdditiveETS) minLength() int {
+	if m.Seasonal {
+		return 2 * m.Period
+	}
+	return 4
+}
+
+type etsState struct {
+	level, trend float64
+	season       []float64
+}
+
+func (m *AdditiveETS) initialState(y []float64) etsState {
+	var s etsState
+	if !m.Seasonal {
+		s.level = y[0]
+		if m.Trend {
+			s.trend = y[1] - y[0]
+		}
+		return s
+	}
+
+	p := m.Period
+	first, second := mean(y[:p]), mean(y[p:2*p])
+	s.level = first
+	if m.Trend {
+		s.trend = (second - first) / float64(p)
+	}
+	s.season = make([]float64, p)
+	for i := 0; i < p; i++ {
+		s.season[i] = y[i] - first
+	}
+	return s
+}
+
+// Executa as recursões e devolve a soma dos erros quadráticos de um passo
+func (m *AdditiveETS) run(y []float64, alpha, beta, gamma, phi float64) (float64, etsState) {
+	s := m.initialState(y)
+	var sse float64
+	for t := range y {
+		seasonal := 0.0
+		if m.Seasonal {
+			seasonal = s.season[t%m.Period]
+		}
+		predicted := s.level + phi*s.trend + seasonal
+		e := y[t] - predicted
+		sse += e * e
+
+		previousLevel := s.level
+		s.level = previousLevel + phi*s.trend + alpha*e
+		if m.Trend {
+			s.trend = phi*s.trend + beta*e
+		}
+		if m.Seasonal {
+			s.season[t%m.Period] += gamma * e
+		}
+	}
+	return sse, s
+}
+
+// Ajusta os parâmetros por busca em grade minimizando o erro de um passo
+func (m *AdditiveETS) Fit(y []float64) error {
+	if len(y) < m.minLength() {
+		return errSeriesTooShort
+	}
+
+	alphas := []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+	betas, gammas, phis := []float64{0}, []float64{0}, []float64{1}
+	if m.Trend {
+		betas = []float64{0, 0.01, 0.05, 0.1, 0.2}
+		phis = []float64{0.9, 0.95, 0.98, 1}
+	}
+	if m.Seasonal {
+		gammas = []float64{0, 0.05, 0.1, 0.2, 0.3}
+	}
+
+	best := math.Inf(1)
+	for _, alpha := range alphas {
+		for _, beta := range betas {
+			if beta > alpha {
+				continue
+			}
+			for _, gamma := range gammas {
+				if gamma > 1-alpha {
+					continue
+				}
+				for _, phi := range phis {
+					sse, state := m.run(y, alpha, beta, gamma, phi)
+					if sse < best {
+						best = sse
+						m.alpha, m.beta, m.gamma, m.phi = alpha, beta, gamma, phi
+						m.level, m.trend, m.season = state.level, state.trend, state.season
+					}
+				}
+			}
+		}
+	}
+
+	parameters := 1.0
+	if m.Trend {
+		parameters += 3
+	}
+	if m.Seasonal {
+		parameters += 1 + float64(m.Period)
+	}
+	m.sigma = math.Sqrt(best / math.Max(1, float64(len(y))-parameters))
+	m.seriesLength = len(y)
+	return nil
+}
+
+func (m *AdditiveETS) Forecast(h int) []ForecastPoint {
+	points := make([]ForecastPoint, h)
+	dampedSum := 0.0
+	variance := 0.0
+	for i := 1; i <= h; i++ {
+		dampedSum += math.Pow(m.phi, float64(i))
+		value := m.level + dampedSum*m.trend
+		if m.Seasonal {
+			value += m.season[(m.seriesLength+i-1)%m.Period]
+		}
+
+		// Variância do erro em h passos para o ETS aditivo:
+		// sigma² (1 + soma de c_j² para j < h)
+		if i > 1 {
+			j := float64(i - 1)
+			c := m.alpha
+			if m.Trend {
+				if m.phi == 1 {
+					c += m.beta * j
+				} else {
+					c += m.beta * m.phi * (1 - math.Pow(m.phi, j)) / (1 - m.phi)
+				}
+			}
+			if m.Seasonal && (i-1)%m.Period == 0 {
+				c += m.gamma
+			}
+			variance += c * c
+		}
+		points[i-1] = intervalPoint(value, m.sigma*math.Sqrt(1+variance))
+	}
+	return points
+}
+
+// Repete o valor do mesmo período da última temporada
+type SeasonalNaive struct {
+	Period int
+
+	lastSeason   []float64
+	sigma        float64
+	seriesLength int
+}
+
+func (m *SeasonalNaive) Name() string { return "seasonal_naive" }
+
+func (m *SeasonalNaive) Parameters() map[string]float64 {
+	return map[string]float64{"period": float64(m.Period), "sigma": m.sigma}
+}
+
+func (m *SeasonalNaive) Fit(y []float64) error {
+	if len(y) < 2*m.Period {
+		return errSeriesTooShort
+	}
+	var sse float64
+	for t := m.Period; t < len(y); t++ {
+		e := y[t] - y[t-m.Period]
+		sse += e * e
+	}
+	m.sigma = math.Sqrt(sse / float64(len(y)-m.Period))
+	m.lastSeason = append([]float64(nil), y[len(y)-m.Period:]...)
+	m.seriesLength = len(y)
+	return nil
+}
+
+func (m *SeasonalNaive) Forecast(h int) []ForecastPoint {
+	points := make([]ForecastPoint, h)
+	for i := 0; i < h; i++ {
+		k := float64(i / m.Period)
+		points[i] = intervalPoint(m.lastSeason[i%m.Period], m.sigma*math.Sqrt(k+1))
+	}
+	return points
+}
+
+func intervalPoint(value, sd float64) ForecastPoint {
+	// Receita não é negativa
+	clamp := func(v float64) float64 { return math.Max(0, v) }
+	return ForecastPoint{
+		Value:   clamp(value),
+		Lower80: clamp(value - z80*sd),
+		Upper80: clamp(value + z80*sd),
+		Lower95: clamp(value - z95*sd),
+		Upper95: clamp(value + z95*sd),
+	}
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Modelos candidatos para uma série com a sazonalidade informada
+func forecastCandidates(period int) []func() ForecastModel {
+	return []func() ForecastModel{
+		func() ForecastModel { return &AdditiveETS{} },
+		func() ForecastModel { return &AdditiveETS{Trend: true} },
+		func() ForecastModel { return &SeasonalNaive{Period: period} },
+		func() ForecastModel { return &AdditiveETS{Trend: true, Seasonal: true, Period: period} },
+	}
+}
+
+type BacktestMetrics struct {
+	Model      string  `json:"model"`
+	Folds      int     `json:"folds"`
+	MAE        float64 `json:"mae"`
+	RMSE       float64 `json:"rmse"`
+	MAPE       float64 `json:"mape"`
+	SMAPE      float64 `json:"smape"`
+	Coverage80 float64 `json:"coverage_80"`
+	Coverage95 float64 `json:"coverage_95"`
+}
+
+// Validação com origem móvel: para cada dobra, ajusta até a origem e
+// compara as h previsões seguintes com o realizado
+func backtestModel(newModel func() ForecastModel, y []float64, h int) (BacktestMetrics, error) {
+	metrics := BacktestMetrics{Model: newModel().Name()}
+	var absSum, sqSum, apeSum, sapeSum, in80, in95 float64
+	var n, nPercent int
+
+	for fold := forecastBacktestFolds; fold >= 1; fold-- {
+		origin := len(y) - fold*h
+		if origin <= 0 {
+			continue
+		}
+		model := newModel()
+		if err := model.Fit(y[:origin]); err != nil {
+			continue
+		}
+		end := origin + h
+		if end > len(y) {
+			end = len(y)
+		}
+		for i, point := range model.Forecast(end - origin) {
+			actual := y[origin+i]
+			e := actual - point.Value
+			absSum += math.Abs(e)
+			sqSum += e * e
+			if actual != 0 {
+				apeSum += math.Abs(e / actual)
+				nPercent++
+			}
+			if denominator := math.Abs(actual) + math.Abs(point.Value); denominator > 0 {
+				sapeSum += 2 * math.Abs(e) / denominator
+			}
+			if actual >= point.Lower80 && actual <= point.Upper80 {
+				in80++
+			}
+			if actual >= point.Lower95 && actual <= point.Upper95 {
+				in95++
+			}
+			n++
+		}
+		metrics.Folds++
+	}
+
+	if n == 0 {
+		return metrics, errSeriesTooShort
+	}
+	metrics.MAE = absSum / float64(n)
+	metrics.RMSE = math.Sqrt(sqSum / float64(n))
+	metrics.SMAPE = sapeSum / float64(n)
+	metrics.Coverage80 = in80 / float64(n)
+	metrics.Coverage95 = in95 / float64(n)
+	if nPercent > 0 {
+		metrics.MAPE = apeSum / float64(nPercent)
+	}
+	return metrics, nil
+}
+
+type SeriesForecast struct {
+	Model      string             `json:"model"`
+	Parameters map[string]float64 `json:"parameters"`
+	Backtest   []BacktestMetrics  `json:"backtest"`
+	Forecast   []ForecastPoint    `json:"forecast"`
+}
+
+// Escolhe o modelo com menor erro absoluto médio no backtest e o reajusta
+// na série completa. Séries curtas demais para backtest usam Holt.
+func forecastSeries(y []float64, period, h int) (*SeriesForecast, error) {
+	result := &SeriesForecast{}
+	var best func() ForecastModel
+	bestMAE := math.Inf(1)
+
+	for _, candidate := range forecastCandidates(period) {
+		metrics, err := backtestModel(candidate, y, h)
+		if err != nil {
+			continue
+		}
+		result.Backtest = append(result.Backtest, metrics)
+		if metrics.MAE < bestMAE {
+			bestMAE = metrics.MAE
+			best = candidate
+		}
+	}
+	if best == nil {
+		best = func() ForecastModel { return &AdditiveETS{Trend: true} }
+	}
+
+	model := best()
+	if err := model.Fit(y); err != nil {
+		model = &AdditiveETS{}
+		if err := model.Fit(y); err != nil {
+			return nil, err
+		}
+	}
+	result.Model = model.Name()
+	result.Parameters = model.Parameters()
+	result.Forecast = model.Forecast(h)
+	return result, nil
+}
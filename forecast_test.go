@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func seasonalSeries(n, period int, noise float64, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	y := make([]float64, n)
+	for t := range y {
+		y[t] = 1000 + 5*float64(t) + 200*math.Sin(2*math.Pi*float64(t)/float64(period)) + noise*rng.NormFloat64()
+	}
+	return y
+}
+
+func TestHoltWintersRecoversSeasonalPattern(t *testing.T) {
+	y := seasonalSeries(96, 12, 0, 1)
+	model := &AdditiveETS{Trend: true, Seasonal: true, Period: 12}
+	assert.NoError(t, model.Fit(y[:84]))
+
+	for i, point := range model.Forecast(12) {
+		assert.InDelta(t, y[84+i], point.Value, 30)
+		assert.True(t, point.Lower95 <= point.Lower80 && point.Lower80 <= point.Value)
+		assert.True(t, point.Value <= point.Upper80 && point.Upper80 <= point.Upper95)
+	}
+}
+
+func TestForecastSeriesSelectsSeasonalModel(t *testing.T) {
+	y := seasonalSeries(120, 12, 20, 2)
+	result, err := forecastSeries(y, 12, 12)
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"holt_winters", "seasonal_naive"}, result.Model)
+	assert.Len(t, result.Forecast, 12)
+	assert.Len(t, result.Backtest, 4)
+
+	for _, metrics := range result.Backtest {
+		if metrics.Model == result.Model {
+			assert.Equal(t, forecastBacktestFolds, metrics.Folds)
+			assert.GreaterOrEqual(t, metrics.Coverage95, 0.8)
+		}
+	}
+}
+
+func TestForecastSeriesTooShort(t *testing.T) {
+	_, err := forecastSeries([]float64{10, 20}, 12, 6)
+	assert.ErrorIs(t, err, errSeriesTooShort)
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultFunnelWindow = 90 * 24 * time.Hour
+
+// Etapas de um funil em ordem e o estágio terminal de perda
+type funnelDefinition struct {
+	Steps     []string
+	LostStage string
+}
+
+var funnelDefinitions = map[string]funnelDefinition{
+	"opportunity": {
+		Steps:     []string{"prospecting", "qualification", "proposal", "negotiation", "closed_won"},
+		LostStage: "closed_lost",
+	},
+	"lifecycle": {
+		Steps:     []string{"lead", "qualified", "opportunity", "customer"},
+		LostStage: "disqualified",
+	},
+}
+
+var lifecycleStages = map[string]bool{
+	"lead":         true,
+	"qualified":    true,
+	"opportunity":  true,
+	"customer":     true,
+	"disqualified": true,
+	"churned":      true,
+}
+
+func createFunnelTables() {
+	_, err := db.Exec(`
+		ALTER TABLE customers ADD COLUMN IF NOT EXISTS lifecycle_stage VARCHAR(20) NOT NULL DEFAULT 'lead';
+
+		CREATE TABLE IF NOT EXISTS customer_lifecycle_history (
+			id SERIAL PRIMARY KEY,
+			customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+			from_stage VARCHAR(20),
+			to_stage VARCHAR(20) NOT NULL,
+			reason VARCHAR(200),
+			changed_by VARCHAR(100),
+			changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_customer_lifecycle_history ON customer_lifecycle_history (customer_id, changed_at);
+
+		-- Clientes anteriores ao ciclo de vida: todos entram como lead na data
+		-- de cadastro e quem já comprou é convertido na data da primeira venda
+		WITH backfill AS (
+			SELECT c.id, c.created_at,
+				(SELECT MIN(COALESCE(s.date, CURRENT_TIMESTAMP)) FROM sales s WHERE s.customer_id = c.id) AS first_sale
+			FROM customers c
+			WHERE NOT EXISTS (SELECT 1 FROM customer_lifecycle_history h WHERE h.customer_id = c.id)
+		), initial AS (
+			INSERT INTO customer_lifecycle_history (customer_id, to_stage, changed_by, changed_at)
+			SELECT id, 'lead', 'sistema', COALESCE(created_at, CURRENT_TIMESTAMP) FROM backfill
+		), converted AS (
+			INSERT INTO customer_lifecycle_history (customer_id, from_stage, to_stage, changed_by, changed_at)
+			SELECT id, 'lead', 'customer', 'sistema', first_sale FROM backfill WHERE first_sale IS NOT NULL
+		)
+		UPDATE customers SET lifecycle_stage = 'customer'
+		FROM backfill b
+		WHERE customers.id = b.id AND b.first_sale IS NOT NULL;
+
+		-- Estágio inicial de todo cliente novo
+		CREATE OR REPLACE FUNCTION record_initial_lifecycle_stage() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO customer_lifecycle_history (customer_id, to_stage, changed_by, changed_at)
+			VALUES (NEW.id, NEW.lifecycle_stage, 'sistema', COALESCE(NEW.created_at, CURRENT_TIMESTAMP));
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS customers_initial_lifecycle ON customers;
+		CREATE TRIGGER customers_initial_lifecycle AFTER INSERT ON customers
+			FOR EACH ROW EXECUTE FUNCTION record_initial_lifecycle_stage();
+
+		-- A primeira venda converte o contato em cliente
+		CREATE OR REPLACE FUNCTION convert_customer_on_sale() RETURNS trigger AS $$
+		DECLARE
+			previous VARCHAR(20);
+		BEGIN
+			SELECT lifecycle_stage INTO previous FROM customers WHERE id = NEW.customer_id FOR UPDATE;
+			IF previous IS NOT NULL AND previous <> 'customer' THEN
+				UPDATE customers SET lifecycle_stage = 'customer' WHERE id = NEW.customer_id;
+				INSERT INTO customer_lifecycle_history (customer_id, from_stage, to_stage, changed_by, changed_at)
+				VALUES (NEW.customer_id, previous, 'customer', 'sistema', COALESCE(NEW.date, CURRENT_TIMESTAMP));
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS sales_convert_customer ON sales;
+		CREATE TRIGGER sales_convert_customer AFTER INSERT ON sales
+			FOR EACH ROW EXECUTE FUNCTION convert_customer_on_sale();
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Move o cliente no ciclo de vida. Desqualificação exige motivo, usado na
+// análise de perdas do funil.
+func setCustomerLifecycleStage(c *gin.Context) {
+	var request struct {
+		Stage  string `json:"stage" binding:"required"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !lifecycleStages[request.Stage] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estágio de ciclo de vida inválido"})
+		return
+	}
+	if request.Stage == "disqualified" && request.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe o motivo da desqualificação"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar ciclo de vida"})
+		return
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow(
+		"SELECT lifecycle_stage FROM customers WHERE id::text = $1 AND tenant_id = $2 FOR UPDATE",
+		c.Param("id"), tenantFromContext(c),
+	).Scan(&previous)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar cliente"})
+		return
+	}
+	if previous == request.Stage {
+		c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("id"), "stage": previous})
+		return
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE customers SET lifecycle_stage = $2 WHERE id::text = $1",
+		c.Param("id"), request.Stage,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar ciclo de vida"})
+		return
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO customer_lifecycle_history (customer_id, from_stage, to_stage, reason, changed_by)
+		VALUES ($1::integer, $2, $3, NULLIF($4, ''), $5)`,
+		c.Param("id"), previous, request.Stage, request.Reason, c.GetString("user_id"),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar histórico"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar ciclo de vida"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"customer_id": c.Param("id"), "previous_stage": previous, "stage": request.Stage})
+}
+
+type stageTransition struct {
+	Stage  string
+	At     time.Time
+	Reason string
+}
+
+type FunnelReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+type FunnelStep struct {
+	Stage   string `json:"stage"`
+	Entered int    `json:"entered"`
+	// Fração dos que entraram na etapa e chegaram à seguinte
+	ConversionRate       *float64       `json:"conversion_rate" graphql:"conversionRate"`
+	CumulativeConversion float64        `json:"cumulative_conversion" graphql:"cumulativeConversion"`
+	MedianDaysInStage    *float64       `json:"median_days_in_stage" graphql:"medianDaysInStage"`
+	DropOffs             int            `json:"drop_offs" graphql:"dropOffs"`
+	DropOffReasons       []FunnelReason `json:"drop_off_reasons" graphql:"dropOffReasons"`
+}
+
+type FunnelResult struct {
+	Entities            int          `json:"entities"`
+	Steps               []FunnelStep `json:"steps"`
+	OverallConversion   float64      `json:"overall_conversion" graphql:"overallConversion"`
+	MedianDaysToConvert *float64     `json:"median_days_to_convert" graphql:"medianDaysToConvert"`
+}
+
+func medianPointer(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	m := median(values)
+	return &m
+}
+
+// Calcula o funil a partir das transições de cada entidade (em ordem
+// cronológica). Uma entidade que chegou a uma etapa conta como tendo passado
+// pelas anteriores; a perda é atribuída à etapa mais avançada alcançada.
+func computeFunnel(def funnelDefinition, histories map[string][]stageTransition) FunnelResult {
+	index := map[string]int{}
+	for i, stage := range def.Steps {
+		index[stage] = i
+	}
+
+	last := len(def.Steps) - 1
+	entered := make([]int, len(def.Steps))
+	durations := make([][]float64, len(def.Steps))
+	dropOffs := make([]int, len(def.Steps))
+	reasons := make([]map[string]int, len(def.Steps))
+	var toConvert []float64
+	entities := 0
+
+	for _, transitions := range histories {
+		reached := -1
+		for k, t := range transitions {
+			i, ok := index[t.Stage]
+			if !ok {
+				continue
+			}
+			if i > reached {
+				reached = i
+				if i == last {
+					toConvert = append(toConvert, t.At.Sub(transitions[0].At).Hours()/24)
+				}
+			}
+			if k+1 < len(transitions) && i != last {
+				durations[i] = append(durations[i], transitions[k+1].At.Sub(t.At).Hours()/24)
+			}
+		}
+		if reached < 0 {
+			continue
+		}
+
+		entities++
+		for i := 0; i <= reached; i++ {
+			entered[i]++
+		}
+		final := transitions[len(transitions)-1]
+		if final.Stage == def.LostStage && reached < last {
+			dropOffs[reached]++
+			if reasons[reached] == nil {
+				reasons[reached] = map[string]int{}
+			}
+			reason := final.Reason
+			if reason == "" {
+				reason = "sem motivo informado"
+			}
+			reasons[reached][reason]++
+		}
+	}
+
+	result := FunnelResult{Entities: entities, Steps: make([]FunnelStep, len(def.Steps))}
+	for i, stage := range def.Steps {
+		step := FunnelStep{
+			Stage:             stage,
+			Entered:           entered[i],
+			MedianDaysInStage: medianPointer(durations[i]),
+			DropOffs:          dropOffs[i],
+			DropOffReasons:    []FunnelReason{},
+		}
+		if entered[0] > 0 {
+			step.CumulativeConversion = float64(entered[i]) / float64(entered[0])
+		}
+		if i < last && entered[i] > 0 {
+			rate := float64(entered[i+1]) / float64(entered[i])
+			step.ConversionRate = &rate
+		}
+		for reason, count := range reasons[i] {
+			step.DropOffReasons = append(step.DropOffReasons, FunnelReason{Reason: reason, Count: count})
+		}
+		sort.Slice(step.DropOffReasons, func(a, b int) bool {
+			if step.DropOffReasons[a].Count != step.DropOffReasons[b].Count {
+				return step.DropOffReasons[a].Count > step.DropOffReasons[b].Count
+			}
+			return step.DropOffReasons[a].Reason < step.DropOffReasons[b].Reason
+		})
+		result.Steps[i] = step
+	}
+	result.OverallConversion = result.Steps[last].CumulativeConversion
+	result.MedianDaysToConvert = medianPointer(toConvert)
+	return result
+}
+
+// Escopo do funil: entidades criadas no intervalo, opcionalmente de um
+// responsável
+type funnelScope struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Owner string    `json:"owner,omitempty"`
+}
+
+func loadFunnelHistories(tenantID, funnelType string, scope funnelScope) (map[string][]stageTransition, error) {
+	query := `
+		SELECT o.id::text, h.to_stage, h.changed_at,
+			CASE WHEN h.to_stage = 'closed_lost' THEN COALESCE(o.lost_reason, '') ELSE '' END
+		FROM opportunities o
+		JOIN opportunity_stage_history h ON h.opportunity_id = o.id
+		WHERE o.tenant_id = $1 AND o.created_at >= $2 AND o.created_at < $3
+			AND ($4 = '' OR o.owner_id::text = $4)
+		ORDER BY o.id, h.changed_at, h.id`
+	if funnelType == "lifecycle" {
+		query = `
+			SELECT c.id::text, h.to_stage, h.changed_at, COALESCE(h.reason, '')
+			FROM customers c
+			JOIN customer_lifecycle_history h ON h.customer_id = c.id
+			WHERE c.tenant_id = $1 AND c.created_at >= $2 AND c.created_at < $3
+				AND ($4 = '' OR c.owner_id::text = $4)
+			ORDER BY c.id, h.changed_at, h.id`
+	}
+
+	rows, err := db.Query(query, tenantID, scope.From, scope.To, scope.Owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := map[string][]stageTransition{}
+	for rows.Next() {
+		var id string
+		var t stageTransition
+		if err := rows.Scan(&id, &t.Stage, &t.At, &t.Reason); err != nil {
+			return nil, err
+		}
+		histories[id] = append(histories[id], t)
+	}
+	return histories, rows.Err()
+}
+
+func computeTenantFunnel(tenantID, funnelType string, scope funnelScope) (FunnelResult, error) {
+	key := fmt.Sprintf("funnel:%s:%s:%d:%d:%s", tenantID, funnelType, scope.From.Unix(), scope.To.Unix(), scope.Owner)
+	if value, found := globalCache.Get(key); found {
+		return value.(FunnelResult), nil
+	}
+
+	histories, err := loadFunnelHistories(tenantID, funnelType, scope)
+	if err != nil {
+		return FunnelResult{}, err
+	}
+	result := computeFunnel(funnelDefinitions[funnelType], histories)
+	globalCache.Set(key, result, 10*time.Minute)
+	return result, nil
+}
+
+func parseFunnelDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Lê from/to/owner com o prefixo informado; campos ausentes herdam de base
+func parseFunnelScope(c *gin.Context, prefix string, base funnelScope) (funnelScope, error) {
+	scope := base
+	var err error
+	if scope.From, err = parseFunnelDate(c.Query(prefix+"from"), base.From); err != nil {
+		return scope, fmt.Errorf("%sfrom deve estar no formato AAAA-MM-DD", prefix)
+	}
+	to := base.To
+	if value := c.Query(prefix + "to"); value != "" {
+		// Data final inclusiva
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			return scope, fmt.Errorf("%sto deve estar no formato AAAA-MM-DD", prefix)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	scope.To = to
+	if owner := c.Query(prefix + "owner"); owner != "" {
+		scope.Owner = owner
+		if owner == "me" {
+			scope.Owner = c.GetString("user_id")
+		}
+	}
+	if !scope.From.Before(scope.To) {
+		return scope, fmt.Errorf("%sfrom deve ser anterior a %sto", prefix, prefix)
+	}
+	return scope, nil
+}
+
+// Funil de oportunidades (type=opportunity) ou de ciclo de vida
+// (type=lifecycle). Parâmetros compare_from, compare_to e compare_owner
+// calculam um segundo funil para comparação.
+func getFunnelAnalytics(c *gin.Context) {
+	funnelType := c.DefaultQuery("type", "opportunity")
+	if _, ok := funnelDefinitions[funnelType]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type deve ser opportunity ou lifecycle"})
+		return
+	}
+
+	now := time.Now()
+	defaults := funnelScope{From: truncatePeriod(now.Add(-defaultFunnelWindow), "day"), To: truncatePeriod(now, "day").AddDate(0, 0, 1)}
+	scope, err := parseFunnelScope(c, "", defaults)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenantID := tenantFromContext(c)
+	current, err := computeTenantFunnel(tenantID, funnelType, scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular funil"})
+		return
+	}
+	response := gin.H{
+		"type":   funnelType,
+		"scope":  scope,
+		"funnel": current,
+	}
+
+	if c.Query("compare_from") != "" || c.Query("compare_to") != "" || c.Query("compare_owner") != "" {
+		comparisonScope, err := parseFunnelScope(c, "compare_", scope)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		comparison, err := computeTenantFunnel(tenantID, funnelType, comparisonScope)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular funil de comparação"})
+			return
+		}
+		response["comparison"] = gin.H{
+			"scope":                   comparisonScope,
+			"funnel":                  comparison,
+			"overall_conversion_diff": current.OverallConversion - comparison.OverallConversion,
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
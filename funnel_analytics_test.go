@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeFunnel(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2026, 9, 1+n, 0, 0, 0, 0, time.UTC) }
+	histories := map[string][]stageTransition{
+		"won": {
+			{Stage: "prospecting", At: day(0)},
+			{Stage: "qualification", At: day(2)},
+			{Stage: "proposal", At: day(6)},
+			{Stage: "negotiation", At: day(10)},
+			{Stage: "closed_won", At: day(20)},
+		},
+		"lost_price": {
+			{Stage: "prospecting", At: day(0)},
+			{Stage: "qualification", At: day(4)},
+			{Stage: "proposal", At: day(8)},
+			{Stage: "closed_lost", At: day(9), Reason: "preço"},
+		},
+		"lost_early": {
+			{Stage: "prospecting", At: day(0)},
+			{Stage: "closed_lost", At: day(1)},
+		},
+		// Criada direto em proposta: conta como tendo passado pelas anteriores
+		"skipped": {
+			{Stage: "proposal", At: day(3)},
+		},
+	}
+
+	funnel := computeFunnel(funnelDefinitions["opportunity"], histories)
+	assert.Equal(t, 4, funnel.Entities)
+	assert.Equal(t, []int{4, 3, 3, 1, 1}, []int{
+		funnel.Steps[0].Entered, funnel.Steps[1].Entered, funnel.Steps[2].Entered,
+		funnel.Steps[3].Entered, funnel.Steps[4].Entered,
+	})
+	assert.InDelta(t, 0.75, *funnel.Steps[0].ConversionRate, 1e-9)
+	assert.Nil(t, funnel.Steps[4].ConversionRate)
+	assert.InDelta(t, 0.25, funnel.OverallConversion, 1e-9)
+	assert.InDelta(t, 20, *funnel.MedianDaysToConvert, 1e-9)
+
+	// Tempo em qualificação: 4 dias (won) e 4 dias (lost_price)
+	assert.InDelta(t, 4, *funnel.Steps[1].MedianDaysInStage, 1e-9)
+
+	assert.Equal(t, 1, funnel.Steps[0].DropOffs)
+	assert.Equal(t, []FunnelReason{{Reason: "sem motivo informado", Count: 1}}, funnel.Steps[0].DropOffReasons)
+	assert.Equal(t, []FunnelReason{{Reason: "preço", Count: 1}}, funnel.Steps[2].DropOffReasons)
+}
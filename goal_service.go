@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Abaixo de um dia decorrido a projeção de ritmo não é calculada
+const minGoalPaceElapsed = 24 * time.Hour
+
+// Apuração de cada métrica de meta a partir dos dados reais. Parâmetros:
+// $1 tenant, $2 início, $3 fim (exclusivo), $4 responsáveis. Vendas e
+// clientes são atribuídos ao responsável pelo cliente.
+var goalMetricQueries = map[string]string{
+	"revenue": `SELECT COALESCE(SUM(s.amount), 0) FROM sales s JOIN customers c ON c.id = s.customer_id
+		WHERE c.tenant_id = $1 AND c.owner_id::text = ANY($4) AND s.date >= $2 AND s.date < $3`,
+	"orders": `SELECT COUNT(*) FROM sales s JOIN customers c ON c.id = s.customer_id
+		WHERE c.tenant_id = $1 AND c.owner_id::text = ANY($4) AND s.date >= $2 AND s.date < $3`,
+	"new_customers": `SELECT COUNT(*) FROM customers
+		WHERE tenant_id = $1 AND owner_id::text = ANY($4) AND created_at >= $2 AND created_at < $3`,
+	"deals_won": `SELECT COUNT(*) FROM opportunities
+		WHERE tenant_id = $1 AND owner_id::text = ANY($4) AND stage = 'closed_won' AND closed_at >= $2 AND closed_at < $3`,
+	"won_amount": `SELECT COALESCE(SUM(amount), 0) FROM opportunities
+		WHERE tenant_id = $1 AND owner_id::text = ANY($4) AND stage = 'closed_won' AND closed_at >= $2 AND closed_at < $3`,
+}
+
+// Meta de uma métrica em um período (mês "2026-10" ou trimestre
+// "2026-Q4"), de um vendedor (OwnerID) ou de um time (TeamID)
+type Goal struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Metric    string     `json:"metric"`
+	Target    float64    `json:"target"`
+	Period    string     `json:"period"`
+	OwnerID   *string    `json:"owner_id"`
+	TeamID    *int       `json:"team_id"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReachedAt *time.Time `json:"reached_at"`
+}
+
+type GoalAttainment struct {
+	Actual     float64 `json:"actual"`
+	Target     float64 `json:"target"`
+	Attainment float64 `json:"attainment"`
+	Remaining  float64 `json:"remaining"`
+	// Fração do período já decorrida
+	Elapsed float64 `json:"elapsed"`
+	// Projeção linear no ritmo atual até o fim do período
+	ProjectedActual     *float64 `json:"projected_actual"`
+	ProjectedAttainment *float64 `json:"projected_attainment"`
+	// Ritmo diário necessário no restante do período
+	RequiredDailyPace *float64 `json:"required_daily_pace"`
+	Status            string   `json:"status"`
+	Message           string   `json:"message"`
+}
+
+type GoalWithAttainment struct {
+	Goal
+	Attainment GoalAttainment `json:"attainment"`
+}
+
+// Calcula atingimento e projeção de ritmo da meta no instante now.
+// Status: reached, on_track, behind, not_started ou missed.
+func computeGoalAttainment(actual, target float64, start, end, now time.Time) GoalAttainment {
+	a := GoalAttainment{
+		Actual:     actual,
+		Target:     target,
+		Attainment: safeDivide(actual, target),
+		Remaining:  math.Max(target-actual, 0),
+	}
+
+	total := end.Sub(start)
+	elapsed := now.Sub(start)
+	switch {
+	case elapsed <= 0:
+		a.Status, a.Message = "not_started", "O período da meta ainda não começou"
+		return a
+	case elapsed >= total:
+		a.Elapsed = 1
+	default:
+		a.Elapsed = elapsed.Seconds() / total.Seconds()
+	}
+
+	if elapsed >= minGoalPaceElapsed {
+		projected := actual / a.Elapsed
+		projectedAttainment := safeDivide(projected, target)
+		a.ProjectedActual, a.ProjectedAttainment = &projected, &projectedAttainment
+	}
+	if a.Elapsed < 1 && a.Remaining > 0 {
+		pace := a.Remaining / end.Sub(now).Hours() * 24
+		a.RequiredDailyPace = &pace
+	}
+
+	switch {
+	case actual >= target:
+		a.Status, a.Message = "reached", "Meta atingida"
+	case a.Elapsed >= 1:
+		a.Status = "missed"
+		a.Message = fmt.Sprintf("Período encerrado com %.0f%% da meta", a.Attainment*100)
+	case a.ProjectedAttainment == nil:
+		a.Status, a.Message = "on_track", "Período recém-iniciado"
+	case *a.ProjectedAttainment >= 1:
+		a.Status = "on_track"
+		a.Message = fmt.Sprintf("No ritmo atual, deve atingir %.0f%% da meta", *a.ProjectedAttainment*100)
+	default:
+		a.Status = "behind"
+		a.Message = fmt.Sprintf("No ritmo atual, deve atingir %.0f%% da meta", *a.ProjectedAttainment*100)
+	}
+	return a
+}
+
+func createGoalTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS goals (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			name VARCHAR(100) NOT NULL,
+			metric VARCHAR(30) NOT NULL,
+			target DOUBLE PRECISION NOT NULL CHECK (target > 0),
+			period VARCHAR(7) NOT NULL,
+			owner_id INTEGER,
+			team_id INTEGER REFERENCES teams(id) ON DELETE CASCADE,
+			created_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			reached_at TIMESTAMP,
+			CHECK ((owner_id IS NULL) <> (team_id IS NULL))
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_goals_owner_unique ON goals (tenant_id, metric, period, owner_id) WHERE owner_id IS NOT NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_goals_team_unique ON goals (tenant_id, metric, period, team_id) WHERE team_id IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS idx_goals_open ON goals (period) WHERE reached_at IS NULL;
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupGoalRoutes(r *gin.Engine) {
+	goals := r.Group("/goals")
+	goals.Use(AuthMiddleware())
+	{
+		goals.POST("", createGoal)
+		goals.GET("", listGoals)
+		goals.GET("/leaderboard", getGoalLeaderboard)
+		goals.GET("/:id", getGoal)
+		goals.DELETE("/:id", deleteGoal)
+	}
+}
+
+const goalColumns = `id, name, metric, target, period, owner_id::text, team_id, created_by::text, created_at, reached_at`
+
+func scanGoal(row interface{ Scan(...interface{}) error }) (Goal, error) {
+	var g Goal
+	var teamID sql.NullInt64
+	var reachedAt sql.NullTime
+	err := row.Scan(&g.ID, &g.Name, &g.Metric, &g.Target, &g.Period, &g.OwnerID, &teamID, &g.CreatedBy, &g.CreatedAt, &reachedAt)
+	if teamID.Valid {
+		id := int(teamID.Int64)
+		g.TeamID = &id
+	}
+	if reachedAt.Valid {
+		g.ReachedAt = &reachedAt.Time
+	}
+	return g, err
+}
+
+func queryGoals(query string, args ...interface{}) ([]Goal, error) {
+	rows, err := db.Query("SELECT "+goalColumns+" FROM goals WHERE "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		g, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// Responsáveis cujos resultados contam para a meta: o vendedor ou os
+// membros do time
+func goalOwners(tenantID string, g Goal) ([]string, error) {
+	if g.OwnerID != nil {
+		return []string{*g.OwnerID}, nil
+	}
+	var ids []string
+	err := db.QueryRow(`
+		SELECT COALESCE(array_agg(m.user_id::text), '{}')
+		FROM team_members m
+		JOIN teams t ON t.id = m.team_id
+		WHERE t.tenant_id = $1 AND t.id = $2`,
+		tenantID, *g.TeamID,
+	).Scan(pq.Array(&ids))
+	return ids, err
+}
+
+func evaluateGoal(tenantID string, g Goal, now time.Time) (GoalWithAttainment, error) {
+	period, err := parseForecastPeriod(g.Period)
+	if err != nil {
+		return GoalWithAttainment{}, err
+	}
+	owners, err := goalOwners(tenantID, g)
+	if err != nil {
+		return GoalWithAttainment{}, err
+	}
+
+	var actual float64
+	end := period.End
+	if now.Before(end) {
+		end = now
+	}
+	if err := db.QueryRow(goalMetricQueries[g.Metric], tenantID, period.Start, end, pq.Array(owners)).Scan(&actual); err != nil {
+		return GoalWithAttainment{}, err
+	}
+	return GoalWithAttainment{Goal: g, Attainment: computeGoalAttainment(actual, g.Target, period.Start, period.End, now)}, nil
+}
+
+func evaluateGoals(tenantID string, goals []Goal, now time.Time) ([]GoalWithAttainment, error) {
+	evaluated := make([]GoalWithAttainment, 0, len(goals))
+	for _, g := range goals {
+		e, err := evaluateGoal(tenantID, g, now)
+		if err != nil {
+			return nil, err
+		}
+		evaluated = append(evaluated, e)
+	}
+	return evaluated, nil
+}
+
+// Metas do período corrente que valem para o usuário: as individuais e as
+// dos times dos quais é membro ou gestor
+func userGoalAttainment(tenantID, userID string, now time.Time) ([]GoalWithAttainment, error) {
+	goals, err := queryGoals(`tenant_id = $1 AND period IN ($3, $4) AND (
+		owner_id::text = $2
+		OR team_id IN (SELECT id FROM teams WHERE tenant_id = $1 AND manager_id::text = $2)
+		OR team_id IN (SELECT team_id FROM team_members WHERE user_id::text = $2))
+		ORDER BY period, metric`,
+		tenantID, userID, now.UTC().Format("2006-01"), currentQuarter(now).Key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateGoals(tenantID, goals, now)
+}
+
+// Destinatários do evento goal_reached: o vendedor ou o time e seu gestor
+func goalRecipients(tenantID string, g Goal) ([]string, error) {
+	recipients, err := goalOwners(tenantID, g)
+	if err != nil || g.TeamID == nil {
+		return recipients, err
+	}
+	var managerID string
+	if err := db.QueryRow("SELECT manager_id::text FROM teams WHERE id = $1", *g.TeamID).Scan(&managerID); err != nil {
+		return nil, err
+	}
+	return append(recipients, managerID), nil
+}
+
+// Marca as metas atingidas nos períodos em andamento e notifica em tempo
+// real. reached_at garante que cada meta gere o evento uma única vez.
+func checkReachedGoals(ctx context.Context) error {
+	now := time.Now()
+	tenants, err := listTenants()
+	if err != nil {
+		return err
+	}
+	for _, tenantID := range tenants {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		goals, err := queryGoals("tenant_id = $1 AND reached_at IS NULL AND period IN ($2, $3)",
+			tenantID, now.UTC().Format("2006-01"), currentQuarter(now).Key)
+		if err != nil {
+			return err
+		}
+		for _, g := range goals {
+			if err := markGoalReached(ctx, tenantID, g, now); err != nil {
+				logger.Errorf("Falha ao verificar meta %d: %v", g.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Grava reached_at só depois de resolver os destinatários, na mesma
+// transação, para que uma falha não deixe a meta marcada sem notificação
+func markGoalReached(ctx context.Context, tenantID string, g Goal, now time.Time) error {
+	e, err := evaluateGoal(tenantID, g, now)
+	if err != nil {
+		return err
+	}
+	if e.Attainment.Status != "reached" {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE goals SET reached_at = $2 WHERE id = $1 AND reached_at IS NULL", g.ID, now.UTC())
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil
+	}
+	reachedAt := now.UTC()
+	e.ReachedAt = &reachedAt
+
+	recipients, err := goalRecipients(tenantID, g)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, userID := range recipients {
+		realtimeHub.Publish(tenantID, userTopic(userID), "goal_reached", e)
+	}
+	logger.Infof("Meta %d (%s) atingida: %.2f de %.2f", g.ID, g.Name, e.Attainment.Actual, g.Target)
+	return nil
+}
+
+// Metas individuais podem ser criadas pelo próprio vendedor ou pelo seu
+// gestor; metas de time, apenas pelo gestor do time
+func canManageGoal(tenantID, userID string, ownerID *string, teamID *int) (bool, error) {
+	if teamID != nil {
+		var managerID string
+		err := db.QueryRow("SELECT manager_id::text FROM teams WHERE id = $1 AND tenant_id = $2", *teamID, tenantID).Scan(&managerID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return managerID == userID, err
+	}
+	if *ownerID == userID {
+		return true, nil
+	}
+	return managesUser(tenantID, userID, *ownerID)
+}
+
+func createGoal(c *gin.Context) {
+	var request struct {
+		Name    string  `json:"name" binding:"required"`
+		Metric  string  `json:"metric" binding:"required"`
+		Target  float64 `json:"target" binding:"required"`
+		Period  string  `json:"period" binding:"required"`
+		OwnerID *int    `json:"owner_id"`
+		TeamID  *int    `json:"team_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := goalMetricQueries[request.Metric]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric deve ser revenue, orders, new_customers, deals_won ou won_amount"})
+		return
+	}
+	if request.Target <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target deve ser positivo"})
+		return
+	}
+	if _, err := parseForecastPeriod(request.Period); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (request.OwnerID == nil) == (request.TeamID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe owner_id ou team_id"})
+		return
+	}
+
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+	var ownerID *string
+	if request.OwnerID != nil {
+		owner := strconv.Itoa(*request.OwnerID)
+		ownerID = &owner
+	}
+	allowed, err := canManageGoal(tenantID, userID, ownerID, request.TeamID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao verificar permissão"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sem permissão para definir essa meta"})
+		return
+	}
+
+	goal, err := scanGoal(db.QueryRow(`
+		INSERT INTO goals (tenant_id, name, metric, target, period, owner_id, team_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+goalColumns,
+		tenantID, request.Name, request.Metric, request.Target, request.Period, request.OwnerID, request.TeamID, userID,
+	))
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Já existe uma meta dessa métrica no período"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar meta"})
+		return
+	}
+
+	recordAudit(tenantID, userID, "goal_created", "goal", strconv.Itoa(goal.ID), gin.H{"metric": goal.Metric, "target": goal.Target, "period": goal.Period})
+	c.JSON(http.StatusCreated, goal)
+}
+
+// Filtros: period, owner (ID ou "me") e team
+func listGoals(c *gin.Context) {
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+	goals, err := queryGoals(`tenant_id = $1 AND ($2 = '' OR period = $2) AND ($3 = '' OR owner_id::text = $3)
+		AND ($4 = '' OR team_id::text = $4)
+		ORDER BY period DESC, metric, id`,
+		tenantFromContext(c), c.Query("period"), owner, c.Query("team"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar metas"})
+		return
+	}
+
+	evaluated, err := evaluateGoals(tenantFromContext(c), goals, time.Now())
+	if err != nil {
+		logger.Errorf("Erro ao apurar metas: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao apurar metas"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"goals": evaluated})
+}
+
+func getGoal(c *gin.Context) {
+	goal, err := scanGoal(db.QueryRow(
+		"SELECT "+goalColumns+" FROM goals WHERE id::text = $1 AND tenant_id = $2",
+		c.Param("id"), tenantFromContext(c),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Meta não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar meta"})
+		return
+	}
+
+	evaluated, err := evaluateGoal(tenantFromContext(c), goal, time.Now())
+	if err != nil {
+		logger.Errorf("Erro ao apurar meta %d: %v", goal.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao apurar meta"})
+		return
+	}
+	c.JSON(http.StatusOK, evaluated)
+}
+
+func deleteGoal(c *gin.Context) {
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+	goal, err := scanGoal(db.QueryRow(
+		"SELECT "+goalColumns+" FROM goals WHERE id::text = $1 AND tenant_id = $2",
+		c.Param("id"), tenantID,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Meta não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar meta"})
+		return
+	}
+
+	allowed, err := canManageGoal(tenantID, userID, goal.OwnerID, goal.TeamID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao verificar permissão"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sem permissão para excluir essa meta"})
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM goals WHERE id = $1", goal.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao excluir meta"})
+		return
+	}
+	recordAudit(tenantID, userID, "goal_deleted", "goal", strconv.Itoa(goal.ID), gin.H{"metric": goal.Metric, "period": goal.Period})
+	c.JSON(http.StatusOK, gin.H{"message": "Meta excluída com sucesso"})
+}
+
+// Ranking por atingimento das metas de uma métrica no período. scope=rep
+// (padrão) compara vendedores; scope=team compara times. Com team=ID,
+// apenas o time ou seus membros entram no ranking.
+func getGoalLeaderboard(c *gin.Context) {
+	period, ok := periodFromQuery(c)
+	if !ok {
+		return
+	}
+	if c.Query("period") == "" {
+		period, _ = parseForecastPeriod(time.Now().UTC().Format("2006-01"))
+	}
+	metric := c.DefaultQuery("metric", "revenue")
+	if _, ok := goalMetricQueries[metric]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Métrica inválida"})
+		return
+	}
+	scope := c.DefaultQuery("scope", "rep")
+	if scope != "rep" && scope != "team" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope deve ser rep ou team"})
+		return
+	}
+
+	tenantID := tenantFromContext(c)
+	goals, err := queryGoals(`tenant_id = $1 AND period = $2 AND metric = $3
+		AND (($4 = 'rep' AND owner_id IS NOT NULL) OR ($4 = 'team' AND team_id IS NOT NULL))
+		AND ($5 = '' OR team_id::text = $5 OR owner_id IN (SELECT user_id FROM team_members WHERE team_id::text = $5))`,
+		tenantID, period.Key, metric, scope, c.Query("team"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar metas"})
+		return
+	}
+	evaluated, err := evaluateGoals(tenantID, goals, time.Now())
+	if err != nil {
+		logger.Errorf("Erro ao apurar metas: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao apurar metas"})
+		return
+	}
+
+	sort.SliceStable(evaluated, func(i, j int) bool {
+		if evaluated[i].Attainment.Attainment != evaluated[j].Attainment.Attainment {
+			return evaluated[i].Attainment.Attainment > evaluated[j].Attainment.Attainment
+		}
+		return evaluated[i].Attainment.Actual > evaluated[j].Attainment.Actual
+	})
+	entries := make([]gin.H, len(evaluated))
+	for i, e := range evaluated {
+		entries[i] = gin.H{
+			"rank":                 i + 1,
+			"goal_id":              e.ID,
+			"name":                 e.Name,
+			"owner_id":             e.OwnerID,
+			"team_id":              e.TeamID,
+			"target":               e.Target,
+			"actual":               e.Attainment.Actual,
+			"attainment":           e.Attainment.Attainment,
+			"projected_attainment": e.Attainment.ProjectedAttainment,
+			"status":               e.Attainment.Status,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"period": period.Key, "metric": metric, "scope": scope, "leaderboard": entries})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeGoalAttainment(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	// 10 de 31 dias decorridos, 26.450 de 100.000: ritmo para 82%
+	a := computeGoalAttainment(26450, 100000, start, end, start.AddDate(0, 0, 10))
+	assert.InDelta(t, 0.2645, a.Attainment, 1e-9)
+	assert.InDelta(t, 0.82, *a.ProjectedAttainment, 1e-3)
+	assert.Equal(t, "behind", a.Status)
+	assert.Equal(t, "No ritmo atual, deve atingir 82% da meta", a.Message)
+	assert.InDelta(t, 73550.0/21, *a.RequiredDailyPace, 1e-6)
+
+	a = computeGoalAttainment(50000, 100000, start, end, start.AddDate(0, 0, 10))
+	assert.Equal(t, "on_track", a.Status)
+
+	a = computeGoalAttainment(100000, 100000, start, end, start.AddDate(0, 0, 20))
+	assert.Equal(t, "reached", a.Status)
+	assert.Nil(t, a.RequiredDailyPace)
+
+	// Primeiras horas do período: sem projeção
+	a = computeGoalAttainment(0, 100000, start, end, start.Add(2*time.Hour))
+	assert.Nil(t, a.ProjectedAttainment)
+	assert.Equal(t, "on_track", a.Status)
+
+	a = computeGoalAttainment(70000, 100000, start, end, end.AddDate(0, 0, 3))
+	assert.Equal(t, "missed", a.Status)
+	assert.Equal(t, 1.0, a.Elapsed)
+
+	a = computeGoalAttainment(0, 100000, start, end, start.AddDate(0, 0, -1))
+	assert.Equal(t, "not_started", a.Status)
+}
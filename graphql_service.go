@@ -1,11 +1,70 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"strconv"
 	"github.com/gin-gonic/gin"
 	"github.com/graphql-go/graphql"
 	"net/http"
 )
 
+type graphqlContextKey string
+
+const (
+	graphqlTenantKey graphqlContextKey = "tenant_id"
+	graphqlUserKey   graphqlContextKey = "user_id"
+)
+
+// Tenant da requisição GraphQL, propagado pelo contexto
+func tenantFromGraphQL(p graphql.ResolveParams) string {
+	if tenantID, ok := p.Context.Value(graphqlTenantKey).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return defaultTenantID
+}
+
+// Usuário autenticado da requisição GraphQL
+func userFromGraphQL(p graphql.ResolveParams) string {
+	userID, _ := p.Context.Value(graphqlUserKey).(string)
+	return userID
+}
+
+// Valor JSON livre, usado nos dados dos widgets, cujo formato depende do tipo
+var jsonScalar = graphql.NewScalar(
+	graphql.ScalarConfig{
+		Name:        "JSON",
+		Description: "Valor JSON arbitrário",
+		Serialize: func(value interface{}) interface{} {
+			return value
+		},
+	},
+)
+
+var featureContributionType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "FeatureContribution",
+		Fields: graphql.Fields{
+			"feature": &graphql.Field{Type: graphql.String},
+			"value":   &graphql.Field{Type: graphql.Float},
+			"impact":  &graphql.Field{Type: graphql.Float},
+			"reason":  &graphql.Field{Type: graphql.String},
+		},
+	},
+)
+
+var explanationType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Explanation",
+		Fields: graphql.Fields{
+			"method":        &graphql.Field{Type: graphql.String},
+			"baseValue":     &graphql.Field{Type: graphql.Float},
+			"summary":       &graphql.Field{Type: graphql.String},
+			"contributions": &graphql.Field{Type: graphql.NewList(featureContributionType)},
+		},
+	},
+)
+
 var customerType = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "Customer",
@@ -22,9 +81,15 @@ var customerType = graphql.NewObject(
 			"churnProbability": &graphql.Field{
 				Type: graphql.Float,
 			},
+			"churnExplanation": &graphql.Field{
+				Type: explanationType,
+			},
 			"lifetimeValue": &graphql.Field{
 				Type: graphql.Float,
 			},
+			"lifetimeValueExplanation": &graphql.Field{
+				Type: explanationType,
+			},
 			"recommendations": &graphql.Field{
 				Type: graphql.NewList(graphql.String),
 			},
@@ -32,23 +97,75 @@ var customerType = graphql.NewObject(
 	},
 )
 
+var kpiType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "KPI",
+		Fields: graphql.Fields{
+			"value":         &graphql.Field{Type: graphql.Float},
+			"previous":      &graphql.Field{Type: graphql.Float},
+			"delta":         &graphql.Field{Type: graphql.Float},
+			"percentChange": &graphql.Field{Type: graphql.Float},
+		},
+	},
+)
+
+var dashboardKPIsType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DashboardKPIs",
+		Fields: graphql.Fields{
+			"totalCustomers":       &graphql.Field{Type: kpiType},
+			"activeCustomers":      &graphql.Field{Type: kpiType},
+			"newCustomers":         &graphql.Field{Type: kpiType},
+			"churnRate":            &graphql.Field{Type: kpiType},
+			"totalRevenue":         &graphql.Field{Type: kpiType},
+			"orders":               &graphql.Field{Type: kpiType},
+			"averageTicket":        &graphql.Field{Type: kpiType},
+			"customerSatisfaction": &graphql.Field{Type: kpiType},
+		},
+	},
+)
+
+// Valor atual do indicador, com o tipo escalar que o campo tinha antes dos
+// KPIs com comparação, para não quebrar as consultas existentes
+func kpiValueField(scalar graphql.Output) *graphql.Field {
+	return &graphql.Field{
+		Type: scalar,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			summary, _ := p.Source.(gin.H)
+			kpi, ok := summary[p.Info.FieldName].(KPI)
+			if !ok {
+				return nil, nil
+			}
+			return kpi.Value, nil
+		},
+	}
+}
+
 var dashboardSummaryType = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "DashboardSummary",
 		Fields: graphql.Fields{
-			"totalCustomers":       &graphql.Field{Type: graphql.Int},
-			"activeCustomers":      &graphql.Field{Type: graphql.Int},
-			"churnRate":            &graphql.Field{Type: graphql.Float},
-			"totalRevenue":         &graphql.Field{Type: graphql.Float},
-			"averageTicket":        &graphql.Field{Type: graphql.Float},
-			"customerSatisfaction": &graphql.Field{Type: graphql.Float},
+			"totalCustomers":       kpiValueField(graphql.Int),
+			"activeCustomers":      kpiValueField(graphql.Int),
+			"churnRate":            kpiValueField(graphql.Float),
+			"totalRevenue":         kpiValueField(graphql.Float),
+			"averageTicket":        kpiValueField(graphql.Float),
+			"customerSatisfaction": kpiValueField(graphql.Float),
+			// Indicadores com valor anterior e variação
+			"kpis": &graphql.Field{
+				Type: dashboardKPIsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
 			"topProducts": &graphql.Field{
 				Type: graphql.NewList(graphql.NewObject(
 					graphql.ObjectConfig{
 						Name: "TopProduct",
 						Fields: graphql.Fields{
-							"name":  &graphql.Field{Type: graphql.String},
-							"sales": &graphql.Field{Type: graphql.Int},
+							"name":    &graphql.Field{Type: graphql.String},
+							"sales":   &graphql.Field{Type: graphql.Int},
+							"revenue": &graphql.Field{Type: graphql.Float},
 						},
 					},
 				)),
@@ -68,6 +185,131 @@ var dashboardSummaryType = graphql.NewObject(
 	},
 )
 
+var rfmSegmentType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "RFMSegment",
+		Fields: graphql.Fields{
+			"segment":            &graphql.Field{Type: graphql.String},
+			"label":              &graphql.Field{Type: graphql.String},
+			"customers":          &graphql.Field{Type: graphql.Int},
+			"share":              &graphql.Field{Type: graphql.Float},
+			"revenue":            &graphql.Field{Type: graphql.Float},
+			"averageRecencyDays": &graphql.Field{Type: graphql.Float},
+			"averageFrequency":   &graphql.Field{Type: graphql.Float},
+			"averageMonetary":    &graphql.Field{Type: graphql.Float},
+		},
+	},
+)
+
+var rfmAnalysisType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "RFMAnalysis",
+		Fields: graphql.Fields{
+			"customers":  &graphql.Field{Type: graphql.Int},
+			"computedAt": &graphql.Field{Type: graphql.DateTime},
+			"segments":   &graphql.Field{Type: graphql.NewList(rfmSegmentType)},
+		},
+	},
+)
+
+var cohortType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Cohort",
+		Fields: graphql.Fields{
+			"cohort":                       &graphql.Field{Type: graphql.String},
+			"customers":                    &graphql.Field{Type: graphql.Int},
+			"retention":                    &graphql.Field{Type: graphql.NewList(graphql.Float)},
+			"revenue":                      &graphql.Field{Type: graphql.NewList(graphql.Float)},
+			"cumulativeRevenuePerCustomer": &graphql.Field{Type: graphql.NewList(graphql.Float)},
+		},
+	},
+)
+
+var cohortAnalysisType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "CohortAnalysis",
+		Fields: graphql.Fields{
+			"months":     &graphql.Field{Type: graphql.Int},
+			"computedAt": &graphql.Field{Type: graphql.DateTime},
+			"cohorts":    &graphql.Field{Type: graphql.NewList(cohortType)},
+		},
+	},
+)
+
+var widgetLayoutType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "WidgetLayout",
+		Fields: graphql.Fields{
+			"x": &graphql.Field{Type: graphql.Int},
+			"y": &graphql.Field{Type: graphql.Int},
+			"w": &graphql.Field{Type: graphql.Int},
+			"h": &graphql.Field{Type: graphql.Int},
+		},
+	},
+)
+
+var dashboardWidgetType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DashboardWidget",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.String},
+			"type":        &graphql.Field{Type: graphql.String},
+			"title":       &graphql.Field{Type: graphql.String},
+			"metric":      &graphql.Field{Type: graphql.String},
+			"from":        &graphql.Field{Type: graphql.String},
+			"to":          &graphql.Field{Type: graphql.String},
+			"rangeDays":   &graphql.Field{Type: graphql.Int},
+			"compare":     &graphql.Field{Type: graphql.String},
+			"owner":       &graphql.Field{Type: graphql.String},
+			"segment":     &graphql.Field{Type: graphql.String},
+			"granularity": &graphql.Field{Type: graphql.String},
+			"limit":       &graphql.Field{Type: graphql.Int},
+			"layout":      &graphql.Field{Type: widgetLayoutType},
+		},
+	},
+)
+
+var widgetResultType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "WidgetResult",
+		Fields: graphql.Fields{
+			"widget": &graphql.Field{Type: dashboardWidgetType},
+			"data":   &graphql.Field{Type: jsonScalar},
+			"error":  &graphql.Field{Type: graphql.String},
+		},
+	},
+)
+
+// Os widgets só são calculados quando o campo results é pedido
+var savedDashboardType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Dashboard",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.Int},
+			"name":        &graphql.Field{Type: graphql.String},
+			"description": &graphql.Field{Type: graphql.String},
+			"ownerId":     &graphql.Field{Type: graphql.String},
+			"teamId":      &graphql.Field{Type: graphql.Int},
+			"clonedFrom":  &graphql.Field{Type: graphql.Int},
+			"sharedWith":  &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"editable":    &graphql.Field{Type: graphql.Boolean},
+			"createdAt":   &graphql.Field{Type: graphql.DateTime},
+			"updatedAt":   &graphql.Field{Type: graphql.DateTime},
+			"widgets":     &graphql.Field{Type: graphql.NewList(dashboardWidgetType)},
+			"results": &graphql.Field{
+				Type: graphql.NewList(widgetResultType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					dashboard, ok := p.Source.(SavedDashboard)
+					if !ok {
+						return nil, nil
+					}
+					return evaluateDashboard(tenantFromGraphQL(p), userFromGraphQL(p), dashboard.Widgets), nil
+				},
+			},
+		},
+	},
+)
+
 var rootQuery = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "RootQuery",
@@ -85,24 +327,88 @@ var rootQuery = graphql.NewObject(
 						return nil, nil
 					}
 					
-					customerData := getCustomerData(id)
-					churnProbability := predictChurn(customerData)
-					recommendations := recommendationEngine.GetRecommendations(id, 3)
-					
-					return map[string]interface{}{
-						"id":               id,
-						"name":             customerData["name"],
-						"email":            customerData["email"],
-						"churnProbability": churnProbability,
-						"lifetimeValue":    calculateLifetimeValue(id),
-						"recommendations":  recommendations,
-					}, nil
+					customer, err := fetchCustomerFromDB(tenantFromGraphQL(p), id)
+					if err != nil {
+						return nil, err
+					}
+					result := map[string]interface{}{
+						"id":              id,
+						"name":            customer.Name,
+						"email":           customer.Email,
+						"recommendations": recommendationEngine.GetRecommendations(id, 3),
+					}
+					if score, err := predictCustomerChurn(id); err == nil {
+						result["churnProbability"] = score.Probability
+						result["churnExplanation"] = score.Explanation
+					}
+					if prediction, err := predictLifetimeValue(tenantFromGraphQL(p), id, defaultLifetimeValueHorizonMonths); err == nil {
+						result["lifetimeValue"] = prediction.Value
+						result["lifetimeValueExplanation"] = prediction.Explanation
+					}
+					return result, nil
 				},
 			},
 			"dashboardSummary": &graphql.Field{
 				Type: dashboardSummaryType,
+				Args: graphql.FieldConfigArgument{
+					"from":    &graphql.ArgumentConfig{Type: graphql.String},
+					"to":      &graphql.ArgumentConfig{Type: graphql.String},
+					"compare": &graphql.ArgumentConfig{Type: graphql.String},
+					"owner":   &graphql.ArgumentConfig{Type: graphql.String},
+					"segment": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					arg := func(name string) string {
+						value, _ := p.Args[name].(string)
+						return value
+					}
+					filters, err := newDashboardFilters(arg("from"), arg("to"), arg("compare"), arg("owner"), arg("segment"), userFromGraphQL(p))
+					if err != nil {
+						return nil, err
+					}
+					return getDashboardSummary(tenantFromGraphQL(p), filters)
+				},
+			},
+			"rfmSegmentation": &graphql.Field{
+				Type: rfmAnalysisType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return computeRFMAnalysis(tenantFromGraphQL(p))
+				},
+			},
+			"cohortAnalysis": &graphql.Field{
+				Type: cohortAnalysisType,
+				Args: graphql.FieldConfigArgument{
+					"months": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: defaultCohortMonths,
+					},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					months, _ := p.Args["months"].(int)
+					if months < 1 || months > maxCohortMonths {
+						months = defaultCohortMonths
+					}
+					return computeCohortAnalysis(tenantFromGraphQL(p), months)
+				},
+			},
+			"dashboards": &graphql.Field{
+				Type: graphql.NewList(savedDashboardType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return listSavedDashboardsFor(tenantFromGraphQL(p), userFromGraphQL(p))
+				},
+			},
+			"dashboard": &graphql.Field{
+				Type: savedDashboardType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
 				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-					return getDashboardSummary(), nil
+					id, _ := p.Args["id"].(int)
+					dashboard, err := loadSavedDashboard(tenantFromGraphQL(p), userFromGraphQL(p), strconv.Itoa(id))
+					if err == sql.ErrNoRows {
+						return nil, nil
+					}
+					return dashboard, err
 				},
 			},
 		},
@@ -116,7 +422,12 @@ var schema, _ = graphql.NewSchema(
 )
 
 func setupGraphQLRoutes(r *gin.Engine) {
-	r.POST("/graphql", func(c *gin.Context) {
+	r.POST("/graphql", AuthMiddleware(), graphqlHandler(schema))
+}
+
+// Executa a consulta com o tenant e o usuário autenticado no contexto
+func graphqlHandler(schema graphql.Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		var request struct {
 			Query string `json:"query"`
 		}
@@ -128,10 +439,12 @@ func setupGraphQLRoutes(r *gin.Engine) {
 		result := graphql.Do(graphql.Params{
 			Schema:        schema,
 			RequestString: request.Query,
+			Context: context.WithValue(
+				context.WithValue(c.Request.Context(), graphqlTenantKey, tenantFromContext(c)),
+				graphqlUserKey, c.GetString("user_id"),
+			),
 		})
 		
 		c.JSON(http.StatusOK, result)
-	})
+	}
 }
-
-
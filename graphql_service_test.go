@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"CRMind/backend/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandlerPropagatesAuthenticatedUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	viewer, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"viewer": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return tenantFromGraphQL(p) + "/" + userFromGraphQL(p), nil
+					},
+				},
+			},
+		}),
+	})
+	assert.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/graphql", AuthMiddleware(), graphqlHandler(viewer))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ viewer }"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token, err := auth.GenerateToken("u1", "rep", "acme")
+	assert.NoError(t, err)
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ viewer }"}`))
+	req.Header.Set("Authorization", token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result struct {
+		Data struct {
+			Viewer string `json:"viewer"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "acme/u1", result.Data.Viewer)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Serviço de gerenciamento de chaves usado na criptografia envelope.
+// As chaves mestras (KEKs) nunca saem do KMS: ele apenas cifra e decifra
+// as chaves de dados geradas para cada valor.
+type KeyManager interface {
+	ActiveKeyID() string
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+	// Chave estável usada nos índices cegos; não participa da rotação
+	BlindIndexKey() []byte
+}
+
+// KMS que permite criar uma nova chave ativa (rotação)
+type RotatingKeyManager interface {
+	KeyManager
+	RotateKey() (string, error)
+}
+
+var keyManager KeyManager
+
+func initKeyManager() {
+	path := os.Getenv("CRMIND_KEYRING_FILE")
+	if path == "" {
+		path = "keyring.json"
+	}
+
+	keyring, err := LoadLocalKeyring(path)
+	if err != nil {
+		log.Fatal("Falha ao carregar chaveiro local: ", err)
+	}
+	keyManager = keyring
+}
+
+// Chaveiro em arquivo JSON, para desenvolvimento. Em produção, use uma
+// implementação de KeyManager baseada em um KMS gerenciado.
+type LocalKeyring struct {
+	path  string
+	mutex sync.RWMutex
+	file  localKeyringFile
+}
+
+type localKeyringFile struct {
+	ActiveKeyID   string            `json:"active_key_id"`
+	Keys          map[string]string `json:"keys"`
+	BlindIndexKey string            `json:"blind_index_key"`
+}
+
+// Carrega o chaveiro do arquivo, criando um novo se ele não existir
+func LoadLocalKeyring(path string) (*LocalKeyring, error) {
+	k := &LocalKeyring{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		k.file = localKeyringFile{Keys: map[string]string{}}
+		indexKey, err := randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		k.file.BlindIndexKey = base64.StdEncoding.EncodeToString(indexKey)
+		if _, err := k.RotateKey(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &k.file); err != nil {
+		return nil, err
+	}
+	if _, ok := k.file.Keys[k.file.ActiveKeyID]; !ok {
+		return nil, fmt.Errorf("chave ativa %q não encontrada no chaveiro", k.file.ActiveKeyID)
+	}
+	return k, nil
+}
+
+func (k *LocalKeyring) ActiveKeyID() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.file.ActiveKeyID
+}
+
+func (k *LocalKeyring) BlindIndexKey() []byte {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	key, _ := base64.StdEncoding.DecodeString(k.file.BlindIndexKey)
+	return key
+}
+
+func (k *LocalKeyring) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	kek, err := k.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return sealAESGCM(kek, dataKey)
+}
+
+func (k *LocalKeyring) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := k.masterKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(kek, wrapped)
+}
+
+// Gera uma nova chave mestra, torna-a ativa e persiste o chaveiro.
+// As chaves antigas são mantidas para decifrar dados ainda não recifrados.
+func (k *LocalKeyring) RotateKey() (string, error) {
+	key, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	keyID := fmt.Sprintf("local-%d", time.Now().UnixNano())
+	k.file.Keys[keyID] = base64.StdEncoding.EncodeToString(key)
+	previous := k.file.ActiveKeyID
+	k.file.ActiveKeyID = keyID
+
+	if err := k.save(); err != nil {
+		delete(k.file.Keys, keyID)
+		k.file.ActiveKeyID = previous
+		return "", err
+	}
+	return keyID, nil
+}
+
+func (k *LocalKeyring) masterKey(keyID string) ([]byte, error) {
+	k.mutex.RLock()
+	encoded, ok := k.file.Keys[keyID]
+	k.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("chave %q não encontrada", keyID)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func (k *LocalKeyring) save() error {
+	data, err := json.MarshalIndent(k.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.path)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Cifra com AES-GCM, prefixando o nonce ao texto cifrado
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("texto cifrado inválido")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultLifetimeValueHorizonMonths = 12
+	maxLifetimeValueHorizonMonths     = 60
+	clvRefitInterval                  = 7 * 24 * time.Hour
+	clvParametersCacheDuration        = 10 * time.Minute
+)
+
+var errCLVModelNotFitted = errors.New("modelo de valor vitalício ainda não foi ajustado para este tenant")
+
+var errCustomerNotFound = errors.New("cliente não encontrado")
+
+// Variáveis do modelo, na ordem usada nas explicações
+var lifetimeValueFeatureNames = []string{
+	"repeat_purchases",
+	"recency_weeks",
+	"customer_age_weeks",
+	"average_repeat_value",
+}
+
+func (c CustomerRFM) vector() []float64 {
+	return []float64{c.Frequency, c.Recency, c.Age, c.MonetaryValue}
+}
+
+func rfmFromVector(v []float64) CustomerRFM {
+	return CustomerRFM{Frequency: v[0], Recency: v[1], Age: v[2], MonetaryValue: v[3]}
+}
+
+// Parâmetros ajustados de um tenant, com o cliente médio usado como
+// referência nas explicações
+type FittedCLVModel struct {
+	TenantID  string        `json:"tenant_id"`
+	Params    CLVParameters `json:"parameters"`
+	Baseline  []float64     `json:"baseline"`
+	Customers int           `json:"customers"`
+	FittedAt  time.Time     `json:"fitted_at"`
+}
+
+type LifetimeValuePrediction struct {
+	CustomerID        string       `json:"customer_id"`
+	Value             float64      `json:"lifetime_value"`
+	ExpectedPurchases float64      `json:"expected_purchases"`
+	ExpectedAverage   float64      `json:"expected_average_value"`
+	ProbabilityAlive  float64      `json:"probability_alive"`
+	HorizonMonths     int          `json:"horizon_months"`
+	Explanation       *Explanation `json:"explanation,omitempty"`
+}
+
+func createLifetimeValueTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS clv_parameters (
+			id BIGSERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL,
+			parameters JSONB NOT NULL,
+			baseline JSONB NOT NULL,
+			customers INTEGER NOT NULL,
+			fitted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_clv_parameters_tenant ON clv_parameters (tenant_id, fitted_at DESC);
+
+		-- Previsões no horizonte padrão, recalculadas a cada ajuste e usadas no ranking
+		CREATE TABLE IF NOT EXISTS clv_scores (
+			customer_id INTEGER PRIMARY KEY REFERENCES customers(id) ON DELETE CASCADE,
+			tenant_id VARCHAR(50) NOT NULL,
+			lifetime_value DOUBLE PRECISION NOT NULL,
+			expected_purchases DOUBLE PRECISION NOT NULL,
+			expected_average_value DOUBLE PRECISION NOT NULL,
+			probability_alive DOUBLE PRECISION NOT NULL,
+			horizon_months INTEGER NOT NULL,
+			scored_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_clv_scores_ranking ON clv_scores (tenant_id, lifetime_value DESC);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func horizonWeeks(months int) float64 {
+	return float64(months) * 52 / 12
+}
+
+// Resumo RFM em semanas dos clientes do tenant, com compras agregadas por
+// dia. Sem IDs, considera todos os clientes com consentimento para perfilamento.
+func loadCustomerRFM(tenantID string, customerIDs []string, asOf time.Time) ([]CustomerRFM, error) {
+	rows, err := db.Query(`
+		WITH d AS (
+			SELECT s.customer_id, date_trunc('day', s.date) AS day, SUM(s.amount) AS amount
+			FROM sales s
+			JOIN customers c ON c.id = s.customer_id
+			WHERE c.tenant_id = $1 AND c.anonymized_at IS NULL AND s.date <= $2
+				AND (cardinality($3::text[]) = 0 OR c.id::text = ANY($3))
+				AND EXISTS (
+					SELECT 1 FROM customer_consents cc
+					WHERE cc.customer_id = c.id AND cc.purpose = 'profiling'
+						AND cc.withdrawn_at IS NULL AND cc.superseded_at IS NULL
+				)
+			GROUP BY 1, 2
+		), n AS (
+			SELECT customer_id, day, amount, ROW_NUMBER() OVER (PARTITION BY customer_id ORDER BY day) AS seq
+			FROM d
+		)
+		SELECT customer_id::text, COUNT(*) - 1, MIN(day), MAX(day),
+			COALESCE(AVG(amount) FILTER (WHERE seq > 1), 0)
+		FROM n
+		GROUP BY customer_id`,
+		tenantID, asOf, pq.Array(customerIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []CustomerRFM
+	for rows.Next() {
+		var c CustomerRFM
+		var first, last time.Time
+		if err := rows.Scan(&c.CustomerID, &c.Frequency, &first, &last, &c.MonetaryValue); err != nil {
+			return nil, err
+		}
+		c.Recency = last.Sub(first).Hours() / 24 / 7
+		c.Age = asOf.Sub(first).Hours() / 24 / 7
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func refitCLVModel(tenantID string) (*FittedCLVModel, error) {
+	customers, err := loadCustomerRFM(tenantID, nil, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	params, err := fitCLVParameters(customers)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := make([]float64, len(lifetimeValueFeatureNames))
+	for _, c := range customers {
+		for i, v := range c.vector() {
+			baseline[i] += v / float64(len(customers))
+		}
+	}
+
+	model := &FittedCLVModel{
+		TenantID:  tenantID,
+		Params:    params,
+		Baseline:  baseline,
+		Customers: len(customers),
+		FittedAt:  time.Now(),
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	baselineJSON, err := json.Marshal(baseline)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO clv_parameters (tenant_id, parameters, baseline, customers, fitted_at) VALUES ($1, $2, $3, $4, $5)",
+		tenantID, paramsJSON, baselineJSON, model.Customers, model.FittedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeLifetimeValueScores(tx, model, customers); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	globalCache.Set(clvCacheKey(tenantID), model, clvParametersCacheDuration)
+	return model, nil
+}
+
+// Substitui as previsões do tenant pelas do modelo recém-ajustado
+func storeLifetimeValueScores(tx *sql.Tx, model *FittedCLVModel, customers []CustomerRFM) error {
+	if _, err := tx.Exec("DELETE FROM clv_scores WHERE tenant_id = $1", model.TenantID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("clv_scores",
+		"customer_id", "tenant_id", "lifetime_value", "expected_purchases", "expected_average_value",
+		"probability_alive", "horizon_months", "scored_at"))
+	if err != nil {
+		return err
+	}
+	for _, customer := range customers {
+		p := model.predict(customer, defaultLifetimeValueHorizonMonths)
+		_, err := stmt.Exec(customer.CustomerID, model.TenantID, p.Value, p.ExpectedPurchases, p.ExpectedAverage,
+			p.ProbabilityAlive, p.HorizonMonths, model.FittedAt)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	return stmt.Close()
+}
+
+func forgetLifetimeValueScores(customerID string) {
+	_, err := db.Exec("DELETE FROM clv_scores WHERE customer_id::text = $1", customerID)
+	if err != nil {
+		logger.Errorf("Falha ao remover previsões de valor vitalício do cliente %s: %v", customerID, err)
+	}
+}
+
+func refitAllCLVModels(ctx context.Context) error {
+	tenants, err := listTenants()
+	if err != nil {
+		return err
+	}
+
+	for _, tenantID := range tenants {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		model, err := refitCLVModel(tenantID)
+		if errors.Is(err, errInsufficientCLVData) {
+			continue
+		}
+		if err != nil {
+			logger.Errorf("Falha ao ajustar modelo de valor vitalício do tenant %s: %v", tenantID, err)
+			continue
+		}
+		logger.Infof("Modelo de valor vitalício do tenant %s ajustado com %d clientes", tenantID, model.Customers)
+	}
+	return nil
+}
+
+func clvCacheKey(tenantID string) string {
+	return "clv_model:" + tenantID
+}
+
+func currentCLVModel(tenantID string) (*FittedCLVModel, error) {
+	if value, found := globalCache.Get(clvCacheKey(tenantID)); found {
+		return value.(*FittedCLVModel), nil
+	}
+
+	model := &FittedCLVModel{TenantID: tenantID}
+	var paramsJSON, baselineJSON []byte
+	err := db.QueryRow(`
+		SELECT parameters, baseline, customers, fitted_at FROM clv_parameters
+		WHERE tenant_id = $1
+		ORDER BY fitted_at DESC
+		LIMIT 1`,
+		tenantID,
+	).Scan(&paramsJSON, &baselineJSON, &model.Customers, &model.FittedAt)
+	if err == sql.ErrNoRows {
+		return nil, errCLVModelNotFitted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(paramsJSON, &model.Params); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(baselineJSON, &model.Baseline); err != nil {
+		return nil, err
+	}
+
+	globalCache.Set(clvCacheKey(tenantID), model, clvParametersCacheDuration)
+	return model, nil
+}
+
+func (m *FittedCLVModel) predict(c CustomerRFM, horizonMonths int) LifetimeValuePrediction {
+	t := horizonWeeks(horizonMonths)
+	return LifetimeValuePrediction{
+		CustomerID:        c.CustomerID,
+		Value:             m.Params.LifetimeValue(c, t),
+		ExpectedPurchases: m.Params.ExpectedPurchases(c, t),
+		ExpectedAverage:   m.Params.ExpectedAverageValue(c),
+		ProbabilityAlive:  m.Params.ProbabilityAlive(c),
+		HorizonMonths:     horizonMonths,
+	}
+}
+
+func predictLifetimeValue(tenantID, customerID string, horizonMonths int) (LifetimeValuePrediction, error) {
+	if !customerExists(tenantID, customerID) {
+		return LifetimeValuePrediction{}, errCustomerNotFound
+	}
+	if !hasProfilingConsent(customerID) {
+		return LifetimeValuePrediction{}, errNoProfilingConsent
+	}
+
+	model, err := currentCLVModel(tenantID)
+	if err != nil {
+		return LifetimeValuePrediction{}, err
+	}
+
+	customers, err := loadCustomerRFM(tenantID, []string{customerID}, time.Now())
+	if err != nil {
+		return LifetimeValuePrediction{}, err
+	}
+	if len(customers) == 0 {
+		// Cliente sem compras: não há base para estimar valor futuro
+		return LifetimeValuePrediction{CustomerID: customerID, HorizonMonths: horizonMonths}, nil
+	}
+
+	prediction := model.predict(customers[0], horizonMonths)
+	t := horizonWeeks(horizonMonths)
+	prediction.Explanation = explainWithShapley(
+		lifetimeValueFeatureNames,
+		func(v []float64) float64 { return model.Params.LifetimeValue(rfmFromVector(v), t) },
+		customers[0].vector(),
+		model.Baseline,
+		formatCurrencyImpact,
+	)
+	return prediction, nil
+}
+
+// Valor vitalício estimado no horizonte padrão, ou zero quando não puder
+// ser calculado
+func calculateLifetimeValue(tenantID, customerID string) float64 {
+	prediction, err := predictLifetimeValue(tenantID, customerID, defaultLifetimeValueHorizonMonths)
+	if err != nil {
+		return 0
+	}
+	return prediction.Value
+}
+
+func lifetimeValueErrorStatus(err error) int {
+	if errors.Is(err, errCLVModelNotFitted) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, errCustomerNotFound) {
+		return http.StatusNotFound
+	}
+	return churnErrorStatus(err)
+}
+
+func parseHorizonMonths(c *gin.Context) (int, bool) {
+	months, err := strconv.Atoi(c.DefaultQuery("horizon_months", strconv.Itoa(defaultLifetimeValueHorizonMonths)))
+	if err != nil || months < 1 || months > maxLifetimeValueHorizonMonths {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "horizon_months deve estar entre 1 e 60"})
+		return 0, false
+	}
+	return months, true
+}
+
+func getLifetimeValue(c *gin.Context) {
+	months, ok := parseHorizonMonths(c)
+	if !ok {
+		return
+	}
+
+	prediction, err := predictLifetimeValue(tenantFromContext(c), c.Param("id"), months)
+	if err != nil {
+		c.JSON(lifetimeValueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prediction)
+}
+
+// Ranking dos clientes do tenant pelas previsões gravadas no último ajuste,
+// sempre no horizonte padrão
+func listLifetimeValueRanking(c *gin.Context) {
+	if months := c.Query("horizon_months"); months != "" && months != strconv.Itoa(defaultLifetimeValueHorizonMonths) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O ranking usa o horizonte padrão de 12 meses"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 50
+	}
+
+	tenantID := tenantFromContext(c)
+	model, err := currentCLVModel(tenantID)
+	if err != nil {
+		c.JSON(lifetimeValueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT s.customer_id::text, s.lifetime_value, s.expected_purchases, s.expected_average_value,
+			s.probability_alive, s.horizon_months, COUNT(*) OVER ()
+		FROM clv_scores s
+		JOIN customers c ON c.id = s.customer_id
+		WHERE s.tenant_id = $1 AND c.anonymized_at IS NULL
+		ORDER BY s.lifetime_value DESC, s.customer_id
+		LIMIT $2 OFFSET $3`,
+		tenantID, pageSize, (page-1)*pageSize,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar valor vitalício"})
+		return
+	}
+	defer rows.Close()
+
+	predictions := []LifetimeValuePrediction{}
+	total := 0
+	for rows.Next() {
+		var p LifetimeValuePrediction
+		if err := rows.Scan(&p.CustomerID, &p.Value, &p.ExpectedPurchases, &p.ExpectedAverage,
+			&p.ProbabilityAlive, &p.HorizonMonths, &total); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler valor vitalício"})
+			return
+		}
+		predictions = append(predictions, p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"horizon_months": defaultLifetimeValueHorizonMonths,
+		"fitted_at":      model.FittedAt,
+		"page":           page,
+		"page_size":      pageSize,
+		"total":          total,
+		"customers":      predictions,
+	})
+}
+
+func refitLifetimeValueModel(c *gin.Context) {
+	tenantID := tenantFromContext(c)
+	model, err := refitCLVModel(tenantID)
+	if errors.Is(err, errInsufficientCLVData) || errors.Is(err, errCLVFitFailed) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ajustar modelo de valor vitalício"})
+		return
+	}
+
+	recordAudit(tenantID, c.GetString("user_id"), "clv_model_refit", "clv_model", tenantID, gin.H{"customers": model.Customers})
+	c.JSON(http.StatusOK, model)
+}
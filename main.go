@@ -3,9 +3,14 @@ package main
 import (
     "CRMind/backend/auth"
     "CRMind/backend/database"
+    "context"
     "fmt"
     "log"
     "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
     "github.com/gin-gonic/gin"
     "database/sql"
     _ "github.com/lib/pq"
@@ -13,12 +18,19 @@ import (
 
 var db *sql.DB
 
+// Lida de DATABASE_URL em initDB. Também usada pela conexão LISTEN do
+// backplane em tempo real.
+var dbConnStr string
+
 // Inicializar o banco de dados
 func initDB() {
-    connStr := "host=p-2hrz9m0lvu.pg.biganimal.io port=5432 user=edb_admin password=CRMind*2024*898 dbname=edb_admin sslmode=require"
-    // Substitua 'your_user' e 'your_password' pelas suas credenciais do PostgreSQL
+    dbConnStr = os.Getenv("DATABASE_URL")
+    if dbConnStr == "" {
+        log.Fatal("DATABASE_URL não definida")
+    }
+
     var err error
-    db, err = sql.Open("postgres", connStr)
+    db, err = sql.Open("postgres", dbConnStr)
     if err != nil {
         log.Fatal(err)
     }
@@ -48,17 +60,79 @@ func createTables() {
             amount DECIMAL(10, 2) NOT NULL,
             date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
         );
+
+        ALTER TABLE customers ADD COLUMN IF NOT EXISTS phone VARCHAR(20);
+        ALTER TABLE customers ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP;
+        ALTER TABLE customers ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+
+        CREATE TABLE IF NOT EXISTS users (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(100),
+            email VARCHAR(100) UNIQUE NOT NULL,
+            password VARCHAR(255) NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+        CREATE TABLE IF NOT EXISTS interactions (
+            id SERIAL PRIMARY KEY,
+            customer_id INTEGER REFERENCES customers(id),
+            type VARCHAR(50) NOT NULL,
+            content TEXT,
+            sentiment DOUBLE PRECISION,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+
+        CREATE TABLE IF NOT EXISTS chatbot_messages (
+            id SERIAL PRIMARY KEY,
+            customer_id INTEGER REFERENCES customers(id),
+            role VARCHAR(10) NOT NULL,
+            text TEXT NOT NULL,
+            intent VARCHAR(50),
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
     `)
     if err != nil {
         log.Fatal(err)
     }
+
+    createAuditTables()
+    createPrivacyTables()
+    createConsentTables()
+    createRetentionTables()
+    createEncryptionTables()
+    createFeatureStoreTables()
+    createModelRegistryTables()
+    createChurnScoringTables()
+    createLifetimeValueTables()
+    createSalesForecastTables()
+    createTeamTables()
+    createOpportunityTables()
+    createFunnelTables()
+    createPipelineForecastTables()
+    createAnomalyTables()
+    createSavedDashboardTables()
+    createScheduledReportTables()
+    createGoalTables()
+    createRealtimeEventTables()
 }
 
 // Função principal que inicia o servidor
 func main() {
+    // Cancelado em SIGINT/SIGTERM para o encerramento ordenado
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
     initDB()
+    initKeyManager()
+    initMailer()
+    initRealtime(ctx)
+    loadProductionModels()
 
-    r := gin.Default()
+    r := gin.New()
+    r.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.Recovery())
 
     // Configurar rotas de autenticação
     setupAuthRoutes(r)
@@ -69,8 +143,63 @@ func main() {
     // Configurar rotas de vendas
     setupSalesRoutes(r)
 
+    // Configurar rotas de times, oportunidades e metas
+    setupTeamRoutes(r)
+    setupOpportunityRoutes(r)
+    setupGoalRoutes(r)
+
+    // Configurar rotas do dashboard
+    setupDashboardRoutes(r)
+    setupSavedDashboardRoutes(r)
+    setupGraphQLRoutes(r)
+
+    // Configurar rotas de relatórios agendados
+    setupReportRoutes(r)
+
+    // Configurar rotas de privacidade (LGPD)
+    setupPrivacyRoutes(r)
+
+    // Configurar rotas de análise, modelos e eventos em tempo real
+    setupAnalyticsRoutes(r)
+    setupMLRoutes(r)
+    setupRealtimeRoutes(r)
+    metricsDone := metricsAggregator.Start(ctx)
+
+    // Iniciar tarefas agendadas (expurgo de retenção, etc.)
+    registerScheduledJobs()
+    jobsDone := startScheduler(ctx)
+
     // Iniciar o servidor na porta 8080
-    r.Run(":8080")
+    srv := &http.Server{Addr: ":8080", Handler: r}
+    go func() {
+        if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Fatal(err)
+        }
+    }()
+
+    <-ctx.Done()
+    log.Println("Encerrando o servidor...")
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        log.Println("Falha ao encerrar o servidor:", err)
+    }
+    jobsDone.Wait()
+    metricsDone.Wait()
+}
+
+// Tarefas periódicas executadas pelo scheduler
+func registerScheduledJobs() {
+    registerJob(ScheduledJob{Name: "retention_purge", Interval: 24 * time.Hour, Run: runScheduledRetentionPurge})
+    registerJob(ScheduledJob{Name: fieldReencryptionJob, Interval: time.Hour, Run: reencryptAllColumns})
+    registerJob(ScheduledJob{Name: "feature_refresh_incremental", Interval: time.Minute, Run: refreshDirtyFeatures})
+    registerJob(ScheduledJob{Name: "feature_refresh_full", Interval: featureFullRefreshInterval, Run: refreshAllFeatures})
+    registerJob(ScheduledJob{Name: "churn_batch_scoring", Interval: churnScoringInterval, Run: runChurnBatchScoring})
+    registerJob(ScheduledJob{Name: "clv_refit", Interval: clvRefitInterval, Run: refitAllCLVModels})
+    registerJob(ScheduledJob{Name: "anomaly_detection", Interval: anomalyDetectionInterval, Run: runAnomalyDetection})
+    registerJob(ScheduledJob{Name: "scheduled_reports", Interval: time.Minute, Run: runDueReports})
+    registerJob(ScheduledJob{Name: "goal_attainment", Interval: time.Minute, Run: checkReachedGoals})
+    registerJob(ScheduledJob{Name: "realtime_event_retention", Interval: time.Hour, Run: purgeRealtimeEvents})
 }
 
 // Configurar rotas de autenticação
@@ -94,18 +223,22 @@ func login(c *gin.Context) {
         return
     }
 
-    var userID string
-    err := db.QueryRow("SELECT id FROM users WHERE email = $1 AND password = $2", loginData.Email, loginData.Password).Scan(&userID)
+    var userID, role, tenantID string
+    err := db.QueryRow(
+        "SELECT id, role, tenant_id FROM users WHERE email = $1 AND password = $2",
+        loginData.Email, loginData.Password,
+    ).Scan(&userID, &role, &tenantID)
     if err != nil {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciais inválidas"})
         return
     }
 
-    token, err := auth.GenerateToken(userID)
+    token, err := auth.GenerateToken(userID, role, tenantID)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao gerar token"})
         return
     }
+    metricsAggregator.Record(DomainEvent{Type: eventUserLogin, TenantID: tenantID, UserID: userID})
 
     c.JSON(http.StatusOK, gin.H{"token": token})
 }
@@ -145,10 +278,29 @@ func AuthMiddleware() gin.HandlerFunc {
         }
 
         c.Set("user_id", claims.UserID)
+        c.Set("role", claims.Role)
+        c.Set("tenant_id", claims.TenantID)
         c.Next()
     }
 }
 
+// Middleware que restringe a rota aos papéis informados. Deve rodar depois
+// do AuthMiddleware, que define o papel do usuário no contexto.
+func roleAuthorization(allowedRoles ...string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        role := c.GetString("role")
+        for _, allowedRole := range allowedRoles {
+            if role == allowedRole {
+                c.Next()
+                return
+            }
+        }
+
+        c.JSON(http.StatusForbidden, gin.H{"error": "Acesso não autorizado"})
+        c.Abort()
+    }
+}
+
 // Configurar rotas de clientes
 func setupCustomerRoutes(r *gin.Engine) {
     customerGroup := r.Group("/customers")
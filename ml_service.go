@@ -2,100 +2,102 @@ package main
 
 import (
 	"github.com/gin-gonic/gin"
-	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/gonum/stat"
-	"math"
 	"net/http"
+	"time"
 )
 
-type MLModel struct {
-	Weights *mat.VecDense
-}
-
-func NewMLModel(features int) *MLModel {
-	return &MLModel{
-		Weights: mat.NewVecDense(features, nil),
-	}
-}
-
-func (m *MLModel) Predict(features *mat.VecDense) float64 {
-	return mat.Dot(m.Weights, features)
-}
-
-func (m *MLModel) Train(X *mat.Dense, y *mat.VecDense, learningRate float64, epochs int) {
-	nSamples, nFeatures := X.Dims()
-	for epoch := 0; epoch < epochs; epoch++ {
-		for i := 0; i < nSamples; i++ {
-			xi := X.RowView(i)
-			yi := y.AtVec(i)
-			
-			prediction := m.Predict(xi.(*mat.VecDense))
-			error := yi - prediction
-			
-			gradient := mat.NewVecDense(nFeatures, nil)
-			gradient.ScaleVec(-2*error, xi.(*mat.VecDense))
-			
-			m.Weights.AddScaledVec(m.Weights, -learningRate, gradient)
-		}
-	}
-}
-
-var churnModel = NewMLModel(5) // 5 features for simplicity
-
 func setupMLRoutes(r *gin.Engine) {
 	ml := r.Group("/ml")
+	ml.Use(AuthMiddleware())
 	{
 		ml.POST("/train", trainModel)
 		ml.POST("/predict", predictChurn)
+		ml.GET("/models", listModels)
+		ml.GET("/models/:version", getModel)
+		ml.POST("/models/:version/promote", promoteModelVersion)
+		ml.POST("/models/rollback", rollbackModelVersion)
+		ml.GET("/features", listFeatureDefinitions)
+		ml.GET("/features/:id", getCustomerFeatures)
 	}
 }
 
+// Treina o modelo de churn com os dados reais de vendas e interações e o
+// registra como candidato. A promoção para produção é feita à parte.
 func trainModel(c *gin.Context) {
-	// Simulated training data
-	X := mat.NewDense(100, 5, generateRandomData(100*5))
-	y := mat.NewVecDense(100, generateRandomLabels(100))
-	
-	churnModel.Train(X, y, 0.01, 1000)
-	
-	c.JSON(http.StatusOK, gin.H{"message": "Model trained successfully"})
-}
-
-func predictChurn(c *gin.Context) {
-	var features struct {
-		Data []float64 `json:"data"`
+	cfg := defaultChurnTrainingConfig
+	var request struct {
+		InactivityDays int     `json:"inactivity_days"`
+		L2             float64 `json:"l2"`
+		Epochs         int     `json:"epochs"`
 	}
-	if err := c.BindJSON(&features); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&request); err == nil {
+		if request.InactivityDays > 0 {
+			cfg.InactivityWindow = time.Duration(request.InactivityDays) * 24 * time.Hour
+		}
+		if request.L2 > 0 {
+			cfg.L2 = request.L2
+		}
+		if request.Epochs > 0 {
+			cfg.Epochs = request.Epochs
+		}
+	}
+
+	result, err := trainChurnModel(time.Now(), cfg)
+	if err != nil {
+		c.JSON(churnErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	
-	if len(features.Data) != 5 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected 5 features"})
+	registered, err := registerChurnCandidate(result, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar modelo"})
 		return
 	}
-	
-	featureVec := mat.NewVecDense(5, features.Data)
-	prediction := churnModel.Predict(featureVec)
-	
+
 	c.JSON(http.StatusOK, gin.H{
-		"churn_probability": math.Max(0, math.Min(1, prediction)),
+		"message":          "Model trained successfully",
+		"version":          registered.Version,
+		"status":           registered.Status,
+		"train_samples":    result.TrainSamples,
+		"feature_snapshot": result.FeatureSnapshotID,
+		"holdout_metrics":  result.HoldoutMetrics,
+		"weights":          result.Model.Weights,
+		"feature_names":    result.Model.FeatureNames,
 	})
 }
 
-func generateRandomData(n int) []float64 {
-	data := make([]float64, n)
-	for i := range data {
-		data[i] = stat.NormFloat64()
+func predictChurn(c *gin.Context) {
+	var request struct {
+		CustomerID string    `json:"customer_id"`
+		Data       []float64 `json:"data"`
+	}
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	return data
-}
 
-func generateRandomLabels(n int) []float64 {
-	labels := make([]float64, n)
-	for i := range labels {
-		if stat.NormFloat64() > 0 {
-			labels[i] = 1
+	if request.CustomerID != "" {
+		score, err := predictCustomerChurn(request.CustomerID)
+		if err != nil {
+			c.JSON(churnErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
+		c.JSON(http.StatusOK, score)
+		return
+	}
+
+	if len(request.Data) != len(churnFeatureNames) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Informe customer_id ou as features", "features": churnFeatureNames})
+		return
 	}
-	return labels
+	registered, err := modelRegistry.Production(churnModelName)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errChurnModelNotTrained.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ChurnScore{
+		Probability:  registered.Model.Predict(request.Data),
+		ModelVersion: registered.Version,
+		Explanation:  explainChurnModel(registered.Model, request.Data),
+	})
 }
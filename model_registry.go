@@ -0,0 +1,396 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	modelStatusProduction = "production"
+
+	churnModelName = "churn"
+
+	// Intervalo para verificar se outra réplica promoveu um novo modelo
+	modelRegistryRefreshInterval = 30 * time.Second
+)
+
+var errModelNotFound = errors.New("modelo não encontrado")
+
+// Versão de modelo armazenada no registro, com tudo o que é necessário para
+// reproduzi-la e auditá-la
+type RegisteredModel struct {
+	ID            int64       `json:"id"`
+	Name          string      `json:"name"`
+	Version       int         `json:"version"`
+	Status        string      `json:"status"`
+	Model         *ChurnModel `json:"model"`
+	FeatureSchema []string    `json:"feature_schema"`
+	// Snapshot offline usado no treino, para reproduzir o conjunto de dados
+	FeatureSnapshotID int64               `json:"feature_snapshot_id"`
+	Hyperparameters   ChurnTrainingConfig `json:"hyperparameters"`
+	TrainWindowStart  time.Time           `json:"train_window_start"`
+	TrainWindowEnd    time.Time           `json:"train_window_end"`
+	Metrics           ChurnEvaluation     `json:"metrics"`
+	CreatedBy         string              `json:"created_by"`
+	CreatedAt         time.Time           `json:"created_at"`
+	PromotedAt        *time.Time          `json:"promoted_at,omitempty"`
+}
+
+// Mantém em memória o modelo em produção de cada nome
+type ModelRegistry struct {
+	mutex      sync.RWMutex
+	production map[string]*RegisteredModel
+	checkedAt  map[string]time.Time
+}
+
+func NewModelRegistry() *ModelRegistry {
+	return &ModelRegistry{
+		production: make(map[string]*RegisteredModel),
+		checkedAt:  make(map[string]time.Time),
+	}
+}
+
+var modelRegistry = NewModelRegistry()
+
+func createModelRegistryTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ml_models (
+			id BIGSERIAL PRIMARY KEY,
+			name VARCHAR(50) NOT NULL,
+			version INTEGER NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'candidate',
+			artifact JSONB NOT NULL,
+			feature_schema JSONB NOT NULL,
+			hyperparameters JSONB NOT NULL,
+			train_window_start TIMESTAMP NOT NULL,
+			train_window_end TIMESTAMP NOT NULL,
+			metrics JSONB NOT NULL,
+			created_by VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			promoted_at TIMESTAMP,
+			UNIQUE (name, version)
+		);
+
+		ALTER TABLE ml_models ADD COLUMN IF NOT EXISTS feature_snapshot_id BIGINT REFERENCES feature_snapshots(id);
+		-- Versão que estava em produção quando esta foi promovida; destino do rollback
+		ALTER TABLE ml_models ADD COLUMN IF NOT EXISTS previous_version INTEGER;
+		UPDATE ml_models m SET previous_version = (
+			SELECT MAX(p.version) FROM ml_models p
+			WHERE p.name = m.name AND p.status = 'archived' AND p.promoted_at IS NOT NULL AND p.version < m.version
+		)
+		WHERE m.status = 'production' AND m.previous_version IS NULL;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_ml_models_production ON ml_models (name) WHERE status = 'production';
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Carrega na inicialização o modelo em produção, se houver um promovido
+func loadProductionModels() {
+	model, err := modelRegistry.Production(churnModelName)
+	if errors.Is(err, errModelNotFound) {
+		logger.Infof("Nenhum modelo de churn em produção")
+		return
+	}
+	if err != nil {
+		log.Fatal("Falha ao carregar modelo em produção: ", err)
+	}
+	logger.Infof("Modelo de churn v%d carregado", model.Version)
+}
+
+// Retorna o modelo em produção, recarregando-o do banco se outra réplica
+// tiver promovido uma nova versão
+func (r *ModelRegistry) Production(name string) (*RegisteredModel, error) {
+	r.mutex.RLock()
+	current := r.production[name]
+	fresh := time.Since(r.checkedAt[name]) < modelRegistryRefreshInterval
+	r.mutex.RUnlock()
+	if fresh {
+		if current == nil {
+			return nil, errModelNotFound
+		}
+		return current, nil
+	}
+
+	var version int
+	err := db.QueryRow("SELECT version FROM ml_models WHERE name = $1 AND status = 'production'", name).Scan(&version)
+	if err != nil && err != sql.ErrNoRows {
+		if current != nil {
+			return current, nil
+		}
+		return nil, err
+	}
+
+	if err == sql.ErrNoRows {
+		current = nil
+	} else if current == nil || current.Version != version {
+		if current, err = loadRegisteredModel(name, version); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mutex.Lock()
+	r.production[name] = current
+	r.checkedAt[name] = time.Now()
+	r.mutex.Unlock()
+
+	if current == nil {
+		return nil, errModelNotFound
+	}
+	return current, nil
+}
+
+func (r *ModelRegistry) setProduction(model *RegisteredModel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.production[model.Name] = model
+	r.checkedAt[model.Name] = time.Now()
+}
+
+// Registra o resultado de um treino como candidato
+func registerChurnCandidate(result *ChurnTrainingResult, actor string) (*RegisteredModel, error) {
+	artifact, err := json.Marshal(result.Model)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := json.Marshal(result.Model.FeatureNames)
+	if err != nil {
+		return nil, err
+	}
+	hyperparameters, err := json.Marshal(result.Config)
+	if err != nil {
+		return nil, err
+	}
+	// Métricas indefinidas (como a AUC de um holdout com uma classe) são
+	// gravadas como null; um NaN faria a serialização falhar
+	metrics, err := json.Marshal(result.HoldoutMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("métricas do holdout: %w", err)
+	}
+
+	var version int
+	err = db.QueryRow(`
+		INSERT INTO ml_models (name, version, artifact, feature_schema, feature_snapshot_id, hyperparameters,
+			train_window_start, train_window_end, metrics, created_by)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6, $7, $8, $9
+		FROM ml_models WHERE name = $1
+		RETURNING version`,
+		churnModelName, artifact, schema, result.FeatureSnapshotID, hyperparameters,
+		result.WindowStart, result.WindowEnd, metrics, actor,
+	).Scan(&version)
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit("", actor, "model_registered", "ml_model", churnModelName+":"+strconv.Itoa(version), gin.H{
+		"auc": result.HoldoutMetrics.AUC,
+	})
+	return loadRegisteredModel(churnModelName, version)
+}
+
+func loadRegisteredModel(name string, version int) (*RegisteredModel, error) {
+	models, err := queryRegisteredModels("WHERE name = $1 AND version = $2", name, version)
+	if err != nil {
+		return nil, err
+	}
+	if len(models) == 0 {
+		return nil, errModelNotFound
+	}
+	return models[0], nil
+}
+
+func queryRegisteredModels(where string, args ...interface{}) ([]*RegisteredModel, error) {
+	rows, err := db.Query(`
+		SELECT id, name, version, status, artifact, feature_schema, COALESCE(feature_snapshot_id, 0), hyperparameters,
+			train_window_start, train_window_end, metrics, created_by, created_at, promoted_at
+		FROM ml_models `+where+`
+		ORDER BY version DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var models []*RegisteredModel
+	for rows.Next() {
+		m := &RegisteredModel{}
+		var artifact, schema, hyperparameters, metrics []byte
+		var promotedAt sql.NullTime
+		err := rows.Scan(&m.ID, &m.Name, &m.Version, &m.Status, &artifact, &schema, &m.FeatureSnapshotID, &hyperparameters,
+			&m.TrainWindowStart, &m.TrainWindowEnd, &metrics, &m.CreatedBy, &m.CreatedAt, &promotedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(artifact, &m.Model); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(schema, &m.FeatureSchema)
+		json.Unmarshal(hyperparameters, &m.Hyperparameters)
+		json.Unmarshal(metrics, &m.Metrics)
+		if promotedAt.Valid {
+			m.PromotedAt = &promotedAt.Time
+		}
+		models = append(models, m)
+	}
+	return models, rows.Err()
+}
+
+// Promove a versão para produção, arquivando a versão atual
+func promoteModel(name string, version int, actor string) (*RegisteredModel, error) {
+	return setProductionVersion(name, version, actor, false)
+}
+
+// Indica se o modelo usa as mesmas features, na mesma ordem, que o código
+func sameFeatureSchema(schema, names []string) bool {
+	if len(schema) != len(names) {
+		return false
+	}
+	for i := range schema {
+		if schema[i] != names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Coloca a versão em produção. Na promoção, a versão substituída fica
+// registrada como anterior; no rollback, a versão restaurada mantém o
+// registro da sua própria promoção, para que rollbacks sucessivos percorram
+// o histórico.
+func setProductionVersion(name string, version int, actor string, rollback bool) (*RegisteredModel, error) {
+	model, err := loadRegisteredModel(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if !sameFeatureSchema(model.FeatureSchema, churnFeatureNames) {
+		return nil, errors.New("esquema de features incompatível com a versão atual do código")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullInt64
+	err = tx.QueryRow(
+		"UPDATE ml_models SET status = 'archived' WHERE name = $1 AND status = 'production' RETURNING version",
+		name,
+	).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE ml_models SET status = 'production', promoted_at = CURRENT_TIMESTAMP,
+			previous_version = CASE WHEN $3::boolean OR $4::integer = $2 THEN previous_version ELSE $4::integer END
+		WHERE name = $1 AND version = $2`,
+		name, version, rollback, previous,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	model.Status = modelStatusProduction
+	model.PromotedAt = &now
+	modelRegistry.setProduction(model)
+
+	action := "model_promoted"
+	if rollback {
+		action = "model_rolled_back"
+	}
+	recordAudit("", actor, action, "ml_model", name+":"+strconv.Itoa(version), gin.H{
+		"previous_version": previous.Int64,
+	})
+	return model, nil
+}
+
+// Volta para a versão que estava em produção antes da atual, registrada
+// na promoção
+func rollbackModel(name, actor string) (*RegisteredModel, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(
+		"SELECT previous_version FROM ml_models WHERE name = $1 AND status = 'production'",
+		name,
+	).Scan(&version)
+	if err == sql.ErrNoRows || (err == nil && !version.Valid) {
+		return nil, errModelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return setProductionVersion(name, int(version.Int64), actor, true)
+}
+
+func listModels(c *gin.Context) {
+	models, err := queryRegisteredModels("WHERE name = $1", churnModelName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar modelos"})
+		return
+	}
+	if models == nil {
+		models = []*RegisteredModel{}
+	}
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+func getModel(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Versão inválida"})
+		return
+	}
+
+	model, err := loadRegisteredModel(churnModelName, version)
+	if err != nil {
+		c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, model)
+}
+
+func promoteModelVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Versão inválida"})
+		return
+	}
+
+	model, err := promoteModel(churnModelName, version, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Modelo promovido para produção", "version": model.Version})
+}
+
+func rollbackModelVersion(c *gin.Context) {
+	model, err := rollbackModel(churnModelName, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(modelErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rollback concluído", "version": model.Version})
+}
+
+func modelErrorStatus(err error) int {
+	if errors.Is(err, errModelNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
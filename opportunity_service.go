@@ -0,0 +1,473 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Probabilidade padrão de fechamento de cada estágio do funil de vendas
+var opportunityStages = map[string]float64{
+	"prospecting":   0.10,
+	"qualification": 0.25,
+	"proposal":      0.50,
+	"negotiation":   0.75,
+	"closed_won":    1,
+	"closed_lost":   0,
+}
+
+// Categorias de previsão declaradas pelo vendedor
+var forecastCategories = map[string]bool{
+	"pipeline":  true,
+	"best_case": true,
+	"commit":    true,
+	"omitted":   true,
+}
+
+func isClosedStage(stage string) bool {
+	return stage == "closed_won" || stage == "closed_lost"
+}
+
+type Opportunity struct {
+	ID                int        `json:"id"`
+	CustomerID        *int       `json:"customer_id"`
+	OwnerID           string     `json:"owner_id"`
+	Name              string     `json:"name"`
+	Amount            float64    `json:"amount"`
+	Stage             string     `json:"stage"`
+	Probability       float64    `json:"probability"`
+	ForecastCategory  string     `json:"forecast_category"`
+	ExpectedCloseDate time.Time  `json:"expected_close_date"`
+	ClosedAt          *time.Time `json:"closed_at"`
+	LostReason        *string    `json:"lost_reason"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// Campos aceitos na criação e atualização. Probability nula usa a do estágio.
+type opportunityInput struct {
+	CustomerID        *int     `json:"customer_id"`
+	OwnerID           *int     `json:"owner_id"`
+	Name              string   `json:"name" binding:"required"`
+	Amount            float64  `json:"amount" binding:"required,gt=0"`
+	Stage             string   `json:"stage" binding:"required"`
+	Probability       *float64 `json:"probability"`
+	ForecastCategory  string   `json:"forecast_category"`
+	ExpectedCloseDate string   `json:"expected_close_date" binding:"required"`
+	LostReason        *string  `json:"lost_reason"`
+}
+
+func (in *opportunityInput) validate() (time.Time, string) {
+	if _, ok := opportunityStages[in.Stage]; !ok {
+		return time.Time{}, "Estágio inválido"
+	}
+	if in.ForecastCategory == "" {
+		in.ForecastCategory = "pipeline"
+	}
+	if !forecastCategories[in.ForecastCategory] {
+		return time.Time{}, "forecast_category deve ser pipeline, best_case, commit ou omitted"
+	}
+	if in.Probability != nil && (*in.Probability < 0 || *in.Probability > 1) {
+		return time.Time{}, "probability deve estar entre 0 e 1"
+	}
+	closeDate, err := time.Parse("2006-01-02", in.ExpectedCloseDate)
+	if err != nil {
+		return time.Time{}, "expected_close_date deve estar no formato AAAA-MM-DD"
+	}
+	return closeDate, ""
+}
+
+func createOpportunityTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS opportunities (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			customer_id INTEGER REFERENCES customers(id) ON DELETE SET NULL,
+			owner_id INTEGER NOT NULL,
+			name VARCHAR(200) NOT NULL,
+			amount DECIMAL(12, 2) NOT NULL,
+			stage VARCHAR(30) NOT NULL,
+			probability DOUBLE PRECISION,
+			forecast_category VARCHAR(20) NOT NULL DEFAULT 'pipeline',
+			expected_close_date DATE NOT NULL,
+			closed_at TIMESTAMP,
+			lost_reason VARCHAR(200),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_opportunities_owner ON opportunities (tenant_id, owner_id);
+		CREATE INDEX IF NOT EXISTS idx_opportunities_close ON opportunities (tenant_id, expected_close_date);
+
+		-- Cada mudança de estágio, para análise de funil e tempo em estágio
+		CREATE TABLE IF NOT EXISTS opportunity_stage_history (
+			id SERIAL PRIMARY KEY,
+			opportunity_id INTEGER NOT NULL REFERENCES opportunities(id) ON DELETE CASCADE,
+			from_stage VARCHAR(30),
+			to_stage VARCHAR(30) NOT NULL,
+			changed_by VARCHAR(100),
+			changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_opportunity_stage_history ON opportunity_stage_history (opportunity_id, changed_at);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupOpportunityRoutes(r *gin.Engine) {
+	opportunities := r.Group("/opportunities")
+	opportunities.Use(AuthMiddleware())
+	{
+		opportunities.POST("", createOpportunity)
+		opportunities.GET("", listOpportunities)
+		opportunities.GET("/:id", getOpportunity)
+		opportunities.PUT("/:id", updateOpportunity)
+		opportunities.DELETE("/:id", deleteOpportunity)
+		opportunities.GET("/:id/history", getOpportunityHistory)
+	}
+}
+
+const opportunityColumns = `id, customer_id, owner_id::text, name, amount, stage, probability, forecast_category,
+	expected_close_date, closed_at, lost_reason, created_at, updated_at`
+
+func scanOpportunity(row interface{ Scan(...interface{}) error }) (Opportunity, error) {
+	var o Opportunity
+	var customerID sql.NullInt64
+	var probability sql.NullFloat64
+	var closedAt sql.NullTime
+	var lostReason sql.NullString
+	err := row.Scan(&o.ID, &customerID, &o.OwnerID, &o.Name, &o.Amount, &o.Stage, &probability,
+		&o.ForecastCategory, &o.ExpectedCloseDate, &closedAt, &lostReason, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return o, err
+	}
+	if customerID.Valid {
+		id := int(customerID.Int64)
+		o.CustomerID = &id
+	}
+	o.Probability = opportunityStages[o.Stage]
+	if probability.Valid && !isClosedStage(o.Stage) {
+		o.Probability = probability.Float64
+	}
+	if closedAt.Valid {
+		o.ClosedAt = &closedAt.Time
+	}
+	if lostReason.Valid {
+		o.LostReason = &lostReason.String
+	}
+	return o, nil
+}
+
+// Confere se o responsável é usuário do tenant; responde 400 caso contrário
+func validOpportunityOwner(c *gin.Context, ownerID int) bool {
+	exist, err := tenantUsersExist(tenantFromContext(c), []int{ownerID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao validar responsável"})
+		return false
+	}
+	if !exist {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Responsável não encontrado"})
+		return false
+	}
+	return true
+}
+
+// Libera dados e alterações do vendedor owner ao próprio vendedor, ao seu
+// gestor e aos administradores. owner vazio (todos os vendedores) é
+// restrito aos administradores.
+func authorizeOwner(c *gin.Context, owner string) bool {
+	actor := c.GetString("user_id")
+	if c.GetString("role") == "admin" || (owner != "" && owner == actor) {
+		return true
+	}
+	if owner != "" {
+		manages, err := managesUser(tenantFromContext(c), actor, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao verificar time"})
+			return false
+		}
+		if manages {
+			return true
+		}
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Sem permissão para os dados deste vendedor"})
+	return false
+}
+
+func createOpportunity(c *gin.Context) {
+	var in opportunityInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	closeDate, problem := in.validate()
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+
+	owner := c.GetString("user_id")
+	if in.OwnerID != nil {
+		if !validOpportunityOwner(c, *in.OwnerID) {
+			return
+		}
+		owner = strconv.Itoa(*in.OwnerID)
+	}
+	var closedAt *time.Time
+	if isClosedStage(in.Stage) {
+		now := time.Now()
+		closedAt = &now
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar oportunidade"})
+		return
+	}
+	defer tx.Rollback()
+
+	opportunity, err := scanOpportunity(tx.QueryRow(`
+		INSERT INTO opportunities (tenant_id, customer_id, owner_id, name, amount, stage, probability,
+			forecast_category, expected_close_date, closed_at, lost_reason)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		WHERE $2::integer IS NULL OR EXISTS (SELECT 1 FROM customers WHERE id = $2 AND tenant_id = $1)
+		RETURNING `+opportunityColumns,
+		tenantFromContext(c), in.CustomerID, owner, in.Name, in.Amount, in.Stage, in.Probability,
+		in.ForecastCategory, closeDate, closedAt, in.LostReason,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar oportunidade"})
+		return
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO opportunity_stage_history (opportunity_id, to_stage, changed_by) VALUES ($1, $2, $3)",
+		opportunity.ID, opportunity.Stage, c.GetString("user_id"),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar estágio"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar oportunidade"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, opportunity)
+}
+
+// Lista oportunidades com filtros opcionais de responsável, estágio e
+// intervalo de data prevista de fechamento
+func listOpportunities(c *gin.Context) {
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+
+	rows, err := db.Query(`
+		SELECT `+opportunityColumns+`
+		FROM opportunities
+		WHERE tenant_id = $1
+			AND ($2 = '' OR owner_id::text = $2)
+			AND ($3 = '' OR stage = $3)
+			AND ($4 = '' OR expected_close_date >= $4::date)
+			AND ($5 = '' OR expected_close_date <= $5::date)
+		ORDER BY expected_close_date, id`,
+		tenantFromContext(c), owner, c.Query("stage"), c.Query("close_from"), c.Query("close_to"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar oportunidades"})
+		return
+	}
+	defer rows.Close()
+
+	opportunities := []Opportunity{}
+	for rows.Next() {
+		opportunity, err := scanOpportunity(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler oportunidades"})
+			return
+		}
+		opportunities = append(opportunities, opportunity)
+	}
+	c.JSON(http.StatusOK, gin.H{"opportunities": opportunities})
+}
+
+func getOpportunity(c *gin.Context) {
+	opportunity, err := scanOpportunity(db.QueryRow(
+		"SELECT "+opportunityColumns+" FROM opportunities WHERE id::text = $1 AND tenant_id = $2",
+		c.Param("id"), tenantFromContext(c),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Oportunidade não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar oportunidade"})
+		return
+	}
+	c.JSON(http.StatusOK, opportunity)
+}
+
+// Atualiza a oportunidade. Mudanças de estágio ficam no histórico e o
+// fechamento registra closed_at.
+func updateOpportunity(c *gin.Context) {
+	var in opportunityInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	closeDate, problem := in.validate()
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return
+	}
+
+	if in.OwnerID != nil && !validOpportunityOwner(c, *in.OwnerID) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar oportunidade"})
+		return
+	}
+	defer tx.Rollback()
+
+	var previousStage, currentOwner string
+	err = tx.QueryRow(
+		"SELECT stage, owner_id::text FROM opportunities WHERE id::text = $1 AND tenant_id = $2 FOR UPDATE",
+		c.Param("id"), tenantFromContext(c),
+	).Scan(&previousStage, &currentOwner)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Oportunidade não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar oportunidade"})
+		return
+	}
+
+	if !authorizeOwner(c, currentOwner) {
+		return
+	}
+
+	opportunity, err := scanOpportunity(tx.QueryRow(`
+		UPDATE opportunities SET
+			customer_id = $3, owner_id = COALESCE($4, owner_id), name = $5, amount = $6, stage = $7,
+			probability = $8, forecast_category = $9, expected_close_date = $10, lost_reason = $11,
+			closed_at = CASE
+				WHEN $7 NOT IN ('closed_won', 'closed_lost') THEN NULL
+				WHEN stage = $7 THEN closed_at
+				ELSE CURRENT_TIMESTAMP
+			END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id::text = $1 AND tenant_id = $2
+			AND ($3::integer IS NULL OR EXISTS (SELECT 1 FROM customers WHERE id = $3 AND tenant_id = $2))
+		RETURNING `+opportunityColumns,
+		c.Param("id"), tenantFromContext(c), in.CustomerID, in.OwnerID, in.Name, in.Amount, in.Stage,
+		in.Probability, in.ForecastCategory, closeDate, in.LostReason,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar oportunidade"})
+		return
+	}
+
+	if previousStage != opportunity.Stage {
+		if _, err := tx.Exec(
+			"INSERT INTO opportunity_stage_history (opportunity_id, from_stage, to_stage, changed_by) VALUES ($1, $2, $3, $4)",
+			opportunity.ID, previousStage, opportunity.Stage, c.GetString("user_id"),
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar estágio"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar oportunidade"})
+		return
+	}
+
+	c.JSON(http.StatusOK, opportunity)
+}
+
+func deleteOpportunity(c *gin.Context) {
+	var owner string
+	err := db.QueryRow(
+		"SELECT owner_id::text FROM opportunities WHERE id::text = $1 AND tenant_id = $2",
+		c.Param("id"), tenantFromContext(c),
+	).Scan(&owner)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Oportunidade não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar oportunidade"})
+		return
+	}
+	if !authorizeOwner(c, owner) {
+		return
+	}
+
+	// O responsável não muda entre a verificação e a exclusão
+	res, err := db.Exec(
+		"DELETE FROM opportunities WHERE id::text = $1 AND tenant_id = $2 AND owner_id::text = $3",
+		c.Param("id"), tenantFromContext(c), owner,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao excluir oportunidade"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Oportunidade alterada durante a exclusão"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "opportunity_deleted", "opportunity", c.Param("id"), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Oportunidade excluída com sucesso"})
+}
+
+func getOpportunityHistory(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT h.from_stage, h.to_stage, h.changed_by, h.changed_at
+		FROM opportunity_stage_history h
+		JOIN opportunities o ON o.id = h.opportunity_id
+		WHERE o.id::text = $1 AND o.tenant_id = $2
+		ORDER BY h.changed_at, h.id`,
+		c.Param("id"), tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar histórico"})
+		return
+	}
+	defer rows.Close()
+
+	history := []gin.H{}
+	for rows.Next() {
+		var from, changedBy sql.NullString
+		var to string
+		var changedAt time.Time
+		if err := rows.Scan(&from, &to, &changedBy, &changedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler histórico"})
+			return
+		}
+		entry := gin.H{"from_stage": nil, "to_stage": to, "changed_by": changedBy.String, "changed_at": changedAt}
+		if from.Valid {
+			entry["from_stage"] = from.String
+		}
+		history = append(history, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"opportunity_id": c.Param("id"), "history": history})
+}
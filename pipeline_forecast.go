@@ -0,0 +1,614 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+var errInvalidForecastPeriod = errors.New("período deve estar no formato AAAA-MM ou AAAA-QN")
+
+// Período de previsão: mês (2026-10) ou trimestre (2026-Q4), com fim exclusivo
+type forecastPeriod struct {
+	Key   string
+	Start time.Time
+	End   time.Time
+}
+
+var forecastPeriodPattern = regexp.MustCompile(`^\d{4}-(Q[1-4]|\d{2})$`)
+
+func parseForecastPeriod(value string) (forecastPeriod, error) {
+	if !forecastPeriodPattern.MatchString(value) {
+		return forecastPeriod{}, errInvalidForecastPeriod
+	}
+	var year, number int
+	if _, err := fmt.Sscanf(value, "%4d-Q%1d", &year, &number); err == nil {
+		start := time.Date(year, time.Month(3*(number-1)+1), 1, 0, 0, 0, 0, time.UTC)
+		return forecastPeriod{Key: value, Start: start, End: start.AddDate(0, 3, 0)}, nil
+	}
+	start, err := time.Parse("2006-01", value)
+	if err != nil {
+		return forecastPeriod{}, errInvalidForecastPeriod
+	}
+	return forecastPeriod{Key: value, Start: start, End: start.AddDate(0, 1, 0)}, nil
+}
+
+func currentQuarter(now time.Time) forecastPeriod {
+	period, _ := parseForecastPeriod(fmt.Sprintf("%d-Q%d", now.Year(), (int(now.Month())-1)/3+1))
+	return period
+}
+
+// Previsão do funil de um vendedor em um período. Commit e best case
+// incluem o que já foi ganho; weighted pondera as oportunidades abertas
+// pela probabilidade do estágio (ou a informada pelo vendedor).
+type PipelineForecast struct {
+	OwnerID   string  `json:"owner_id"`
+	ClosedWon float64 `json:"closed_won"`
+	Commit    float64 `json:"commit"`
+	BestCase  float64 `json:"best_case"`
+	Pipeline  float64 `json:"pipeline"`
+	Weighted  float64 `json:"weighted"`
+	OpenDeals int     `json:"open_deals"`
+}
+
+func aggregatePipeline(opportunities []Opportunity, period forecastPeriod) map[string]*PipelineForecast {
+	forecasts := map[string]*PipelineForecast{}
+	for _, o := range opportunities {
+		f, ok := forecasts[o.OwnerID]
+		if !ok {
+			f = &PipelineForecast{OwnerID: o.OwnerID}
+			forecasts[o.OwnerID] = f
+		}
+
+		switch {
+		case o.Stage == "closed_won":
+			if o.ClosedAt == nil || o.ClosedAt.Before(period.Start) || !o.ClosedAt.Before(period.End) {
+				continue
+			}
+			f.ClosedWon += o.Amount
+			f.Commit += o.Amount
+			f.BestCase += o.Amount
+			f.Pipeline += o.Amount
+			f.Weighted += o.Amount
+		case isClosedStage(o.Stage), o.ForecastCategory == "omitted":
+			continue
+		default:
+			if o.ExpectedCloseDate.Before(period.Start) || !o.ExpectedCloseDate.Before(period.End) {
+				continue
+			}
+			f.OpenDeals++
+			f.Pipeline += o.Amount
+			f.Weighted += o.Amount * o.Probability
+			if o.ForecastCategory == "commit" {
+				f.Commit += o.Amount
+			}
+			if o.ForecastCategory == "commit" || o.ForecastCategory == "best_case" {
+				f.BestCase += o.Amount
+			}
+		}
+	}
+	return forecasts
+}
+
+func createPipelineForecastTables() {
+	_, err := db.Exec(`
+		-- Histórico de previsões: cada envio do vendedor ou ajuste do gestor
+		-- gera uma linha, com os números do funil no momento do envio
+		CREATE TABLE IF NOT EXISTS forecast_submissions (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			owner_id INTEGER NOT NULL,
+			period VARCHAR(7) NOT NULL,
+			kind VARCHAR(10) NOT NULL CHECK (kind IN ('submission', 'override')),
+			amount DECIMAL(12, 2) NOT NULL,
+			notes TEXT,
+			submitted_by VARCHAR(100) NOT NULL,
+			pipeline_commit DECIMAL(12, 2) NOT NULL,
+			pipeline_best_case DECIMAL(12, 2) NOT NULL,
+			pipeline_weighted DECIMAL(12, 2) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_forecast_submissions ON forecast_submissions (tenant_id, owner_id, period, created_at);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Oportunidades que contam para o período: ganhas dentro dele ou abertas
+// com fechamento previsto nele
+func loadPipelineOpportunities(tenantID, owner string, period forecastPeriod) ([]Opportunity, error) {
+	rows, err := db.Query(`
+		SELECT `+opportunityColumns+`
+		FROM opportunities
+		WHERE tenant_id = $1 AND ($2 = '' OR owner_id::text = $2)
+			AND ((stage = 'closed_won' AND closed_at >= $3 AND closed_at < $4)
+				OR (stage NOT IN ('closed_won', 'closed_lost') AND expected_close_date >= $3 AND expected_close_date < $4))`,
+		tenantID, owner, period.Start, period.End,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var opportunities []Opportunity
+	for rows.Next() {
+		opportunity, err := scanOpportunity(rows)
+		if err != nil {
+			return nil, err
+		}
+		opportunities = append(opportunities, opportunity)
+	}
+	return opportunities, rows.Err()
+}
+
+func ownerPipeline(tenantID, owner string, period forecastPeriod) (PipelineForecast, error) {
+	opportunities, err := loadPipelineOpportunities(tenantID, owner, period)
+	if err != nil {
+		return PipelineForecast{}, err
+	}
+	if f, ok := aggregatePipeline(opportunities, period)[owner]; ok {
+		return *f, nil
+	}
+	return PipelineForecast{OwnerID: owner}, nil
+}
+
+type forecastCall struct {
+	Amount      float64   `json:"amount"`
+	Notes       string    `json:"notes"`
+	SubmittedBy string    `json:"submitted_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Último envio e último ajuste de cada vendedor no período
+func latestForecastCalls(tenantID, owner, period string) (map[string]map[string]forecastCall, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (owner_id, kind) owner_id::text, kind, amount, COALESCE(notes, ''), submitted_by, created_at
+		FROM forecast_submissions
+		WHERE tenant_id = $1 AND period = $2 AND ($3 = '' OR owner_id::text = $3)
+		ORDER BY owner_id, kind, created_at DESC, id DESC`,
+		tenantID, period, owner,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	calls := map[string]map[string]forecastCall{}
+	for rows.Next() {
+		var ownerID, kind string
+		var call forecastCall
+		if err := rows.Scan(&ownerID, &kind, &call.Amount, &call.Notes, &call.SubmittedBy, &call.CreatedAt); err != nil {
+			return nil, err
+		}
+		if calls[ownerID] == nil {
+			calls[ownerID] = map[string]forecastCall{}
+		}
+		calls[ownerID][kind] = call
+	}
+	return calls, rows.Err()
+}
+
+// O ajuste do gestor prevalece sobre o envio do vendedor
+func effectiveCall(calls map[string]forecastCall) *forecastCall {
+	if call, ok := calls["override"]; ok {
+		return &call
+	}
+	if call, ok := calls["submission"]; ok {
+		return &call
+	}
+	return nil
+}
+
+func periodFromQuery(c *gin.Context) (forecastPeriod, bool) {
+	value := c.Query("period")
+	if value == "" {
+		return currentQuarter(time.Now()), true
+	}
+	period, err := parseForecastPeriod(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return period, false
+	}
+	return period, true
+}
+
+// Previsão do funil (commit, best case, pipeline e ponderada) por vendedor,
+// com a previsão enviada e o ajuste do gestor
+func getPipelineForecast(c *gin.Context) {
+	period, ok := periodFromQuery(c)
+	if !ok {
+		return
+	}
+	tenantID := tenantFromContext(c)
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+
+	// owner=team restringe aos vendedores dos times do gestor
+	var team map[string]bool
+	if owner == "team" {
+		ids, err := managedUserIDs(tenantID, c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar time"})
+			return
+		}
+		team = map[string]bool{}
+		for _, id := range ids {
+			team[id] = true
+		}
+		owner = ""
+	} else if !authorizeOwner(c, owner) {
+		return
+	}
+
+	opportunities, err := loadPipelineOpportunities(tenantID, owner, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular previsão do funil"})
+		return
+	}
+	calls, err := latestForecastCalls(tenantID, owner, period.Key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar previsões enviadas"})
+		return
+	}
+
+	forecasts := aggregatePipeline(opportunities, period)
+	for ownerID := range calls {
+		if _, ok := forecasts[ownerID]; !ok {
+			forecasts[ownerID] = &PipelineForecast{OwnerID: ownerID}
+		}
+	}
+
+	owners := make([]string, 0, len(forecasts))
+	for ownerID := range forecasts {
+		if team == nil || team[ownerID] {
+			owners = append(owners, ownerID)
+		}
+	}
+	sort.Strings(owners)
+
+	var total PipelineForecast
+	var totalCall float64
+	reps := make([]gin.H, 0, len(owners))
+	for _, ownerID := range owners {
+		f := forecasts[ownerID]
+		entry := gin.H{"pipeline": f, "submission": nil, "override": nil, "call": nil}
+		if call, ok := calls[ownerID]["submission"]; ok {
+			entry["submission"] = call
+		}
+		if call, ok := calls[ownerID]["override"]; ok {
+			entry["override"] = call
+		}
+		if call := effectiveCall(calls[ownerID]); call != nil {
+			entry["call"] = call.Amount
+			totalCall += call.Amount
+		}
+		reps = append(reps, entry)
+
+		total.ClosedWon += f.ClosedWon
+		total.Commit += f.Commit
+		total.BestCase += f.BestCase
+		total.Pipeline += f.Pipeline
+		total.Weighted += f.Weighted
+		total.OpenDeals += f.OpenDeals
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"period":     period.Key,
+		"start":      period.Start.Format("2006-01-02"),
+		"end":        period.End.AddDate(0, 0, -1).Format("2006-01-02"),
+		"total":      total,
+		"total_call": totalCall,
+		"reps":       reps,
+	})
+}
+
+// Grava a previsão com os números atuais do funil do vendedor
+func insertForecastSubmission(tenantID, owner string, period forecastPeriod, kind string, amount float64, notes, actor string) (int, error) {
+	pipeline, err := ownerPipeline(tenantID, owner, period)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = db.QueryRow(`
+		INSERT INTO forecast_submissions (tenant_id, owner_id, period, kind, amount, notes, submitted_by,
+			pipeline_commit, pipeline_best_case, pipeline_weighted)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, $10)
+		RETURNING id`,
+		tenantID, owner, period.Key, kind, amount, notes, actor,
+		pipeline.Commit, pipeline.BestCase, pipeline.Weighted,
+	).Scan(&id)
+	return id, err
+}
+
+type forecastSubmissionRequest struct {
+	OwnerID int      `json:"owner_id"`
+	Period  string   `json:"period" binding:"required"`
+	Amount  *float64 `json:"amount" binding:"required"`
+	Notes   string   `json:"notes"`
+}
+
+func bindForecastSubmission(c *gin.Context) (forecastSubmissionRequest, forecastPeriod, bool) {
+	var request forecastSubmissionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, forecastPeriod{}, false
+	}
+	if *request.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount não pode ser negativo"})
+		return request, forecastPeriod{}, false
+	}
+	period, err := parseForecastPeriod(request.Period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return request, period, false
+	}
+	if !time.Now().Before(period.End) {
+		c.JSON(http.StatusConflict, gin.H{"error": "O período já foi encerrado"})
+		return request, period, false
+	}
+	return request, period, true
+}
+
+// Envio da previsão do próprio vendedor
+func submitForecast(c *gin.Context) {
+	request, period, ok := bindForecastSubmission(c)
+	if !ok {
+		return
+	}
+
+	actor := c.GetString("user_id")
+	id, err := insertForecastSubmission(tenantFromContext(c), actor, period, "submission", *request.Amount, request.Notes, actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar previsão"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": id, "owner_id": actor, "period": period.Key, "amount": *request.Amount})
+}
+
+// Ajuste do gestor sobre a previsão de um vendedor do seu time
+func overrideForecast(c *gin.Context) {
+	request, period, ok := bindForecastSubmission(c)
+	if !ok {
+		return
+	}
+	if request.OwnerID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "owner_id é obrigatório"})
+		return
+	}
+
+	tenantID := tenantFromContext(c)
+	actor := c.GetString("user_id")
+	owner := strconv.Itoa(request.OwnerID)
+	manages, err := managesUser(tenantID, actor, owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao verificar time"})
+		return
+	}
+	if !manages {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Apenas o gestor do vendedor pode ajustar sua previsão"})
+		return
+	}
+
+	id, err := insertForecastSubmission(tenantID, owner, period, "override", *request.Amount, request.Notes, actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar ajuste"})
+		return
+	}
+
+	recordAudit(tenantID, actor, "forecast_overridden", "forecast", owner+":"+period.Key, gin.H{"amount": *request.Amount, "notes": request.Notes})
+	c.JSON(http.StatusCreated, gin.H{"id": id, "owner_id": owner, "period": period.Key, "amount": *request.Amount})
+}
+
+// Histórico completo de envios e ajustes
+func listForecastSubmissions(c *gin.Context) {
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+	if !authorizeOwner(c, owner) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, owner_id::text, period, kind, amount, COALESCE(notes, ''), submitted_by,
+			pipeline_commit, pipeline_best_case, pipeline_weighted, created_at
+		FROM forecast_submissions
+		WHERE tenant_id = $1 AND ($2 = '' OR owner_id::text = $2) AND ($3 = '' OR period = $3)
+		ORDER BY created_at DESC, id DESC
+		LIMIT 500`,
+		tenantFromContext(c), owner, c.Query("period"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar previsões"})
+		return
+	}
+	defer rows.Close()
+
+	submissions := []gin.H{}
+	for rows.Next() {
+		var id int
+		var ownerID, period, kind, notes, submittedBy string
+		var amount, commit, bestCase, weighted float64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &ownerID, &period, &kind, &amount, &notes, &submittedBy,
+			&commit, &bestCase, &weighted, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler previsões"})
+			return
+		}
+		submissions = append(submissions, gin.H{
+			"id":           id,
+			"owner_id":     ownerID,
+			"period":       period,
+			"kind":         kind,
+			"amount":       amount,
+			"notes":        notes,
+			"submitted_by": submittedBy,
+			"pipeline":     gin.H{"commit": commit, "best_case": bestCase, "weighted": weighted},
+			"created_at":   createdAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"submissions": submissions})
+}
+
+// Erro percentual da previsão em relação ao realizado. Sem realizado, o
+// erro é 0 se a previsão também era zero e 100% caso contrário.
+func forecastError(call, actual float64) float64 {
+	if actual == 0 {
+		if call == 0 {
+			return 0
+		}
+		return 1
+	}
+	return (call - actual) / actual
+}
+
+type forecastAccuracyEntry struct {
+	Period        string   `json:"period"`
+	Actual        float64  `json:"actual"`
+	Call          *float64 `json:"call"`
+	Submission    *float64 `json:"submission"`
+	Override      *float64 `json:"override"`
+	Weighted      float64  `json:"weighted_at_submission"`
+	CallError     *float64 `json:"call_error"`
+	WeightedError float64  `json:"weighted_error"`
+}
+
+// Acurácia por vendedor nos períodos encerrados: compara a última previsão
+// (e o funil ponderado no momento dela) com o valor efetivamente ganho
+func getForecastAccuracy(c *gin.Context) {
+	owner := c.Query("owner")
+	if owner == "me" {
+		owner = c.GetString("user_id")
+	}
+	if !authorizeOwner(c, owner) {
+		return
+	}
+	tenantID := tenantFromContext(c)
+
+	rows, err := db.Query(`
+		SELECT DISTINCT ON (owner_id, period, kind) owner_id::text, period, kind, amount, pipeline_weighted
+		FROM forecast_submissions
+		WHERE tenant_id = $1 AND ($2 = '' OR owner_id::text = $2)
+		ORDER BY owner_id, period, kind, created_at DESC, id DESC`,
+		tenantID, owner,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular acurácia"})
+		return
+	}
+
+	type key struct{ owner, period string }
+	entries := map[key]*forecastAccuracyEntry{}
+	now := time.Now()
+	for rows.Next() {
+		var ownerID, periodKey, kind string
+		var amount, weighted float64
+		if err := rows.Scan(&ownerID, &periodKey, &kind, &amount, &weighted); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler previsões"})
+			return
+		}
+		period, err := parseForecastPeriod(periodKey)
+		if err != nil || now.Before(period.End) {
+			continue
+		}
+
+		k := key{ownerID, periodKey}
+		entry, ok := entries[k]
+		if !ok {
+			entry = &forecastAccuracyEntry{Period: periodKey}
+			entries[k] = entry
+		}
+		value := amount
+		if kind == "override" {
+			entry.Override = &value
+		} else {
+			entry.Submission = &value
+			entry.Weighted = weighted
+		}
+	}
+	rows.Close()
+
+	// Realizado de todos os pares vendedor/período em uma única consulta
+	var owners, periods, starts, ends []string
+	for k := range entries {
+		period, _ := parseForecastPeriod(k.period)
+		owners = append(owners, k.owner)
+		periods = append(periods, k.period)
+		starts = append(starts, period.Start.Format("2006-01-02"))
+		ends = append(ends, period.End.Format("2006-01-02"))
+	}
+	rows, err = db.Query(`
+		SELECT p.owner_id, p.period, COALESCE(SUM(o.amount), 0)
+		FROM unnest($2::text[], $3::text[], $4::date[], $5::date[]) AS p(owner_id, period, start_date, end_date)
+		LEFT JOIN opportunities o ON o.tenant_id = $1 AND o.owner_id::text = p.owner_id
+			AND o.stage = 'closed_won' AND o.closed_at >= p.start_date AND o.closed_at < p.end_date
+		GROUP BY p.owner_id, p.period`,
+		tenantID, pq.Array(owners), pq.Array(periods), pq.Array(starts), pq.Array(ends),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular realizado"})
+		return
+	}
+	for rows.Next() {
+		var k key
+		var actual float64
+		if err := rows.Scan(&k.owner, &k.period, &actual); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular realizado"})
+			return
+		}
+		if entry, ok := entries[k]; ok {
+			entry.Actual = actual
+		}
+	}
+	rows.Close()
+
+	byOwner := map[string][]*forecastAccuracyEntry{}
+	for k, entry := range entries {
+		entry.Call = entry.Submission
+		if entry.Override != nil {
+			entry.Call = entry.Override
+		}
+		callError := forecastError(*entry.Call, entry.Actual)
+		entry.CallError = &callError
+		entry.WeightedError = forecastError(entry.Weighted, entry.Actual)
+		byOwner[k.owner] = append(byOwner[k.owner], entry)
+	}
+
+	reps := []gin.H{}
+	for ownerID, history := range byOwner {
+		sort.Slice(history, func(i, j int) bool { return history[i].Period < history[j].Period })
+		var absError, bias float64
+		for _, entry := range history {
+			absError += math.Abs(*entry.CallError)
+			bias += *entry.CallError
+		}
+		n := float64(len(history))
+		reps = append(reps, gin.H{
+			"owner_id":            ownerID,
+			"periods":             len(history),
+			"mean_absolute_error": absError / n,
+			"bias":                bias / n,
+			"accuracy":            math.Max(0, 1-absError/n),
+			"history":             history,
+		})
+	}
+	sort.Slice(reps, func(i, j int) bool { return reps[i]["owner_id"].(string) < reps[j]["owner_id"].(string) })
+
+	c.JSON(http.StatusOK, gin.H{"reps": reps})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForecastPeriod(t *testing.T) {
+	quarter, err := parseForecastPeriod("2026-Q4")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), quarter.Start)
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), quarter.End)
+
+	month, err := parseForecastPeriod("2026-02")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), month.End)
+
+	_, err = parseForecastPeriod("2026-Q5")
+	assert.ErrorIs(t, err, errInvalidForecastPeriod)
+	_, err = parseForecastPeriod("2026-Q4abc")
+	assert.ErrorIs(t, err, errInvalidForecastPeriod)
+}
+
+func TestAggregatePipeline(t *testing.T) {
+	period, _ := parseForecastPeriod("2026-Q4")
+	inPeriod := time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+
+	opportunities := []Opportunity{
+		{OwnerID: "1", Amount: 1000, Stage: "closed_won", ClosedAt: &inPeriod, ForecastCategory: "commit"},
+		{OwnerID: "1", Amount: 500, Stage: "closed_won", ClosedAt: &before, ForecastCategory: "commit"},
+		{OwnerID: "1", Amount: 2000, Stage: "negotiation", Probability: 0.75, ForecastCategory: "commit", ExpectedCloseDate: inPeriod},
+		{OwnerID: "1", Amount: 4000, Stage: "proposal", Probability: 0.5, ForecastCategory: "best_case", ExpectedCloseDate: inPeriod},
+		{OwnerID: "1", Amount: 8000, Stage: "prospecting", Probability: 0.1, ForecastCategory: "pipeline", ExpectedCloseDate: inPeriod},
+		{OwnerID: "1", Amount: 9000, Stage: "proposal", Probability: 0.5, ForecastCategory: "omitted", ExpectedCloseDate: inPeriod},
+		{OwnerID: "2", Amount: 3000, Stage: "closed_lost", ForecastCategory: "commit", ExpectedCloseDate: inPeriod},
+	}
+
+	forecasts := aggregatePipeline(opportunities, period)
+	rep := forecasts["1"]
+	assert.Equal(t, 1000.0, rep.ClosedWon)
+	assert.Equal(t, 3000.0, rep.Commit)
+	assert.Equal(t, 7000.0, rep.BestCase)
+	assert.Equal(t, 15000.0, rep.Pipeline)
+	assert.InDelta(t, 1000+1500+2000+800, rep.Weighted, 1e-9)
+	assert.Equal(t, 3, rep.OpenDeals)
+	assert.Zero(t, forecasts["2"].Pipeline)
+}
+
+func TestForecastError(t *testing.T) {
+	assert.InDelta(t, 0.25, forecastError(1250, 1000), 1e-9)
+	assert.InDelta(t, -0.5, forecastError(500, 1000), 1e-9)
+	assert.Equal(t, 0.0, forecastError(0, 0))
+	assert.Equal(t, 1.0, forecastError(100, 0))
+}
+
+func TestAuthorizeOwnerWithoutTeamLookup(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("user_id", "7")
+	c.Set("role", "sales")
+	assert.True(t, authorizeOwner(c, "7"))
+
+	// Todos os vendedores só para administradores
+	assert.False(t, authorizeOwner(c, ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	c.Set("role", "admin")
+	assert.True(t, authorizeOwner(c, ""))
+	assert.True(t, authorizeOwner(c, "9"))
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prazo para atender solicitações de titulares (LGPD, art. 19, II)
+const dataSubjectRequestDeadline = 15 * 24 * time.Hour
+
+const (
+	dsrTypeAccess  = "access"
+	dsrTypeErasure = "erasure"
+
+	dsrStatusPending   = "pending"
+	dsrStatusCompleted = "completed"
+	dsrStatusFailed    = "failed"
+)
+
+type DataSubjectRequest struct {
+	ID          int64      `json:"id"`
+	CustomerID  string     `json:"customer_id"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	Deadline    time.Time  `json:"deadline"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Overdue     bool       `json:"overdue"`
+}
+
+func createPrivacyTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS data_subject_requests (
+			id BIGSERIAL PRIMARY KEY,
+			customer_id INTEGER NOT NULL,
+			type VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			requested_by VARCHAR(100) NOT NULL,
+			requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			deadline TIMESTAMP NOT NULL,
+			completed_at TIMESTAMP,
+			error TEXT
+		);
+
+		ALTER TABLE data_subject_requests ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+		UPDATE data_subject_requests r SET tenant_id = c.tenant_id
+		FROM customers c
+		WHERE c.id = r.customer_id AND r.tenant_id <> c.tenant_id;
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupPrivacyRoutes(r *gin.Engine) {
+	privacy := r.Group("/privacy")
+	privacy.Use(AuthMiddleware())
+	{
+		privacy.GET("/requests", roleAuthorization("admin", "dpo"), listDataSubjectRequests)
+		privacy.GET("/audit", roleAuthorization("admin", "dpo"), getAuditTrail)
+		privacy.POST("/consents/status", getBulkConsentStatus)
+	}
+	setupRetentionRoutes(privacy)
+	setupEncryptionRoutes(privacy)
+}
+
+func openDataSubjectRequest(tenantID, customerID, requestType, requestedBy string) (int64, error) {
+	var id int64
+	err := db.QueryRow(
+		"INSERT INTO data_subject_requests (tenant_id, customer_id, type, requested_by, deadline) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		tenantID, customerID, requestType, requestedBy, time.Now().Add(dataSubjectRequestDeadline),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	recordAudit(tenantID, requestedBy, "dsr_opened", "customer", customerID, gin.H{"request_id": id, "type": requestType})
+	return id, nil
+}
+
+func closeDataSubjectRequest(tenantID string, requestID int64, customerID, actor string, cause error) {
+	status, errMsg := dsrStatusCompleted, ""
+	if cause != nil {
+		status, errMsg = dsrStatusFailed, cause.Error()
+	}
+
+	_, err := db.Exec(
+		"UPDATE data_subject_requests SET status = $1, completed_at = $2, error = NULLIF($3, '') WHERE id = $4",
+		status, time.Now(), errMsg, requestID,
+	)
+	if err != nil {
+		logger.Errorf("Falha ao atualizar solicitação de titular %d: %v", requestID, err)
+	}
+
+	recordAudit(tenantID, actor, "dsr_"+status, "customer", customerID, gin.H{"request_id": requestID, "error": errMsg})
+}
+
+func listDataSubjectRequests(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT id, customer_id, type, status, requested_by, requested_at, deadline, completed_at
+		FROM data_subject_requests
+		WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY deadline ASC`,
+		tenantFromContext(c), c.Query("status"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar solicitações"})
+		return
+	}
+	defer rows.Close()
+
+	requests := []DataSubjectRequest{}
+	for rows.Next() {
+		var r DataSubjectRequest
+		var completedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.CustomerID, &r.Type, &r.Status, &r.RequestedBy, &r.RequestedAt, &r.Deadline, &completedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler solicitação"})
+			return
+		}
+		if completedAt.Valid {
+			r.CompletedAt = &completedAt.Time
+		}
+		r.Overdue = r.Status == dsrStatusPending && time.Now().After(r.Deadline)
+		requests = append(requests, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// Exporta todos os dados pessoais do cliente em um arquivo ZIP com um JSON por categoria
+func exportCustomerPersonalData(c *gin.Context) {
+	customerID := c.Param("id")
+	actor := c.GetString("user_id")
+	tenantID := tenantFromContext(c)
+
+	if !customerExists(tenantID, customerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	requestID, err := openDataSubjectRequest(tenantID, customerID, dsrTypeAccess, actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar solicitação"})
+		return
+	}
+
+	archive, err := buildPersonalDataArchive(tenantID, customerID)
+	closeDataSubjectRequest(tenantID, requestID, customerID, actor, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao exportar dados pessoais"})
+		return
+	}
+
+	filename := fmt.Sprintf("dados-pessoais-%s-%s.zip", customerID, time.Now().Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+func buildPersonalDataArchive(tenantID, customerID string) ([]byte, error) {
+	sections := []struct {
+		name    string
+		collect func(string) (interface{}, error)
+	}{
+		{"profile", func(id string) (interface{}, error) { return collectCustomerProfile(tenantID, id) }},
+		{"sales", collectCustomerSales},
+		{"interactions", collectCustomerInteractions},
+		{"sentiment_scores", collectCustomerSentimentScores},
+		{"recommendations", collectCustomerRecommendations},
+		{"chatbot_transcripts", collectChatbotTranscripts},
+		{"consents", collectCustomerConsents},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	files := make([]string, 0, len(sections))
+	for _, section := range sections {
+		data, err := section.collect(customerID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", section.name, err)
+		}
+		name := section.name + ".json"
+		if err := writeZipJSON(zw, name, data); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+
+	manifest := gin.H{
+		"customer_id":  customerID,
+		"generated_at": time.Now().UTC(),
+		"format":       "application/json",
+		"files":        files,
+	}
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, data interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+func customerExists(tenantID, customerID string) bool {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM customers WHERE id::text = $1 AND tenant_id = $2)", customerID, tenantID).Scan(&exists)
+	return err == nil && exists
+}
+
+func collectCustomerProfile(tenantID, customerID string) (interface{}, error) {
+	customer, err := fetchCustomerFromDB(tenantID, customerID)
+	if err != nil {
+		return nil, err
+	}
+	var anonymizedAt sql.NullTime
+	if err := db.QueryRow("SELECT anonymized_at FROM customers WHERE id = $1", customerID).Scan(&anonymizedAt); err != nil {
+		return nil, err
+	}
+
+	customFields, err := queryRowsAsMaps(
+		"SELECT name, value, value_enc FROM customer_custom_fields WHERE customer_id = $1 ORDER BY name",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	decryptExportedColumn(customFields, "value", "value_enc")
+
+	profile := gin.H{
+		"id":            customerID,
+		"name":          customer.Name,
+		"email":         customer.Email,
+		"phone":         customer.Phone,
+		"tax_id":        customer.TaxID,
+		"custom_fields": customFields,
+		"created_at":    customer.CreatedAt,
+	}
+	if anonymizedAt.Valid {
+		profile["anonymized_at"] = anonymizedAt.Time
+	}
+	return profile, nil
+}
+
+func collectCustomerSales(customerID string) (interface{}, error) {
+	return queryRowsAsMaps(
+		"SELECT id, product_name, amount, date FROM sales WHERE customer_id = $1 ORDER BY date",
+		customerID,
+	)
+}
+
+func collectCustomerInteractions(customerID string) (interface{}, error) {
+	interactions, err := queryRowsAsMaps(
+		"SELECT id, type, content, content_enc, created_at FROM interactions WHERE customer_id = $1 ORDER BY created_at",
+		customerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	decryptExportedColumn(interactions, "content", "content_enc")
+	return interactions, nil
+}
+
+// Substitui a coluna cifrada pelo valor decifrado nas linhas exportadas
+func decryptExportedColumn(rows []gin.H, plainColumn, encryptedColumn string) {
+	for _, row := range rows {
+		var plain, encrypted sql.NullString
+		if v, ok := row[plainColumn].(string); ok {
+			plain = sql.NullString{String: v, Valid: true}
+		}
+		if v, ok := row[encryptedColumn].(string); ok {
+			encrypted = sql.NullString{String: v, Valid: true}
+		}
+		row[plainColumn] = readProtectedField(encrypted, plain)
+		delete(row, encryptedColumn)
+	}
+}
+
+func collectCustomerSentimentScores(customerID string) (interface{}, error) {
+	return queryRowsAsMaps(
+		"SELECT id AS interaction_id, sentiment, created_at FROM interactions WHERE customer_id = $1 AND sentiment IS NOT NULL ORDER BY created_at",
+		customerID,
+	)
+}
+
+func collectCustomerRecommendations(customerID string) (interface{}, error) {
+	return gin.H{
+		"ratings":         recommendationEngine.UserRatings(customerID),
+		"recommendations": recommendationEngine.GetRecommendations(customerID, 10),
+	}, nil
+}
+
+func collectChatbotTranscripts(customerID string) (interface{}, error) {
+	return queryRowsAsMaps(
+		"SELECT id, role, text, intent, created_at FROM chatbot_messages WHERE customer_id = $1 ORDER BY created_at",
+		customerID,
+	)
+}
+
+func collectCustomerConsents(customerID string) (interface{}, error) {
+	return queryRowsAsMaps(
+		"SELECT purpose, legal_basis, source, granted_at, withdrawn_at, superseded_at FROM customer_consents WHERE customer_id = $1 ORDER BY granted_at",
+		customerID,
+	)
+}
+
+// Converte o resultado de uma consulta em uma lista de mapas coluna -> valor
+func queryRowsAsMaps(query string, args ...interface{}) ([]gin.H, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []gin.H{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := gin.H{}
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// Anonimiza os dados pessoais do cliente em todas as tabelas, preservando
+// os valores de vendas para os relatórios agregados
+func eraseCustomerPersonalData(c *gin.Context) {
+	customerID := c.Param("id")
+	actor := c.GetString("user_id")
+	tenantID := tenantFromContext(c)
+
+	if !customerExists(tenantID, customerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+
+	// Dados sob retenção legal não podem ser eliminados (LGPD, art. 16, I)
+	if customerUnderLegalHold(tenantID, customerID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cliente sob retenção legal"})
+		return
+	}
+
+	requestID, err := openDataSubjectRequest(tenantID, customerID, dsrTypeErasure, actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar solicitação"})
+		return
+	}
+
+	affected, err := anonymizeCustomer(customerID)
+	closeDataSubjectRequest(tenantID, requestID, customerID, actor, err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao anonimizar dados do cliente"})
+		return
+	}
+
+	recommendationEngine.RemoveUser(customerID)
+	invalidateCustomerCaches(customerID)
+	for _, purpose := range consentPurposes {
+		invalidateConsentCache(customerID, purpose)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Dados pessoais anonimizados com sucesso",
+		"request_id": requestID,
+		"affected":   affected,
+	})
+}
+
+func anonymizeCustomer(customerID string) (gin.H, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		table string
+		query string
+	}{
+		{"customers", `
+			UPDATE customers
+			SET name = 'Titular anonimizado',
+				email = NULL, email_enc = NULL, email_bidx = NULL,
+				phone = NULL, phone_enc = NULL, phone_bidx = NULL,
+				tax_id = NULL, tax_id_enc = NULL, tax_id_bidx = NULL,
+				anonymized_at = CURRENT_TIMESTAMP
+			WHERE id = $1`},
+		{"interactions", "UPDATE interactions SET content = NULL, content_enc = NULL WHERE customer_id = $1"},
+		{"customer_custom_fields", "DELETE FROM customer_custom_fields WHERE customer_id = $1"},
+		{"chatbot_messages", "UPDATE chatbot_messages SET text = '[removido]' WHERE customer_id = $1"},
+		{"customer_features_online", "DELETE FROM customer_features_online WHERE customer_id = $1"},
+		{"churn_scores", "DELETE FROM churn_scores WHERE customer_id = $1"},
+		{"clv_scores", "DELETE FROM clv_scores WHERE customer_id = $1"},
+		{"feature_snapshot_rows", "DELETE FROM feature_snapshot_rows WHERE customer_id = $1"},
+		{"customer_consents", "UPDATE customer_consents SET withdrawn_at = CURRENT_TIMESTAMP WHERE customer_id = $1 AND withdrawn_at IS NULL AND superseded_at IS NULL"},
+	}
+
+	affected := gin.H{}
+	for _, stmt := range statements {
+		res, err := tx.Exec(stmt.query, customerID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", stmt.table, err)
+		}
+		n, _ := res.RowsAffected()
+		affected[stmt.table] = n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return affected, nil
+}
+
+func invalidateCustomerCaches(customerID string) {
+	customer360CacheMutex.Lock()
+	delete(customer360Cache, customerID)
+	delete(customer360CacheExpiration, customerID)
+	customer360CacheMutex.Unlock()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	realtimeNotifyChannel = "crmind_realtime"
+	// O NOTIFY aceita até 8000 bytes; acima disso o corpo vai para a tabela
+	// realtime_payloads e a notificação leva apenas a referência
+	notifyPayloadLimit    = 7900
+	realtimePayloadTTL    = time.Hour
+	backplaneCatchUpLimit = 1000
+	backplanePingInterval = 90 * time.Second
+	recentEventIDs        = 4096
+)
+
+// Meio de distribuição dos eventos entre as réplicas da API. Publish numera
+// o evento, grava-o no armazenamento durável, se houver, e só então o
+// entrega a todos os nós, inclusive o atual, pela função registrada em Listen.
+type HubBackplane interface {
+	Publish(ctx context.Context, e RealtimeEvent) (int64, error)
+	Listen(ctx context.Context, deliver func(RealtimeEvent)) error
+}
+
+// Backplane de um único processo, usado quando há uma só réplica
+type memoryBackplane struct {
+	store RealtimeEventStore
+
+	mutex   sync.Mutex
+	lastID  int64
+	deliver func(RealtimeEvent)
+}
+
+func newMemoryBackplane(lastID int64, store RealtimeEventStore) *memoryBackplane {
+	return &memoryBackplane{lastID: lastID, store: store}
+}
+
+func (b *memoryBackplane) Publish(ctx context.Context, e RealtimeEvent) (int64, error) {
+	b.mutex.Lock()
+	b.lastID++
+	e.ID = b.lastID
+	deliver := b.deliver
+	b.mutex.Unlock()
+
+	if b.store != nil {
+		if err := b.store.Append(ctx, e); err != nil {
+			return 0, err
+		}
+	}
+	if deliver != nil {
+		deliver(e)
+	}
+	return e.ID, nil
+}
+
+func (b *memoryBackplane) Listen(ctx context.Context, deliver func(RealtimeEvent)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.deliver = deliver
+	return nil
+}
+
+// Formato do evento no NOTIFY. Com Ref, o evento completo está em
+// realtime_payloads sob o mesmo ID.
+type backplaneEnvelope struct {
+	ID       int64           `json:"id"`
+	TenantID string          `json:"tenant_id,omitempty"`
+	Topic    string          `json:"topic,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	At       time.Time       `json:"at"`
+	Ref      bool            `json:"ref,omitempty"`
+}
+
+// Retorna o conteúdo do NOTIFY e, para eventos grandes demais, o corpo a
+// gravar em realtime_payloads
+func encodeNotification(e RealtimeEvent) (notify, body []byte, err error) {
+	envelope, err := json.Marshal(backplaneEnvelope{ID: e.ID, TenantID: e.TenantID, Topic: e.Topic, Type: e.Type, Data: e.Data, At: e.At})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(envelope) <= notifyPayloadLimit {
+		return envelope, nil, nil
+	}
+	notify, err = json.Marshal(backplaneEnvelope{ID: e.ID, Ref: true})
+	return notify, envelope, err
+}
+
+func decodeEnvelope(data []byte) (RealtimeEvent, bool, error) {
+	var envelope backplaneEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return RealtimeEvent{}, false, err
+	}
+	e := RealtimeEvent{ID: envelope.ID, TenantID: envelope.TenantID, Topic: envelope.Topic, Type: envelope.Type, Data: envelope.Data, At: envelope.At}
+	return e, envelope.Ref, nil
+}
+
+// Backplane sobre LISTEN/NOTIFY do Postgres. Os IDs vêm da sequência
+// realtime_event_seq, compartilhada por todas as réplicas. Após uma queda
+// da conexão LISTEN, os eventos perdidos são recuperados do armazenamento
+// durável, se configurado; a deduplicação do hub descarta os repetidos.
+type postgresBackplane struct {
+	connStr string
+	store   RealtimeEventStore
+
+	mutex  sync.Mutex
+	lastID int64 // maior ID recebido, ponto de partida da recuperação
+}
+
+// O evento, seu corpo e a notificação são gravados na mesma transação, e
+// o NOTIFY só é entregue no commit, com tudo já visível. Com armazenamento,
+// as publicações são serializadas pelo advisory lock: os IDs ficam
+// visíveis em ordem crescente e a recuperação pode partir do último ID
+// recebido.
+func (b *postgresBackplane) Publish(ctx context.Context, e RealtimeEvent) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if b.store != nil {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", jobLockKey(realtimeNotifyChannel)); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT nextval('realtime_event_seq')").Scan(&e.ID); err != nil {
+		return 0, err
+	}
+	notify, body, err := encodeNotification(e)
+	if err != nil {
+		return 0, err
+	}
+	if b.store != nil {
+		if err := insertRealtimeEvent(ctx, tx, e); err != nil {
+			return 0, err
+		}
+	}
+	if body != nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO realtime_payloads (id, body, created_at) VALUES ($1, $2, $3)", e.ID, string(body), time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", realtimeNotifyChannel, string(notify)); err != nil {
+		return 0, err
+	}
+	return e.ID, tx.Commit()
+}
+
+func (b *postgresBackplane) Listen(ctx context.Context, deliver func(RealtimeEvent)) error {
+	err := db.QueryRowContext(ctx, "SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM realtime_event_seq").Scan(&b.lastID)
+	if err != nil {
+		return err
+	}
+	listener := pq.NewListener(b.connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Errorf("Backplane em tempo real: %v", err)
+		}
+	})
+	if err := listener.Listen(realtimeNotifyChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		ping := time.NewTicker(backplanePingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// nil indica reconexão: notificações podem ter sido perdidas
+				if n == nil {
+					b.catchUp(ctx, deliver)
+					continue
+				}
+				e, err := b.receive(ctx, n.Extra)
+				if err != nil {
+					logger.Errorf("Backplane em tempo real: notificação inválida: %v", err)
+					continue
+				}
+				b.deliver(e, deliver)
+			case <-ping.C:
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *postgresBackplane) receive(ctx context.Context, payload string) (RealtimeEvent, error) {
+	e, ref, err := decodeEnvelope([]byte(payload))
+	if err != nil || !ref {
+		return e, err
+	}
+	var body string
+	err = db.QueryRowContext(ctx, "SELECT body FROM realtime_payloads WHERE id = $1", e.ID).Scan(&body)
+	if err == sql.ErrNoRows {
+		return e, fmt.Errorf("corpo do evento %d expirado", e.ID)
+	}
+	if err != nil {
+		return e, err
+	}
+	e, _, err = decodeEnvelope([]byte(body))
+	return e, err
+}
+
+func (b *postgresBackplane) deliver(e RealtimeEvent, deliver func(RealtimeEvent)) {
+	b.mutex.Lock()
+	if e.ID > b.lastID {
+		b.lastID = e.ID
+	}
+	b.mutex.Unlock()
+	deliver(e)
+}
+
+func (b *postgresBackplane) catchUp(ctx context.Context, deliver func(RealtimeEvent)) {
+	if b.store == nil {
+		logger.Errorf("Backplane em tempo real reconectado sem armazenamento durável; eventos do intervalo podem ter sido perdidos")
+		return
+	}
+	b.mutex.Lock()
+	lastID := b.lastID
+	b.mutex.Unlock()
+
+	// Página a página, até uma página incompleta
+	for from := lastID; ; {
+		events, err := b.store.After(ctx, from, backplaneCatchUpLimit)
+		if err != nil {
+			logger.Errorf("Backplane em tempo real: falha ao recuperar eventos após %d: %v", from, err)
+			return
+		}
+		for _, e := range events {
+			b.deliver(e, deliver)
+		}
+		if len(events) < backplaneCatchUpLimit {
+			return
+		}
+		from = events[len(events)-1].ID
+	}
+}
+
+// IDs entregues recentemente, para descartar eventos repetidos pelo
+// backplane, como os recuperados após uma reconexão
+type recentIDs struct {
+	seen  map[int64]bool
+	order []int64
+	next  int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{seen: make(map[int64]bool, size), order: make([]int64, 0, size)}
+}
+
+// Registra o ID e indica se ele já havia sido visto
+func (r *recentIDs) add(id int64) bool {
+	if r.seen[id] {
+		return true
+	}
+	if len(r.order) < cap(r.order) {
+		r.order = append(r.order, id)
+	} else {
+		delete(r.seen, r.order[r.next])
+		r.order[r.next] = id
+		r.next = (r.next + 1) % len(r.order)
+	}
+	r.seen[id] = true
+	return false
+}
+
+// Configura o armazenamento durável (REALTIME_EVENT_STORE=postgres) e o
+// backplane (REALTIME_BACKPLANE=postgres para várias réplicas; por padrão,
+// em memória)
+func initRealtime(ctx context.Context) {
+	var store RealtimeEventStore
+	var lastID int64
+	if os.Getenv("REALTIME_EVENT_STORE") == "postgres" {
+		s := postgresEventStore{}
+		var err error
+		if lastID, err = s.LastID(ctx); err != nil {
+			log.Fatal("Falha ao ler o último evento em tempo real:", err)
+		}
+		store = s
+		realtimeHub.useStore(store, lastID)
+	}
+
+	if os.Getenv("REALTIME_BACKPLANE") != "postgres" {
+		if err := realtimeHub.useBackplane(ctx, newMemoryBackplane(lastID, store)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// A sequência nunca recua abaixo dos IDs já gravados
+	if lastID > 0 {
+		_, err := db.ExecContext(ctx, "SELECT setval('realtime_event_seq', GREATEST($1, (SELECT last_value FROM realtime_event_seq)))", lastID)
+		if err != nil {
+			log.Fatal("Falha ao ajustar a sequência de eventos em tempo real:", err)
+		}
+	}
+	backplane := &postgresBackplane{connStr: dbConnStr, store: store}
+	if err := realtimeHub.useBackplane(ctx, backplane); err != nil {
+		log.Fatal("Falha ao iniciar o backplane em tempo real:", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Backplane compartilhado entre hubs, simulando réplicas da API
+type testBus struct {
+	mutex     sync.Mutex
+	lastID    int64
+	listeners []func(RealtimeEvent)
+}
+
+func (b *testBus) Publish(ctx context.Context, e RealtimeEvent) (int64, error) {
+	b.mutex.Lock()
+	b.lastID++
+	e.ID = b.lastID
+	listeners := append([]func(RealtimeEvent){}, b.listeners...)
+	b.mutex.Unlock()
+
+	for _, deliver := range listeners {
+		deliver(e)
+	}
+	return e.ID, nil
+}
+
+func (b *testBus) Listen(ctx context.Context, deliver func(RealtimeEvent)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.listeners = append(b.listeners, deliver)
+	return nil
+}
+
+func TestRealtimeHubsShareBackplane(t *testing.T) {
+	bus := &testBus{}
+	nodeA, nodeB := NewRealtimeHub(), NewRealtimeHub()
+	assert.NoError(t, nodeA.useBackplane(context.Background(), bus))
+	assert.NoError(t, nodeB.useBackplane(context.Background(), bus))
+
+	c := newTestClient(nodeB, "acme", "u1")
+	assert.NoError(t, nodeB.subscribe(c, []string{dashboardTopic}, 0))
+	receive(t, c)
+
+	nodeA.Publish("acme", dashboardTopic, "dashboard_update", 1)
+	assert.Equal(t, 1.0, receive(t, c)["id"])
+
+	// Evento repetido pelo backplane, como após uma reconexão, é descartado
+	nodeB.dispatch(RealtimeEvent{ID: 1, TenantID: "acme", Topic: dashboardTopic, Type: "dashboard_update", Data: []byte("1")})
+	assert.Len(t, c.send, 0)
+
+	// A retomada funciona em qualquer nó: o outro também guardou o evento
+	c = newTestClient(nodeA, "acme", "u1")
+	nodeA.Publish("acme", dashboardTopic, "dashboard_update", 2)
+	assert.NoError(t, nodeA.subscribe(c, []string{dashboardTopic}, 1))
+	receive(t, c)
+	assert.Equal(t, 2.0, receive(t, c)["id"])
+}
+
+func TestEncodeNotification(t *testing.T) {
+	e := RealtimeEvent{ID: 9, TenantID: "acme", Topic: dashboardTopic, Type: "dashboard_update", Data: []byte(`{"sales":1}`)}
+	notify, body, err := encodeNotification(e)
+	assert.NoError(t, err)
+	assert.Nil(t, body)
+	decoded, ref, err := decodeEnvelope(notify)
+	assert.NoError(t, err)
+	assert.False(t, ref)
+	assert.Equal(t, e.Data, decoded.Data)
+	assert.Equal(t, "acme", decoded.TenantID)
+
+	// Acima do limite do NOTIFY, vai apenas a referência
+	e.Data = []byte(`"` + strings.Repeat("x", notifyPayloadLimit) + `"`)
+	notify, body, err = encodeNotification(e)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":9,"at":"0001-01-01T00:00:00Z","ref":true}`, string(notify))
+	decoded, _, err = decodeEnvelope(body)
+	assert.NoError(t, err)
+	assert.Equal(t, e.Data, decoded.Data)
+}
+
+func TestRecentIDs(t *testing.T) {
+	r := newRecentIDs(3)
+	assert.False(t, r.add(1))
+	assert.True(t, r.add(1))
+	r.add(2)
+	r.add(3)
+	r.add(4)
+	assert.False(t, r.add(1))
+	assert.True(t, r.add(4))
+}
+
+func TestBackplaneCatchUpReadsAllPages(t *testing.T) {
+	store := &memoryEventStore{}
+	for id := int64(1); id <= backplaneCatchUpLimit+7; id++ {
+		store.events = append(store.events, RealtimeEvent{ID: id})
+	}
+	b := &postgresBackplane{store: store, lastID: 5}
+
+	var delivered []int64
+	b.catchUp(context.Background(), func(e RealtimeEvent) { delivered = append(delivered, e.ID) })
+	assert.Len(t, delivered, backplaneCatchUpLimit+2)
+	assert.Equal(t, int64(6), delivered[0])
+	assert.Equal(t, int64(backplaneCatchUpLimit+7), b.lastID)
+}
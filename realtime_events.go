@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+const (
+	realtimeRingSize       = 256
+	realtimeMaxRings       = 10000
+	realtimeRingIdleTTL    = time.Hour
+	realtimeReplayLimit    = 500
+	realtimeEventRetention = 7 * 24 * time.Hour
+)
+
+// Evento publicado pelo hub. O ID é crescente em todo o hub e é o que o
+// cliente informa em last_event_id para retomar o fluxo.
+type RealtimeEvent struct {
+	ID       int64           `json:"id,omitempty"`
+	Topic    string          `json:"topic,omitempty"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+	TenantID string          `json:"-"`
+	At       time.Time       `json:"-"`
+}
+
+func (e RealtimeEvent) encode() []byte {
+	message, _ := json.Marshal(e)
+	return message
+}
+
+// Últimos eventos de um tópico de um tenant, em buffer circular
+type eventRing struct {
+	events  []RealtimeEvent
+	next    int
+	evicted int64 // maior ID já descartado do buffer
+}
+
+func (r *eventRing) add(e RealtimeEvent) {
+	if len(r.events) < realtimeRingSize {
+		r.events = append(r.events, e)
+		return
+	}
+	// Com vários publicadores os IDs podem chegar fora de ordem
+	if id := r.events[r.next].ID; id > r.evicted {
+		r.evicted = id
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % realtimeRingSize
+}
+
+// Eventos com ID maior que afterID, do mais antigo ao mais recente
+func (r *eventRing) since(afterID int64) []RealtimeEvent {
+	var events []RealtimeEvent
+	for i := range r.events {
+		e := r.events[(r.next+i)%len(r.events)]
+		if e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (r *eventRing) lastAt() time.Time {
+	if len(r.events) == 0 {
+		return time.Time{}
+	}
+	return r.events[(r.next+len(r.events)-1)%len(r.events)].At
+}
+
+// Armazenamento durável opcional, para retomar lacunas maiores que o
+// buffer em memória ou que atravessam um reinício do servidor
+type RealtimeEventStore interface {
+	Append(ctx context.Context, e RealtimeEvent) error
+	// Até limit eventos mais recentes com ID maior que afterID, em ordem crescente
+	Since(ctx context.Context, tenantID, topic string, afterID int64, limit int) ([]RealtimeEvent, error)
+	// Até limit eventos de todos os tópicos com ID maior que afterID, em ordem crescente
+	After(ctx context.Context, afterID int64, limit int) ([]RealtimeEvent, error)
+	LastID(ctx context.Context) (int64, error)
+}
+
+type postgresEventStore struct{}
+
+func (postgresEventStore) Append(ctx context.Context, e RealtimeEvent) error {
+	return insertRealtimeEvent(ctx, db, e)
+}
+
+// Grava o evento pela conexão ou pela transação informada
+func insertRealtimeEvent(ctx context.Context, exec interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, e RealtimeEvent) error {
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO realtime_events (id, tenant_id, topic, type, data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		e.ID, e.TenantID, e.Topic, e.Type, string(e.Data), e.At.UTC(),
+	)
+	return err
+}
+
+func (postgresEventStore) Since(ctx context.Context, tenantID, topic string, afterID int64, limit int) ([]RealtimeEvent, error) {
+	return queryRealtimeEvents(ctx, `
+		SELECT id, tenant_id, topic, type, data, created_at FROM (
+			SELECT * FROM realtime_events
+			WHERE tenant_id = $1 AND topic = $2 AND id > $3
+			ORDER BY id DESC LIMIT $4
+		) recent ORDER BY id`,
+		tenantID, topic, afterID, limit,
+	)
+}
+
+func (postgresEventStore) After(ctx context.Context, afterID int64, limit int) ([]RealtimeEvent, error) {
+	return queryRealtimeEvents(ctx, `
+		SELECT id, tenant_id, topic, type, data, created_at FROM realtime_events
+		WHERE id > $1 ORDER BY id LIMIT $2`,
+		afterID, limit,
+	)
+}
+
+func queryRealtimeEvents(ctx context.Context, query string, args ...interface{}) ([]RealtimeEvent, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []RealtimeEvent
+	for rows.Next() {
+		var e RealtimeEvent
+		var data string
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.Topic, &e.Type, &data, &e.At); err != nil {
+			return nil, err
+		}
+		e.Data = json.RawMessage(data)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (postgresEventStore) LastID(ctx context.Context) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM realtime_events").Scan(&id)
+	return id, err
+}
+
+func createRealtimeEventTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS realtime_events (
+			id BIGINT PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL,
+			topic VARCHAR(100) NOT NULL,
+			type VARCHAR(50) NOT NULL,
+			data JSONB NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_realtime_events_topic ON realtime_events (tenant_id, topic, id);
+		CREATE INDEX IF NOT EXISTS idx_realtime_events_created ON realtime_events (created_at);
+
+		-- Numeração compartilhada pelas réplicas no backplane Postgres
+		CREATE SEQUENCE IF NOT EXISTS realtime_event_seq;
+
+		-- Eventos grandes demais para o NOTIFY
+		CREATE TABLE IF NOT EXISTS realtime_payloads (
+			id BIGINT PRIMARY KEY,
+			body TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Expurgo dos eventos mais antigos que a retenção e dos corpos de eventos
+// grandes já entregues pelo backplane
+func purgeRealtimeEvents(ctx context.Context) error {
+	now := time.Now().UTC()
+	if _, err := db.ExecContext(ctx, "DELETE FROM realtime_events WHERE created_at < $1", now.Add(-realtimeEventRetention)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "DELETE FROM realtime_payloads WHERE created_at < $1", now.Add(-realtimePayloadTTL))
+	return err
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Eventos de domínio que alimentam as métricas do dashboard em tempo real
+const (
+	eventSaleCreated     = "sale_created"
+	eventCustomerCreated = "customer_created"
+	eventTicketOpened    = "ticket_opened"
+	eventUserLogin       = "user_login"
+)
+
+const (
+	metricsPublishInterval = 5 * time.Second
+	metricsEventBuffer     = 4096
+	activeUserWindow       = 15 * time.Minute
+	// Sem eventos por mais tempo que a maior janela, o estado do tenant é descartado
+	idleTenantTTL = 2 * time.Hour
+)
+
+type DomainEvent struct {
+	Type     string
+	TenantID string
+	UserID   string
+	Amount   float64
+	At       time.Time
+}
+
+// Contador em janela deslizante, dividido em baldes de duração fixa. Os
+// baldes que saem da janela são zerados conforme o tempo avança.
+type slidingWindow struct {
+	bucket  time.Duration
+	buckets []float64
+	last    int64 // índice absoluto do balde mais recente
+}
+
+func newSlidingWindow(size, bucket time.Duration) *slidingWindow {
+	return &slidingWindow{bucket: bucket, buckets: make([]float64, int(size/bucket))}
+}
+
+func (w *slidingWindow) advance(now time.Time) {
+	idx := now.UnixNano() / int64(w.bucket)
+	if idx <= w.last {
+		return
+	}
+	n := int64(len(w.buckets))
+	steps := idx - w.last
+	if steps > n {
+		steps = n
+	}
+	for i := int64(1); i <= steps; i++ {
+		w.buckets[(w.last+i)%n] = 0
+	}
+	w.last = idx
+}
+
+func (w *slidingWindow) Add(at time.Time, value float64) {
+	w.advance(at)
+	idx := at.UnixNano() / int64(w.bucket)
+	n := int64(len(w.buckets))
+	if idx <= w.last-n {
+		return // evento atrasado, já fora da janela
+	}
+	w.buckets[idx%n] += value
+}
+
+func (w *slidingWindow) Sum(now time.Time) float64 {
+	w.advance(now)
+	total := 0.0
+	for _, v := range w.buckets {
+		total += v
+	}
+	return total
+}
+
+type tenantMetrics struct {
+	sales     *slidingWindow // vendas no último minuto
+	revenue   *slidingWindow // receita na última hora
+	customers *slidingWindow // novos clientes na última hora
+	tickets   *slidingWindow // chamados abertos na última hora
+	users     map[string]time.Time
+	lastEvent time.Time
+	published map[string]float64
+}
+
+func newTenantMetrics() *tenantMetrics {
+	return &tenantMetrics{
+		sales:     newSlidingWindow(time.Minute, time.Second),
+		revenue:   newSlidingWindow(time.Hour, time.Minute),
+		customers: newSlidingWindow(time.Hour, time.Minute),
+		tickets:   newSlidingWindow(time.Hour, time.Minute),
+		users:     make(map[string]time.Time),
+	}
+}
+
+func (m *tenantMetrics) apply(event DomainEvent) {
+	switch event.Type {
+	case eventSaleCreated:
+		m.sales.Add(event.At, 1)
+		m.revenue.Add(event.At, event.Amount)
+	case eventCustomerCreated:
+		m.customers.Add(event.At, 1)
+	case eventTicketOpened:
+		m.tickets.Add(event.At, 1)
+	}
+	// Qualquer evento com autor conta o usuário como ativo
+	if event.UserID != "" && event.At.After(m.users[event.UserID]) {
+		m.users[event.UserID] = event.At
+	}
+	if event.At.After(m.lastEvent) {
+		m.lastEvent = event.At
+	}
+}
+
+func (m *tenantMetrics) snapshot(now time.Time) map[string]float64 {
+	active := 0
+	for userID, seen := range m.users {
+		if now.Sub(seen) > activeUserWindow {
+			delete(m.users, userID)
+			continue
+		}
+		active++
+	}
+	return map[string]float64{
+		"sales_per_minute":        m.sales.Sum(now),
+		"revenue_per_hour":        math.Round(m.revenue.Sum(now)*100) / 100,
+		"new_customers_per_hour":  m.customers.Sum(now),
+		"tickets_opened_per_hour": m.tickets.Sum(now),
+		"active_users":            float64(active),
+	}
+}
+
+// Agregador único das métricas em tempo real. Os eventos chegam por um
+// canal com buffer e os deltas são publicados em intervalo fixo, por tenant.
+type MetricsAggregator struct {
+	events  chan DomainEvent
+	mutex   sync.Mutex
+	tenants map[string]*tenantMetrics
+	publish func(tenantID string, delta gin.H)
+	dropped int64
+}
+
+func NewMetricsAggregator(publish func(tenantID string, delta gin.H)) *MetricsAggregator {
+	return &MetricsAggregator{
+		events:  make(chan DomainEvent, metricsEventBuffer),
+		tenants: make(map[string]*tenantMetrics),
+		publish: publish,
+	}
+}
+
+var metricsAggregator = NewMetricsAggregator(func(tenantID string, delta gin.H) {
+	realtimeHub.Publish(tenantID, dashboardTopic, "dashboard_update", delta)
+})
+
+// Registra um evento sem bloquear quem o emite; com o buffer cheio o
+// evento é descartado e contabilizado
+func (a *MetricsAggregator) Record(event DomainEvent) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	if event.TenantID == "" {
+		event.TenantID = defaultTenantID
+	}
+	select {
+	case a.events <- event:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+// Processa os eventos e publica os deltas até o contexto ser cancelado
+func (a *MetricsAggregator) Start(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(metricsPublishInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-a.events:
+				a.apply(event)
+			case now := <-ticker.C:
+				a.publishDeltas(now)
+			}
+		}
+	}()
+	return &wg
+}
+
+func (a *MetricsAggregator) apply(event DomainEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	m, ok := a.tenants[event.TenantID]
+	if !ok {
+		m = newTenantMetrics()
+		a.tenants[event.TenantID] = m
+	}
+	m.apply(event)
+}
+
+// Publica, para cada tenant, apenas as métricas que mudaram desde a última
+// publicação. A primeira publicação de um tenant traz todas.
+func (a *MetricsAggregator) publishDeltas(now time.Time) {
+	deltas := make(map[string]gin.H)
+
+	a.mutex.Lock()
+	for tenantID, m := range a.tenants {
+		current := m.snapshot(now)
+		delta := gin.H{}
+		for name, value := range current {
+			if previous, ok := m.published[name]; !ok || previous != value {
+				delta[name] = value
+			}
+		}
+		m.published = current
+		if len(delta) > 0 {
+			delta["timestamp"] = now.Unix()
+			deltas[tenantID] = delta
+		}
+		if now.Sub(m.lastEvent) > idleTenantTTL {
+			delete(a.tenants, tenantID)
+		}
+	}
+	a.mutex.Unlock()
+
+	if dropped := atomic.SwapInt64(&a.dropped, 0); dropped > 0 {
+		logger.Errorf("Métricas em tempo real: %d eventos descartados com o buffer cheio", dropped)
+	}
+	for tenantID, delta := range deltas {
+		a.publish(tenantID, delta)
+	}
+}
+
+// Estado atual completo do tenant, para clientes que acabaram de conectar
+func (a *MetricsAggregator) Snapshot(tenantID string, now time.Time) map[string]float64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	m, ok := a.tenants[tenantID]
+	if !ok {
+		return newTenantMetrics().snapshot(now)
+	}
+	return m.snapshot(now)
+}
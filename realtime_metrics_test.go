@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	w := newSlidingWindow(time.Minute, time.Second)
+	w.Add(start, 1)
+	w.Add(start.Add(30*time.Second), 2)
+	assert.Equal(t, 3.0, w.Sum(start.Add(45*time.Second)))
+	assert.Equal(t, 2.0, w.Sum(start.Add(time.Minute)))
+	assert.Equal(t, 0.0, w.Sum(start.Add(2*time.Minute)))
+
+	// Evento atrasado além da janela é ignorado
+	w.Add(start, 5)
+	assert.Equal(t, 0.0, w.Sum(start.Add(2*time.Minute)))
+}
+
+func TestMetricsAggregatorPublishesDeltas(t *testing.T) {
+	published := map[string][]gin.H{}
+	a := NewMetricsAggregator(func(tenantID string, delta gin.H) {
+		published[tenantID] = append(published[tenantID], delta)
+	})
+
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	a.apply(DomainEvent{Type: eventSaleCreated, TenantID: "acme", UserID: "u1", Amount: 150.5, At: now})
+	a.apply(DomainEvent{Type: eventSaleCreated, TenantID: "acme", UserID: "u2", Amount: 49.5, At: now})
+	a.apply(DomainEvent{Type: eventTicketOpened, TenantID: "acme", At: now})
+	a.publishDeltas(now.Add(time.Second))
+
+	first := published["acme"][0]
+	assert.Equal(t, 2.0, first["sales_per_minute"])
+	assert.Equal(t, 200.0, first["revenue_per_hour"])
+	assert.Equal(t, 1.0, first["tickets_opened_per_hour"])
+	assert.Equal(t, 2.0, first["active_users"])
+	assert.Equal(t, 0.0, first["new_customers_per_hour"])
+
+	// Sem mudanças, nada é publicado
+	a.publishDeltas(now.Add(2 * time.Second))
+	assert.Len(t, published["acme"], 1)
+
+	// Após um minuto só a taxa de vendas muda
+	a.publishDeltas(now.Add(61 * time.Second))
+	assert.Len(t, published["acme"], 2)
+	second := published["acme"][1]
+	assert.Equal(t, 0.0, second["sales_per_minute"])
+	assert.NotContains(t, second, "revenue_per_hour")
+
+	assert.Equal(t, 200.0, a.Snapshot("acme", now.Add(time.Minute))["revenue_per_hour"])
+	assert.Equal(t, 0.0, a.Snapshot("outro", now)["sales_per_minute"])
+}
+
+func TestMetricsAggregatorStops(t *testing.T) {
+	a := NewMetricsAggregator(func(string, gin.H) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := a.Start(ctx)
+	a.Record(DomainEvent{Type: eventUserLogin, UserID: "u1"})
+	cancel()
+	done.Wait()
+}
@@ -1,118 +1,598 @@
 package main
 
 import (
-	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
+	"CRMind/backend/auth"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
+const (
+	maxClientTopics   = 100
+	maxClientMessage  = 4096
+	clientSendBuffer  = 1024
+	dashboardTopic    = "dashboard"
+	websocketProtocol = "bearer"
+
+	// Heartbeat: o servidor envia ping a cada pingPeriod e encerra a conexão
+	// sem pong ou mensagem do cliente dentro de pongWait
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Origens de navegador aceitas no handshake, separadas por vírgula. Sem a
+// variável, apenas a mesma origem do servidor é aceita.
+var realtimeAllowedOrigins = parseOriginList(os.Getenv("REALTIME_ALLOWED_ORIGINS"))
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Permitir qualquer origem para este exemplo
-	},
+	CheckOrigin:  checkRealtimeOrigin,
+	Subprotocols: []string{websocketProtocol},
+}
+
+func parseOriginList(value string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// Clientes fora do navegador não enviam Origin e dependem apenas do token
+func checkRealtimeOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if realtimeAllowedOrigins[strings.TrimSuffix(strings.ToLower(origin), "/")] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
 }
 
+// Formato do log de acesso padrão do gin, com o parâmetro token da query
+// string (usado pelo EventSource, que não envia cabeçalhos) mascarado
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		redactQueryToken(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+func redactQueryToken(path string) string {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path
+	}
+	query, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		// Sem conseguir interpretar a query, descarta-a por inteiro
+		return path[:i]
+	}
+	if !query.Has("token") {
+		return path
+	}
+	query.Set("token", "REDACTED")
+	return path[:i+1] + query.Encode()
+}
+
+// O token vem no parâmetro token, no cabeçalho Authorization ou, no
+// websocket, para não expô-lo em logs de URL, no subprotocolo:
+// Sec-WebSocket-Protocol: bearer, <token>
+func realtimeToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		return token
+	}
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == websocketProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// Assinante do hub, independente do transporte. conn é nil nas conexões SSE.
 type Client struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn     *websocket.Conn
+	send     chan RealtimeEvent
+	userID   string
+	tenantID string
+	topics   map[string]bool // protegido pelo mutex do hub
+	// Informado na reconexão; vale para as assinaturas sem last_event_id próprio
+	lastEventID int64
 }
 
+// Conexões e assinaturas, indexadas por tópico. O registro é síncrono para
+// que a primeira assinatura do cliente já o encontre no hub.
 type RealtimeHub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mutex      sync.Mutex
+	clients   map[*Client]bool
+	topics    map[string]map[*Client]bool
+	seq       int64                 // maior ID recebido por este nó
+	rings     map[string]*eventRing // por tenant e tópico
+	recent    *recentIDs
+	store     RealtimeEventStore
+	backplane HubBackplane
+	mutex     sync.Mutex
 }
 
 func NewRealtimeHub() *RealtimeHub {
-	return &RealtimeHub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+	h := &RealtimeHub{
+		clients: make(map[*Client]bool),
+		topics:  make(map[string]map[*Client]bool),
+		rings:   make(map[string]*eventRing),
+		recent:  newRecentIDs(recentEventIDs),
 	}
+	h.useBackplane(context.Background(), newMemoryBackplane(0, nil))
+	return h
 }
 
-func (h *RealtimeHub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mutex.Lock()
-			h.clients[client] = true
-			h.mutex.Unlock()
-		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+func (h *RealtimeHub) useBackplane(ctx context.Context, backplane HubBackplane) error {
+	if err := backplane.Listen(ctx, h.dispatch); err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.backplane = backplane
+	return nil
+}
+
+func (h *RealtimeHub) useStore(store RealtimeEventStore, lastID int64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.store = store
+	if lastID > h.seq {
+		h.seq = lastID
+	}
+}
+
+func ringKey(tenantID, topic string) string {
+	return tenantID + "|" + topic
+}
+
+// Buffer do tópico, criado sob demanda. Acima de realtimeMaxRings, os
+// buffers sem eventos recentes são descartados. Exige o mutex.
+func (h *RealtimeHub) ring(tenantID, topic string) *eventRing {
+	key := ringKey(tenantID, topic)
+	if r, ok := h.rings[key]; ok {
+		return r
+	}
+	if len(h.rings) >= realtimeMaxRings {
+		cutoff := time.Now().Add(-realtimeRingIdleTTL)
+		for k, r := range h.rings {
+			if r.lastAt().Before(cutoff) {
+				delete(h.rings, k)
+			}
+		}
+	}
+	r := &eventRing{}
+	h.rings[key] = r
+	return r
+}
+
+func (h *RealtimeHub) register(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.clients[client] = true
+}
+
+func (h *RealtimeHub) unregister(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.removeClient(client)
+}
+
+// Desconecta o cliente e remove suas assinaturas. Exige o mutex.
+func (h *RealtimeHub) removeClient(client *Client) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	for topic := range client.topics {
+		delete(h.topics[topic], client)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	delete(h.clients, client)
+	close(client.send)
+}
+
+// Entrega sem bloquear; o cliente lento demais é desconectado e a entrega
+// retorna false. Exige o mutex.
+func (h *RealtimeHub) deliver(client *Client, event RealtimeEvent) bool {
+	select {
+	case client.send <- event:
+		return true
+	default:
+		h.removeClient(client)
+		return false
+	}
+}
+
+// Resposta do hub a um cliente, sem número nem tópico: não entra no reenvio
+func newReply(eventType string, data interface{}) RealtimeEvent {
+	payload, _ := json.Marshal(data)
+	return RealtimeEvent{Type: eventType, Data: payload}
+}
+
+// Publica o evento pelo backplane, que o numera, grava-o no armazenamento
+// durável e o entrega a todos os nós
+func (h *RealtimeHub) Publish(tenantID, topic, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.Errorf("Falha ao serializar evento %s: %v", eventType, err)
+		return
+	}
+
+	h.mutex.Lock()
+	backplane := h.backplane
+	h.mutex.Unlock()
+
+	event := RealtimeEvent{Topic: topic, Type: eventType, Data: payload, TenantID: tenantID, At: time.Now()}
+	if _, err := backplane.Publish(context.Background(), event); err != nil {
+		logger.Errorf("Falha ao publicar evento %s no backplane: %v", eventType, err)
+	}
+}
+
+// Recebe um evento do backplane, guarda-o para reenvio e o envia aos
+// assinantes do tópico que pertencem ao tenant. A autorização de cada
+// assinante foi verificada na assinatura. IDs já recebidos são ignorados.
+func (h *RealtimeHub) dispatch(event RealtimeEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.recent.add(event.ID) {
+		return
+	}
+	if event.ID > h.seq {
+		h.seq = event.ID
+	}
+	h.ring(event.TenantID, event.Topic).add(event)
+	for client := range h.topics[event.Topic] {
+		if client.tenantID == event.TenantID {
+			h.deliver(client, event)
+		}
+	}
+}
+
+// Resposta direta a um cliente, ignorada se ele já foi desconectado
+func (h *RealtimeHub) reply(client *Client, eventType string, data interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.clients[client] {
+		h.deliver(client, newReply(eventType, data))
+	}
+}
+
+// Eventos do tópico que só o armazenamento durável ainda tem. truncated
+// indica que parte da lacuna não pode ser reenviada: sem armazenamento, ou
+// com um ID de antes de um reinício sem ele.
+func (h *RealtimeHub) storedEvents(tenantID, topic string, lastEventID int64) (stored []RealtimeEvent, truncated bool) {
+	h.mutex.Lock()
+	r := h.rings[ringKey(tenantID, topic)]
+	beyondRing := lastEventID < h.seq && (r == nil || lastEventID < r.evicted)
+	restarted := lastEventID > h.seq
+	store := h.store
+	h.mutex.Unlock()
+
+	switch {
+	case restarted:
+		return nil, true
+	case beyondRing && store != nil:
+		stored, err := store.Since(context.Background(), tenantID, topic, lastEventID, realtimeReplayLimit+1)
+		if err != nil {
+			logger.Errorf("Falha ao ler eventos em tempo real do tópico %s: %v", topic, err)
+			return nil, true
+		}
+		return stored, false
+	case beyondRing && r != nil:
+		return nil, true
+	}
+	return nil, false
+}
+
+// Assina os tópicos e reenvia os eventos posteriores a lastEventID, em
+// ordem de ID. As confirmações e o reenvio saem sob o mutex, antes de
+// qualquer evento novo. Lacunas maiores que o buffer em memória vêm do
+// armazenamento durável; acima de realtimeReplayLimit, apenas os eventos
+// mais recentes são reenviados e a confirmação indica truncated.
+func (h *RealtimeHub) subscribe(client *Client, topics []string, lastEventID int64) error {
+	stored := make(map[string][]RealtimeEvent)
+	truncated := make(map[string]bool)
+	if lastEventID > 0 {
+		for _, topic := range topics {
+			stored[topic], truncated[topic] = h.storedEvents(client.tenantID, topic, lastEventID)
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.clients[client] {
+		return fmt.Errorf("conexão encerrada")
+	}
+	added := 0
+	for _, topic := range topics {
+		if !client.topics[topic] {
+			added++
+		}
+	}
+	if len(client.topics)+added > maxClientTopics {
+		return fmt.Errorf("limite de %d tópicos por conexão", maxClientTopics)
+	}
+
+	var replay []RealtimeEvent
+	for _, topic := range topics {
+		client.topics[topic] = true
+		if h.topics[topic] == nil {
+			h.topics[topic] = make(map[*Client]bool)
+		}
+		h.topics[topic][client] = true
+
+		if lastEventID > 0 {
+			after := lastEventID
+			if events := stored[topic]; len(events) > 0 {
+				after = events[len(events)-1].ID
+				replay = append(replay, events...)
 			}
-			h.mutex.Unlock()
-		case message := <-h.broadcast:
-			h.mutex.Lock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+			if r := h.rings[ringKey(client.tenantID, topic)]; r != nil {
+				replay = append(replay, r.since(after)...)
 			}
-			h.mutex.Unlock()
 		}
 	}
+	sort.Slice(replay, func(i, j int) bool { return replay[i].ID < replay[j].ID })
+	if len(replay) > realtimeReplayLimit {
+		for _, e := range replay[:len(replay)-realtimeReplayLimit] {
+			truncated[e.Topic] = true
+		}
+		replay = replay[len(replay)-realtimeReplayLimit:]
+	}
+
+	replayed := make(map[string]int)
+	for _, e := range replay {
+		replayed[e.Topic]++
+	}
+	for _, topic := range topics {
+		ack := newReply("subscribed", gin.H{"topic": topic, "replayed": replayed[topic], "truncated": truncated[topic]})
+		if !h.deliver(client, ack) {
+			return nil
+		}
+	}
+	for _, e := range replay {
+		if !h.deliver(client, e) {
+			break
+		}
+	}
+	return nil
 }
 
-func (h *RealtimeHub) BroadcastEvent(eventType string, data interface{}) {
-	event := gin.H{
-		"type": eventType,
-		"data": data,
+func (h *RealtimeHub) unsubscribe(client *Client, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	delete(client.topics, topic)
+	delete(h.topics[topic], client)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
 	}
-	jsonEvent, _ := json.Marshal(event)
-	h.broadcast <- jsonEvent
 }
 
 var realtimeHub = NewRealtimeHub()
 
-func setupRealtimeRoutes(r *gin.Engine) {
-	r.GET("/ws", func(c *gin.Context) {
-		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+func userTopic(userID string) string {
+	return "user:" + userID
+}
+
+func customerTopic(customerID string) string {
+	return "customer:" + customerID
+}
+
+var topicIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Separa o tópico em tipo e identificador: dashboard, customer:<id> ou user:<id>
+func parseTopic(topic string) (kind, id string, err error) {
+	kind, id, hasID := strings.Cut(topic, ":")
+	switch kind {
+	case dashboardTopic:
+		if !hasID {
+			return kind, "", nil
+		}
+	case "customer", "user":
+		if topicIDPattern.MatchString(id) {
+			return kind, id, nil
+		}
+	}
+	return "", "", fmt.Errorf("tópico inválido: %q", topic)
+}
+
+// Verifica se o cliente pode receber os eventos do tópico: clientes do
+// próprio tenant e o próprio usuário ou os membros dos times que gerencia
+func authorizeTopic(client *Client, topic string) error {
+	kind, id, err := parseTopic(topic)
+	if err != nil {
+		return err
+	}
+
+	allowed := true
+	switch kind {
+	case "customer":
+		err = db.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM customers WHERE id::text = $1 AND tenant_id = $2)",
+			id, client.tenantID,
+		).Scan(&allowed)
+	case "user":
+		if id != client.userID {
+			allowed, err = managesUser(client.tenantID, client.userID, id)
+		}
+	}
+	if err != nil {
+		logger.Errorf("Falha ao autorizar tópico %s para o usuário %s: %v", topic, client.userID, err)
+		return fmt.Errorf("falha ao autorizar tópico")
+	}
+	if !allowed {
+		return fmt.Errorf("acesso negado ao tópico %q", topic)
+	}
+	return nil
+}
+
+type clientMessage struct {
+	Action      string `json:"action"`
+	Topic       string `json:"topic"`
+	LastEventID *int64 `json:"last_event_id"`
+}
+
+func (h *RealtimeHub) handleClientMessage(client *Client, raw []byte) {
+	var msg clientMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		h.reply(client, "error", gin.H{"error": "mensagem inválida"})
+		return
+	}
+
+	switch msg.Action {
+	case "subscribe":
+		lastEventID := client.lastEventID
+		if msg.LastEventID != nil {
+			lastEventID = *msg.LastEventID
+		}
+		err := authorizeTopic(client, msg.Topic)
+		if err == nil {
+			err = h.subscribe(client, []string{msg.Topic}, lastEventID)
+		}
 		if err != nil {
-			log.Println(err)
-			return
+			h.reply(client, "error", gin.H{"topic": msg.Topic, "error": err.Error()})
 		}
-		client := &Client{conn: conn, send: make(chan []byte, 256)}
-		realtimeHub.register <- client
+	case "unsubscribe":
+		h.unsubscribe(client, msg.Topic)
+		h.reply(client, "unsubscribed", gin.H{"topic": msg.Topic})
+	default:
+		h.reply(client, "error", gin.H{"error": "ação deve ser subscribe ou unsubscribe"})
+	}
+}
 
-		go client.writePump()
-		go client.readPump()
-	})
+func setupRealtimeRoutes(r *gin.Engine) {
+	r.GET("/ws", serveWebsocket)
+	r.GET("/events", streamEvents)
+}
+
+// Valida o token e o last_event_id da conexão e cria o assinante. Em caso
+// de erro a resposta já foi escrita.
+func newRealtimeClient(c *gin.Context, lastEventID string) (*Client, bool) {
+	token := realtimeToken(c.Request)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token de autenticação não fornecido"})
+		return nil, false
+	}
+	claims, err := auth.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido"})
+		return nil, false
+	}
+
+	client := &Client{
+		send:     make(chan RealtimeEvent, clientSendBuffer),
+		userID:   claims.UserID,
+		tenantID: claims.TenantID,
+		topics:   make(map[string]bool),
+	}
+	if client.tenantID == "" {
+		client.tenantID = defaultTenantID
+	}
+	if lastEventID != "" {
+		client.lastEventID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || client.lastEventID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "last_event_id inválido"})
+			return nil, false
+		}
+	}
+	return client, true
+}
+
+func serveWebsocket(c *gin.Context) {
+	client, ok := newRealtimeClient(c, c.Query("last_event_id"))
+	if !ok {
+		return
+	}
+
+	// Origem fora da lista é recusada pelo upgrader com 403
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	client.conn = conn
+	realtimeHub.register(client)
+
+	go client.writePump()
+	go client.readPump()
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		realtimeHub.unregister <- c
+		realtimeHub.unregister(c)
 		c.conn.Close()
 	}()
+	c.conn.SetReadLimit(maxClientMessage)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		realtimeHub.handleClientMessage(c, message)
 	}
 }
 
 func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
+		case event, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
@@ -121,12 +601,15 @@ func (c *Client) writePump() {
 			if err != nil {
 				return
 			}
-			w.Write(message)
+			w.Write(event.encode())
 			if err := w.Close(); err != nil {
 				return
 			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
-
-
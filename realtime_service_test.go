@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTopic(t *testing.T) {
+	kind, id, err := parseTopic("customer:42")
+	assert.NoError(t, err)
+	assert.Equal(t, "customer", kind)
+	assert.Equal(t, "42", id)
+
+	kind, _, err = parseTopic("dashboard")
+	assert.NoError(t, err)
+	assert.Equal(t, dashboardTopic, kind)
+
+	for _, topic := range []string{"", "dashboard:1", "customer:", "user:a b", "tenant:acme", "customer:1:2"} {
+		_, _, err := parseTopic(topic)
+		assert.Error(t, err, topic)
+	}
+}
+
+func TestWebsocketHandshakeChecks(t *testing.T) {
+	realtimeAllowedOrigins = parseOriginList("https://app.crmind.com.br, https://admin.crmind.com.br/")
+
+	r := httptest.NewRequest("GET", "http://api.crmind.com.br/ws", nil)
+	assert.True(t, checkRealtimeOrigin(r))
+	r.Header.Set("Origin", "https://admin.crmind.com.br")
+	assert.True(t, checkRealtimeOrigin(r))
+	r.Header.Set("Origin", "https://api.crmind.com.br")
+	assert.True(t, checkRealtimeOrigin(r))
+	r.Header.Set("Origin", "https://evil.example.com")
+	assert.False(t, checkRealtimeOrigin(r))
+
+	r = httptest.NewRequest("GET", "/ws?token=abc", nil)
+	assert.Equal(t, "abc", realtimeToken(r))
+	r = httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Sec-WebSocket-Protocol", "bearer, def")
+	assert.Equal(t, "def", realtimeToken(r))
+	r.Header.Set("Sec-WebSocket-Protocol", "bearer")
+	assert.Equal(t, "", realtimeToken(r))
+	r.Header.Set("Authorization", "Bearer ghi")
+	assert.Equal(t, "ghi", realtimeToken(r))
+}
+
+func newTestClient(h *RealtimeHub, tenantID, userID string) *Client {
+	c := &Client{send: make(chan RealtimeEvent, 8), tenantID: tenantID, userID: userID, topics: make(map[string]bool)}
+	h.register(c)
+	return c
+}
+
+func receive(t *testing.T, c *Client) map[string]interface{} {
+	var message map[string]interface{}
+	assert.NoError(t, json.Unmarshal((<-c.send).encode(), &message))
+	return message
+}
+
+func TestRealtimeHubDeliversToAuthorizedSubscribers(t *testing.T) {
+	h := NewRealtimeHub()
+	acme := newTestClient(h, "acme", "u1")
+	other := newTestClient(h, "globex", "u2")
+	idle := newTestClient(h, "acme", "u3")
+	assert.NoError(t, h.subscribe(acme, []string{dashboardTopic}, 0))
+	assert.NoError(t, h.subscribe(other, []string{dashboardTopic}, 0))
+	assert.Equal(t, "subscribed", receive(t, acme)["type"])
+	assert.Equal(t, "subscribed", receive(t, other)["type"])
+
+	h.Publish("acme", dashboardTopic, "dashboard_update", map[string]int{"sales": 1})
+	assert.Len(t, acme.send, 1)
+	assert.Len(t, other.send, 0)
+	assert.Len(t, idle.send, 0)
+	assert.JSONEq(t, `{"id":1,"topic":"dashboard","type":"dashboard_update","data":{"sales":1}}`, string((<-acme.send).encode()))
+
+	h.unsubscribe(acme, dashboardTopic)
+	h.Publish("acme", dashboardTopic, "dashboard_update", nil)
+	assert.Len(t, acme.send, 0)
+
+	// Cliente lento é desconectado e deixa de ser assinante
+	assert.NoError(t, h.subscribe(other, []string{userTopic("u2")}, 0))
+	for i := 0; i < 10; i++ {
+		h.Publish("globex", userTopic("u2"), "goal_reached", i)
+	}
+	assert.False(t, h.clients[other])
+	assert.Empty(t, h.topics[dashboardTopic])
+	assert.Error(t, h.subscribe(other, []string{dashboardTopic}, 0))
+}
+
+func TestEventRing(t *testing.T) {
+	r := &eventRing{}
+	for id := int64(1); id <= realtimeRingSize+10; id++ {
+		r.add(RealtimeEvent{ID: id})
+	}
+	assert.Equal(t, int64(10), r.evicted)
+	events := r.since(realtimeRingSize + 5)
+	assert.Len(t, events, 5)
+	assert.Equal(t, int64(realtimeRingSize+6), events[0].ID)
+	assert.Len(t, r.since(0), realtimeRingSize)
+}
+
+type memoryEventStore struct {
+	events []RealtimeEvent
+}
+
+func (s *memoryEventStore) Append(ctx context.Context, e RealtimeEvent) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memoryEventStore) Since(ctx context.Context, tenantID, topic string, afterID int64, limit int) ([]RealtimeEvent, error) {
+	var events []RealtimeEvent
+	for _, e := range s.events {
+		if e.TenantID == tenantID && e.Topic == topic && e.ID > afterID {
+			events = append(events, e)
+		}
+	}
+	if len(events) > limit {
+		events = events[len(events)-limit:]
+	}
+	return events, nil
+}
+
+func (s *memoryEventStore) After(ctx context.Context, afterID int64, limit int) ([]RealtimeEvent, error) {
+	var events []RealtimeEvent
+	for _, e := range s.events {
+		if e.ID > afterID && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryEventStore) LastID(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestRealtimeHubReplaysMissedEvents(t *testing.T) {
+	h := NewRealtimeHub()
+	h.Publish("acme", dashboardTopic, "dashboard_update", 1)
+	h.Publish("acme", userTopic("u1"), "goal_reached", 2)
+	h.Publish("acme", dashboardTopic, "dashboard_update", 3)
+	h.Publish("globex", dashboardTopic, "dashboard_update", 4)
+
+	c := newTestClient(h, "acme", "u1")
+	assert.NoError(t, h.subscribe(c, []string{dashboardTopic}, 1))
+	ack := receive(t, c)
+	assert.Equal(t, "subscribed", ack["type"])
+	assert.Equal(t, 1.0, ack["data"].(map[string]interface{})["replayed"])
+	assert.Equal(t, false, ack["data"].(map[string]interface{})["truncated"])
+	assert.Equal(t, 3.0, receive(t, c)["id"])
+
+	// Lacuna maior que o buffer: sem armazenamento o reenvio é truncado
+	for i := 0; i < realtimeRingSize; i++ {
+		h.Publish("acme", userTopic("u1"), "goal_reached", i)
+	}
+	c = newTestClient(h, "acme", "u1")
+	assert.NoError(t, h.subscribe(c, []string{userTopic("u1")}, 1))
+	assert.Equal(t, true, receive(t, c)["data"].(map[string]interface{})["truncated"])
+
+	// Com armazenamento, a lacuna vem do banco e a numeração continua
+	store := &memoryEventStore{}
+	h = NewRealtimeHub()
+	h.useStore(store, 100)
+	assert.NoError(t, h.useBackplane(context.Background(), newMemoryBackplane(100, store)))
+	for i := 0; i < realtimeRingSize+3; i++ {
+		h.Publish("acme", dashboardTopic, "dashboard_update", i)
+	}
+	assert.Equal(t, int64(101), store.events[0].ID)
+	c = &Client{send: make(chan RealtimeEvent, realtimeRingSize+10), tenantID: "acme", topics: make(map[string]bool)}
+	h.register(c)
+	assert.NoError(t, h.subscribe(c, []string{dashboardTopic}, 101))
+	ack = receive(t, c)
+	assert.Equal(t, float64(realtimeRingSize+2), ack["data"].(map[string]interface{})["replayed"])
+	assert.Equal(t, false, ack["data"].(map[string]interface{})["truncated"])
+	assert.Equal(t, 102.0, receive(t, c)["id"])
+
+	// Vários tópicos: o reenvio segue a ordem dos IDs
+	h = NewRealtimeHub()
+	h.Publish("acme", dashboardTopic, "dashboard_update", 1)
+	h.Publish("acme", userTopic("u1"), "goal_reached", 2)
+	h.Publish("acme", dashboardTopic, "dashboard_update", 3)
+	c = newTestClient(h, "acme", "u1")
+	assert.NoError(t, h.subscribe(c, []string{dashboardTopic, userTopic("u1")}, 0))
+	assert.Len(t, c.send, 2)
+	c = newTestClient(h, "acme", "u1")
+	assert.NoError(t, h.subscribe(c, []string{dashboardTopic, userTopic("u1")}, 1))
+	receive(t, c)
+	receive(t, c)
+	assert.Equal(t, 2.0, receive(t, c)["id"])
+	assert.Equal(t, 3.0, receive(t, c)["id"])
+
+	// Cliente com ID de antes de um reinício sem armazenamento
+	h = NewRealtimeHub()
+	c = newTestClient(h, "acme", "u1")
+	assert.NoError(t, h.subscribe(c, []string{dashboardTopic}, 50))
+	assert.Equal(t, true, receive(t, c)["data"].(map[string]interface{})["truncated"])
+}
+
+func TestRedactQueryToken(t *testing.T) {
+	assert.Equal(t, "/events?token=REDACTED&topics=dashboard", redactQueryToken("/events?topics=dashboard&token=eyJhbGci.secret"))
+	assert.Equal(t, "/events?topics=dashboard", redactQueryToken("/events?topics=dashboard"))
+	assert.Equal(t, "/ws", redactQueryToken("/ws?token=%zz"))
+	assert.NotContains(t, accessLogFormatter(gin.LogFormatterParams{Method: "GET", Path: "/ws?token=abc"}), "abc")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sseKeepaliveInterval = 15 * time.Second
+	sseRetry             = 5 * time.Second
+)
+
+// Quadro SSE do evento. O JSON é o mesmo enviado pelo websocket; as
+// respostas do hub não têm id e não alteram o Last-Event-ID do navegador.
+func formatSSE(e RealtimeEvent) []byte {
+	var buf bytes.Buffer
+	if e.ID > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", e.ID)
+	}
+	fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", e.Type, e.encode())
+	return buf.Bytes()
+}
+
+// Transporte SSE, para redes que bloqueiam websocket. Os tópicos vêm em
+// topics, separados por vírgula, e valem por toda a conexão. Ao reconectar,
+// o navegador envia Last-Event-ID e recebe a lacuna.
+func streamEvents(c *gin.Context) {
+	if !checkRealtimeOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origem não permitida"})
+		return
+	}
+	if origin := c.GetHeader("Origin"); origin != "" {
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Allow-Credentials", "true")
+		c.Header("Vary", "Origin")
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	client, ok := newRealtimeClient(c, lastEventID)
+	if !ok {
+		return
+	}
+
+	var topics []string
+	for _, topic := range strings.Split(c.Query("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if len(topics) == 0 || len(topics) > maxClientTopics {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Informe de 1 a %d tópicos em topics", maxClientTopics)})
+		return
+	}
+	for _, topic := range topics {
+		if err := authorizeTopic(client, topic); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "topic": topic})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry.Milliseconds())
+	c.Writer.Flush()
+
+	realtimeHub.register(client)
+	defer realtimeHub.unregister(client)
+	if err := realtimeHub.subscribe(client, topics, client.lastEventID); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-client.send:
+			// Canal fechado: cliente lento desconectado; o navegador reconecta
+			if !ok {
+				return
+			}
+			if _, err := c.Writer.Write(formatSSE(event)); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-keepalive.C:
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"CRMind/backend/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSSE(t *testing.T) {
+	e := RealtimeEvent{ID: 7, Topic: dashboardTopic, Type: "dashboard_update", Data: []byte(`{"sales":1}`)}
+	assert.Equal(t, "id: 7\nevent: dashboard_update\ndata: {\"id\":7,\"topic\":\"dashboard\",\"type\":\"dashboard_update\",\"data\":{\"sales\":1}}\n\n", string(formatSSE(e)))
+	assert.Equal(t, "event: subscribed\ndata: {\"type\":\"subscribed\",\"data\":{\"topic\":\"dashboard\"}}\n\n", string(formatSSE(newReply("subscribed", gin.H{"topic": "dashboard"}))))
+}
+
+func TestStreamEventsResumesFromLastEventID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	setupRealtimeRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?topics=dashboard")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	token, err := auth.GenerateToken("u1", "rep", "acme")
+	assert.NoError(t, err)
+	realtimeHub.Publish("acme", dashboardTopic, "dashboard_update", 1)
+	realtimeHub.Publish(defaultTenantID, dashboardTopic, "dashboard_update", 99)
+	realtimeHub.Publish("acme", dashboardTopic, "dashboard_update", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events?topics=dashboard&token="+token, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(lines) < 6 && scanner.Scan() {
+		if scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	assert.Equal(t, "retry: 5000", lines[0])
+	assert.Equal(t, "event: subscribed", lines[1])
+	assert.Contains(t, lines[2], `"replayed":1`)
+	assert.Equal(t, "id: 3", lines[3])
+	assert.Equal(t, "event: dashboard_update", lines[4])
+	assert.True(t, strings.HasPrefix(lines[5], `data: {"id":3,`))
+}
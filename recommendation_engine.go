@@ -13,6 +13,8 @@ type RecommendationEngine struct {
 	UserItemMatrix *mat.Dense
 	Users          []string
 	Items          []string
+	// Usuários sem consentimento para perfilamento não recebem recomendações
+	ConsentChecker func(user string) bool
 }
 
 func NewRecommendationEngine() *RecommendationEngine {
@@ -20,6 +22,7 @@ func NewRecommendationEngine() *RecommendationEngine {
 		UserItemMatrix: mat.NewDense(0, 0, nil),
 		Users:          make([]string, 0),
 		Items:          make([]string, 0),
+		ConsentChecker: hasProfilingConsent,
 	}
 }
 
@@ -40,6 +43,10 @@ func (re *RecommendationEngine) AddRating(user, item string, rating float64) {
 }
 
 func (re *RecommendationEngine) GetRecommendations(user string, n int) []string {
+	if re.ConsentChecker != nil && !re.ConsentChecker(user) {
+		return []string{}
+	}
+
 	userIndex := re.getUserIndex(user)
 	if userIndex >= re.UserItemMatrix.RawMatrix().Rows {
 		return []string{}
@@ -79,6 +86,44 @@ func (re *RecommendationEngine) GetRecommendations(user string, n int) []string
 	return recommendations
 }
 
+func (re *RecommendationEngine) UserRatings(user string) map[string]float64 {
+	ratings := make(map[string]float64)
+	userIndex := re.findUserIndex(user)
+	rows, cols := re.UserItemMatrix.Dims()
+	if userIndex < 0 || userIndex >= rows {
+		return ratings
+	}
+
+	for i := 0; i < cols && i < len(re.Items); i++ {
+		if rating := re.UserItemMatrix.At(userIndex, i); rating != 0 {
+			ratings[re.Items[i]] = rating
+		}
+	}
+	return ratings
+}
+
+// Remove todas as avaliações do usuário (direito ao esquecimento)
+func (re *RecommendationEngine) RemoveUser(user string) {
+	userIndex := re.findUserIndex(user)
+	rows, cols := re.UserItemMatrix.Dims()
+	if userIndex < 0 || userIndex >= rows {
+		return
+	}
+
+	for i := 0; i < cols; i++ {
+		re.UserItemMatrix.Set(userIndex, i, 0)
+	}
+}
+
+func (re *RecommendationEngine) findUserIndex(user string) int {
+	for i, u := range re.Users {
+		if u == user {
+			return i
+		}
+	}
+	return -1
+}
+
 func (re *RecommendationEngine) getUserIndex(user string) int {
 	for i, u := range re.Users {
 		if u == user {
@@ -134,6 +179,11 @@ func addRating(c *gin.Context) {
 		return
 	}
 
+	if !hasProfilingConsent(rating.User) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cliente sem consentimento para perfilamento"})
+		return
+	}
+
 	recommendationEngine.AddRating(rating.User, rating.Item, rating.Rating)
 	c.JSON(http.StatusOK, gin.H{"message": "Rating added successfully"})
 }
@@ -27,7 +27,6 @@ func setupRecommendationEngineRoutes(r *gin.Engine) {
 func getProductRecommendations(c *gin.Context) {
 	customerID := c.Param("customer_id")
 	
-	customerData := getCustomerData(customerID)
 	recentPurchases := getRecentPurchases(customerID)
 	browsingHistory := getBrowsingHistory(customerID)
 	
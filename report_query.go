@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxQueryDimensions = 5
+	maxQueryMeasures   = 10
+	maxQueryFilters    = 20
+	maxQueryInValues   = 100
+	defaultQueryLimit  = 1000
+	maxQueryLimit      = 10000
+	reportQueryTimeout = 30 * time.Second
+)
+
+// Tipos de campo, que determinam operadores e agregações permitidos
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+	fieldTime   = "time"
+)
+
+type queryField struct {
+	Expr string
+	Kind string
+}
+
+// Conjunto de dados consultável: a cláusula FROM, a coluna de tenant e a
+// lista fechada de campos expostos. Somente as expressões daqui entram no
+// SQL; tudo o que vem do cliente é nome a ser buscado nestes mapas ou
+// valor passado como parâmetro.
+type queryDataset struct {
+	From   string
+	Tenant string
+	Fields map[string]queryField
+}
+
+var queryDatasets = map[string]queryDataset{
+	"customers": {
+		From:   "customers c",
+		Tenant: "c.tenant_id",
+		Fields: map[string]queryField{
+			"id":              {"c.id::text", fieldString},
+			"name":            {"c.name", fieldString},
+			"lifecycle_stage": {"c.lifecycle_stage", fieldString},
+			"owner_id":        {"c.owner_id::text", fieldString},
+			"created_at":      {"c.created_at", fieldTime},
+		},
+	},
+	"sales": {
+		From:   "sales s JOIN customers c ON c.id = s.customer_id",
+		Tenant: "c.tenant_id",
+		Fields: map[string]queryField{
+			"id":           {"s.id::text", fieldString},
+			"customer_id":  {"s.customer_id::text", fieldString},
+			"owner_id":     {"c.owner_id::text", fieldString},
+			"product_name": {"s.product_name", fieldString},
+			"category":     {"s.category", fieldString},
+			"region":       {"s.region", fieldString},
+			"amount":       {"s.amount", fieldNumber},
+			"date":         {"s.date", fieldTime},
+		},
+	},
+	"activities": {
+		From:   "interactions i JOIN customers c ON c.id = i.customer_id",
+		Tenant: "c.tenant_id",
+		Fields: map[string]queryField{
+			"id":          {"i.id::text", fieldString},
+			"customer_id": {"i.customer_id::text", fieldString},
+			"owner_id":    {"c.owner_id::text", fieldString},
+			"type":        {"i.type", fieldString},
+			"sentiment":   {"i.sentiment", fieldNumber},
+			"created_at":  {"i.created_at", fieldTime},
+		},
+	},
+	"opportunities": {
+		From:   "opportunities o",
+		Tenant: "o.tenant_id",
+		Fields: map[string]queryField{
+			"id":                  {"o.id::text", fieldString},
+			"name":                {"o.name", fieldString},
+			"customer_id":         {"o.customer_id::text", fieldString},
+			"owner_id":            {"o.owner_id::text", fieldString},
+			"stage":               {"o.stage", fieldString},
+			"forecast_category":   {"o.forecast_category", fieldString},
+			"amount":              {"o.amount", fieldNumber},
+			"probability":         {"o.probability", fieldNumber},
+			"expected_close_date": {"o.expected_close_date", fieldTime},
+			"closed_at":           {"o.closed_at", fieldTime},
+			"created_at":          {"o.created_at", fieldTime},
+		},
+	},
+}
+
+var queryAggregations = map[string]string{
+	"count":    "COUNT(%s)",
+	"distinct": "COUNT(DISTINCT %s)",
+	"sum":      "SUM(%s)",
+	"avg":      "AVG(%s)",
+	"min":      "MIN(%s)",
+	"max":      "MAX(%s)",
+}
+
+var queryComparisons = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+var queryBuckets = map[string]bool{
+	"day":     true,
+	"week":    true,
+	"month":   true,
+	"quarter": true,
+	"year":    true,
+}
+
+var queryAliasPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,39}$`)
+
+type QueryMeasure struct {
+	Op    string `json:"op"`
+	Field string `json:"field"`
+	As    string `json:"as"`
+}
+
+type QueryFilter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+type QueryTimeBucket struct {
+	Field       string `json:"field"`
+	Granularity string `json:"granularity"`
+}
+
+type QuerySort struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// Especificação de um relatório ad hoc. Sem medidas, a consulta lista os
+// valores distintos das dimensões.
+type ReportQuerySpec struct {
+	Dataset    string           `json:"dataset"`
+	Dimensions []string         `json:"dimensions"`
+	TimeBucket *QueryTimeBucket `json:"time_bucket"`
+	Measures   []QueryMeasure   `json:"measures"`
+	Filters    []QueryFilter    `json:"filters"`
+	Sort       []QuerySort      `json:"sort"`
+	Limit      int              `json:"limit"`
+}
+
+type compiledQuery struct {
+	SQL     string
+	Args    []interface{}
+	Columns []string
+	Kinds   []string
+}
+
+// Converte o valor do filtro para o tipo do campo
+func queryValue(field queryField, value interface{}) (interface{}, error) {
+	switch field.Kind {
+	case fieldNumber:
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("valor numérico esperado")
+	case fieldTime:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("data esperada")
+		}
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("data deve estar no formato AAAA-MM-DD ou RFC 3339")
+	default:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return nil, fmt.Errorf("texto esperado")
+	}
+}
+
+// Compila a especificação em SQL parametrizado, restrito ao tenant ($1)
+func compileReportQuery(tenantID string, spec ReportQuerySpec) (compiledQuery, error) {
+	var q compiledQuery
+	dataset, ok := queryDatasets[spec.Dataset]
+	if !ok {
+		return q, fmt.Errorf("dataset deve ser customers, sales, activities ou opportunities")
+	}
+	if len(spec.Dimensions) > maxQueryDimensions || len(spec.Measures) > maxQueryMeasures || len(spec.Filters) > maxQueryFilters {
+		return q, fmt.Errorf("no máximo %d dimensões, %d medidas e %d filtros", maxQueryDimensions, maxQueryMeasures, maxQueryFilters)
+	}
+	if len(spec.Dimensions) == 0 && spec.TimeBucket == nil && len(spec.Measures) == 0 {
+		return q, fmt.Errorf("informe ao menos uma dimensão ou medida")
+	}
+
+	q.Args = []interface{}{tenantID}
+	param := func(value interface{}) string {
+		q.Args = append(q.Args, value)
+		return "$" + strconv.Itoa(len(q.Args))
+	}
+	field := func(name string) (queryField, error) {
+		f, ok := dataset.Fields[name]
+		if !ok {
+			return f, fmt.Errorf("campo desconhecido em %s: %q", spec.Dataset, name)
+		}
+		return f, nil
+	}
+
+	var selects, groups []string
+	addColumn := func(name, expr, kind string) error {
+		for _, existing := range q.Columns {
+			if existing == name {
+				return fmt.Errorf("coluna repetida: %q", name)
+			}
+		}
+		selects = append(selects, expr)
+		q.Columns = append(q.Columns, name)
+		q.Kinds = append(q.Kinds, kind)
+		return nil
+	}
+
+	if b := spec.TimeBucket; b != nil {
+		f, err := field(b.Field)
+		if err != nil {
+			return q, err
+		}
+		if f.Kind != fieldTime || !queryBuckets[b.Granularity] {
+			return q, fmt.Errorf("time_bucket exige campo de data e granularidade day, week, month, quarter ou year")
+		}
+		// A granularidade vem da lista fechada queryBuckets
+		expr := fmt.Sprintf("date_trunc('%s', %s)", b.Granularity, f.Expr)
+		if err := addColumn(b.Field+"_"+b.Granularity, expr, fieldTime); err != nil {
+			return q, err
+		}
+		groups = append(groups, expr)
+	}
+	for _, name := range spec.Dimensions {
+		f, err := field(name)
+		if err != nil {
+			return q, err
+		}
+		if err := addColumn(name, f.Expr, f.Kind); err != nil {
+			return q, err
+		}
+		groups = append(groups, f.Expr)
+	}
+
+	for _, m := range spec.Measures {
+		template, ok := queryAggregations[m.Op]
+		if !ok {
+			return q, fmt.Errorf("operação inválida: %q", m.Op)
+		}
+		expr, kind := "*", fieldNumber
+		if m.Field != "" {
+			f, err := field(m.Field)
+			if err != nil {
+				return q, err
+			}
+			expr = f.Expr
+			switch {
+			case (m.Op == "sum" || m.Op == "avg") && f.Kind != fieldNumber:
+				return q, fmt.Errorf("%s exige campo numérico: %q", m.Op, m.Field)
+			case (m.Op == "min" || m.Op == "max") && f.Kind == fieldString:
+				return q, fmt.Errorf("%s exige campo numérico ou de data: %q", m.Op, m.Field)
+			case m.Op == "min" || m.Op == "max":
+				kind = f.Kind
+			}
+		} else if m.Op != "count" {
+			return q, fmt.Errorf("%s exige um campo", m.Op)
+		}
+
+		name := m.As
+		if name == "" {
+			name = strings.Trim(m.Op+"_"+m.Field, "_")
+		}
+		if !queryAliasPattern.MatchString(name) {
+			return q, fmt.Errorf("nome de medida inválido: %q", name)
+		}
+		if err := addColumn(name, fmt.Sprintf(template, expr), kind); err != nil {
+			return q, err
+		}
+	}
+
+	where := []string{dataset.Tenant + " = $1"}
+	for _, filter := range spec.Filters {
+		f, err := field(filter.Field)
+		if err != nil {
+			return q, err
+		}
+		switch filter.Op {
+		case "in":
+			values, ok := filter.Value.([]interface{})
+			if !ok || len(values) == 0 || len(values) > maxQueryInValues {
+				return q, fmt.Errorf("in exige uma lista de 1 a %d valores", maxQueryInValues)
+			}
+			placeholders := make([]string, len(values))
+			for i, value := range values {
+				v, err := queryValue(f, value)
+				if err != nil {
+					return q, fmt.Errorf("filtro %s: %v", filter.Field, err)
+				}
+				placeholders[i] = param(v)
+			}
+			where = append(where, fmt.Sprintf("%s IN (%s)", f.Expr, strings.Join(placeholders, ", ")))
+		case "contains":
+			s, ok := filter.Value.(string)
+			if !ok || f.Kind != fieldString {
+				return q, fmt.Errorf("contains exige campo e valor de texto")
+			}
+			escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+			where = append(where, fmt.Sprintf("%s ILIKE %s", f.Expr, param("%"+escaped+"%")))
+		case "is_null":
+			where = append(where, f.Expr+" IS NULL")
+		case "not_null":
+			where = append(where, f.Expr+" IS NOT NULL")
+		default:
+			operator, ok := queryComparisons[filter.Op]
+			if !ok {
+				return q, fmt.Errorf("operador de filtro inválido: %q", filter.Op)
+			}
+			v, err := queryValue(f, filter.Value)
+			if err != nil {
+				return q, fmt.Errorf("filtro %s: %v", filter.Field, err)
+			}
+			where = append(where, fmt.Sprintf("%s %s %s", f.Expr, operator, param(v)))
+		}
+	}
+
+	// Ordenação pelas colunas de saída, referenciadas por posição
+	var orders []string
+	for _, s := range spec.Sort {
+		position := -1
+		for i, name := range q.Columns {
+			if name == s.Field {
+				position = i + 1
+			}
+		}
+		if position < 0 {
+			return q, fmt.Errorf("sort deve usar uma coluna do resultado: %q", s.Field)
+		}
+		order := strconv.Itoa(position)
+		if s.Desc {
+			order += " DESC"
+		}
+		orders = append(orders, order)
+	}
+
+	limit := spec.Limit
+	if limit == 0 {
+		limit = defaultQueryLimit
+	}
+	if limit < 1 || limit > maxQueryLimit {
+		return q, fmt.Errorf("limit deve estar entre 1 e %d", maxQueryLimit)
+	}
+
+	var sql strings.Builder
+	sql.WriteString("SELECT ")
+	if len(spec.Measures) == 0 {
+		sql.WriteString("DISTINCT ")
+	}
+	sql.WriteString(strings.Join(selects, ", "))
+	sql.WriteString(" FROM " + dataset.From)
+	sql.WriteString(" WHERE " + strings.Join(where, " AND "))
+	if len(spec.Measures) > 0 && len(groups) > 0 {
+		sql.WriteString(" GROUP BY " + strings.Join(groups, ", "))
+	}
+	if len(orders) > 0 {
+		sql.WriteString(" ORDER BY " + strings.Join(orders, ", "))
+	}
+	sql.WriteString(" LIMIT " + param(limit))
+	q.SQL = sql.String()
+	return q, nil
+}
+
+// Normaliza os valores lidos: numéricos do Postgres chegam como texto
+func normalizeQueryValue(value interface{}, kind string) interface{} {
+	raw, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	if kind == fieldNumber {
+		if n, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return n
+		}
+	}
+	return string(raw)
+}
+
+func runReportQuery(ctx context.Context, q compiledQuery) ([][]interface{}, error) {
+	rows, err := db.QueryContext(ctx, q.SQL, q.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := [][]interface{}{}
+	for rows.Next() {
+		row := make([]interface{}, len(q.Columns))
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i := range row {
+			row[i] = normalizeQueryValue(row[i], q.Kinds[i])
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// Executa um relatório ad hoc. Com ?format=csv o resultado é devolvido
+// como arquivo CSV; o padrão é JSON com colunas e linhas.
+func postReportQuery(c *gin.Context) {
+	var spec ReportQuerySpec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format deve ser json ou csv"})
+		return
+	}
+
+	q, err := compileReportQuery(tenantFromContext(c), spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), reportQueryTimeout)
+	defer cancel()
+	rows, err := runReportQuery(ctx, q)
+	if err != nil {
+		logger.Errorf("Erro ao executar relatório ad hoc: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao executar relatório"})
+		return
+	}
+
+	if format == "csv" {
+		table := ReportTable{Title: spec.Dataset, Columns: q.Columns}
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				if t, ok := value.(time.Time); ok {
+					value = t.Format(time.RFC3339)
+				}
+				cells[i] = formatReportValue(value)
+			}
+			table.Rows = append(table.Rows, cells)
+		}
+		data, err := renderReportCSV(ReportDocument{Tables: []ReportTable{table}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao gerar CSV"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, spec.Dataset))
+		c.Data(http.StatusOK, reportFormats["csv"].ContentType, data)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"columns": q.Columns, "rows": rows})
+}
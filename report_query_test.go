@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileReportQuery(t *testing.T) {
+	q, err := compileReportQuery("acme", ReportQuerySpec{
+		Dataset:    "sales",
+		Dimensions: []string{"region"},
+		TimeBucket: &QueryTimeBucket{Field: "date", Granularity: "month"},
+		Measures: []QueryMeasure{
+			{Op: "sum", Field: "amount", As: "revenue"},
+			{Op: "distinct", Field: "customer_id"},
+		},
+		Filters: []QueryFilter{
+			{Field: "date", Op: "gte", Value: "2026-01-01"},
+			{Field: "region", Op: "in", Value: []interface{}{"Sul", "Sudeste"}},
+			{Field: "product_name", Op: "contains", Value: "50%"},
+		},
+		Sort:  []QuerySort{{Field: "revenue", Desc: true}},
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT date_trunc('month', s.date), s.region, SUM(s.amount), COUNT(DISTINCT s.customer_id::text)"+
+		" FROM sales s JOIN customers c ON c.id = s.customer_id"+
+		" WHERE c.tenant_id = $1 AND s.date >= $2 AND s.region IN ($3, $4) AND s.product_name ILIKE $5"+
+		" GROUP BY date_trunc('month', s.date), s.region ORDER BY 3 DESC LIMIT $6", q.SQL)
+	assert.Equal(t, []interface{}{"acme", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "Sul", "Sudeste", `%50\%%`, 10}, q.Args)
+	assert.Equal(t, []string{"date_month", "region", "revenue", "distinct_customer_id"}, q.Columns)
+
+	q, err = compileReportQuery("acme", ReportQuerySpec{Dataset: "customers", Measures: []QueryMeasure{{Op: "count"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT COUNT(*) FROM customers c WHERE c.tenant_id = $1 LIMIT $2", q.SQL)
+}
+
+func TestCompileReportQueryRejectsUnsafeSpecs(t *testing.T) {
+	invalid := []ReportQuerySpec{
+		{Dataset: "users", Measures: []QueryMeasure{{Op: "count"}}},
+		{Dataset: "sales", Dimensions: []string{"amount; DROP TABLE sales"}},
+		{Dataset: "sales", Dimensions: []string{"tenant_id"}},
+		{Dataset: "sales", Measures: []QueryMeasure{{Op: "sum", Field: "region"}}},
+		{Dataset: "sales", Measures: []QueryMeasure{{Op: "count", As: `x" FROM pg_user --`}}},
+		{Dataset: "sales", Measures: []QueryMeasure{{Op: "stddev", Field: "amount"}}},
+		{Dataset: "sales", TimeBucket: &QueryTimeBucket{Field: "date", Granularity: "minute'); --"}},
+		{Dataset: "sales", Dimensions: []string{"region"}, Filters: []QueryFilter{{Field: "amount", Op: "gt", Value: "1 OR 1=1"}}},
+		{Dataset: "sales", Dimensions: []string{"region"}, Filters: []QueryFilter{{Field: "region", Op: "like", Value: "x"}}},
+		{Dataset: "sales", Dimensions: []string{"region"}, Sort: []QuerySort{{Field: "amount"}}},
+		{Dataset: "sales", Dimensions: []string{"region"}, Limit: 1000000},
+	}
+	for _, spec := range invalid {
+		_, err := compileReportQuery("acme", spec)
+		assert.Error(t, err, "%+v", spec)
+	}
+}
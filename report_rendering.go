@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Tabela de um relatório; relatórios de dashboard têm uma por widget
+type ReportTable struct {
+	Title   string
+	Columns []string
+	Rows    [][]string
+}
+
+type ReportDocument struct {
+	Title       string
+	GeneratedAt time.Time
+	Tables      []ReportTable
+}
+
+type reportFormat struct {
+	ContentType string
+	Extension   string
+	Render      func(ReportDocument) ([]byte, error)
+}
+
+var reportFormats = map[string]reportFormat{
+	"csv":  {"text/csv; charset=utf-8", "csv", renderReportCSV},
+	"html": {"text/html; charset=utf-8", "html", renderReportHTML},
+	"pdf":  {"application/pdf", "pdf", renderReportPDF},
+}
+
+// Tabelas separadas por uma linha em branco, cada uma precedida do título
+func renderReportCSV(doc ReportDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	for i, table := range doc.Tables {
+		if i > 0 {
+			writer.Write(nil)
+		}
+		if len(doc.Tables) > 1 {
+			writer.Write([]string{table.Title})
+		}
+		writer.Write(table.Columns)
+		for _, row := range table.Rows {
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 13px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f2f2f2; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Gerado em {{.GeneratedAt.Format "02/01/2006 15:04 MST"}}</p>
+{{range .Tables}}
+<h2>{{.Title}}</h2>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+func renderReportHTML(doc ReportDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := reportHTMLTemplate.Execute(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Layout do PDF: A4 em pontos, fonte Helvetica embutida nos leitores
+const (
+	pdfPageWidth   = 595.0
+	pdfPageHeight  = 842.0
+	pdfMargin      = 40.0
+	pdfFontSize    = 9.0
+	pdfLineHeight  = 13.0
+	pdfTitleSize   = 14.0
+	pdfHeadingSize = 11.0
+)
+
+type pdfPage struct {
+	content bytes.Buffer
+	y       float64
+}
+
+// Gerador mínimo de PDF com texto em tabelas, sem dependências externas.
+// Colunas têm largura igual e o texto que não cabe é truncado.
+type pdfWriter struct {
+	pages []*pdfPage
+}
+
+func (w *pdfWriter) current() *pdfPage {
+	if len(w.pages) == 0 {
+		w.newPage()
+	}
+	return w.pages[len(w.pages)-1]
+}
+
+func (w *pdfWriter) newPage() {
+	w.pages = append(w.pages, &pdfPage{y: pdfPageHeight - pdfMargin})
+}
+
+// Garante espaço para height pontos, abrindo uma nova página se preciso
+func (w *pdfWriter) reserve(height float64) *pdfPage {
+	page := w.current()
+	if page.y-height < pdfMargin {
+		w.newPage()
+		page = w.current()
+	}
+	page.y -= height
+	return page
+}
+
+func (w *pdfWriter) text(page *pdfPage, x float64, font string, size float64, value string) {
+	fmt.Fprintf(&page.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, page.y, pdfEscape(value))
+}
+
+func (w *pdfWriter) row(cells []string, font string) {
+	page := w.reserve(pdfLineHeight)
+	if len(cells) == 0 {
+		return
+	}
+	width := (pdfPageWidth - 2*pdfMargin) / float64(len(cells))
+	// Largura média de um caractere Helvetica: cerca de metade do corpo
+	maxChars := int(width/(pdfFontSize*0.5)) - 1
+	for i, cell := range cells {
+		runes := []rune(cell)
+		if maxChars > 1 && len(runes) > maxChars {
+			cell = string(runes[:maxChars-1]) + "…"
+		}
+		w.text(page, pdfMargin+float64(i)*width, font, pdfFontSize, cell)
+	}
+}
+
+// Converte para WinAnsi (Latin-1 cobre a acentuação do português) e
+// escapa os caracteres especiais das strings PDF
+func pdfEscape(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '…':
+			b.WriteByte(0x85)
+		case r < 32:
+			b.WriteByte(' ')
+		case r < 256:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func renderReportPDF(doc ReportDocument) ([]byte, error) {
+	w := &pdfWriter{}
+	page := w.reserve(pdfTitleSize)
+	w.text(page, pdfMargin, "F2", pdfTitleSize, doc.Title)
+	page = w.reserve(pdfLineHeight * 1.5)
+	w.text(page, pdfMargin, "F1", pdfFontSize, "Gerado em "+doc.GeneratedAt.Format("02/01/2006 15:04 MST"))
+
+	for _, table := range doc.Tables {
+		page = w.reserve(pdfLineHeight * 2)
+		w.text(page, pdfMargin, "F2", pdfHeadingSize, table.Title)
+		w.row(table.Columns, "F2")
+		for _, row := range table.Rows {
+			w.row(row, "F1")
+		}
+		w.reserve(pdfLineHeight)
+	}
+
+	// Objetos: 1 catálogo, 2 árvore de páginas, 3 e 4 fontes e, para cada
+	// página, o objeto da página seguido do seu conteúdo
+	var objects []string
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	)
+	for i, p := range w.pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()),
+		)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Classe de dados sujeita a política de retenção. O expurgo só alcança
+// registros de clientes do tenant que não estejam sob retenção legal.
+type retentionDataClass struct {
+	Description string
+	Table       string
+	// Coluna textual com o ID do cliente, para tabelas sem customer_id
+	TextCustomerKey string
+	// Condição adicional para que o registro ainda tenha algo a expurgar
+	Pending string
+	// Ação aplicada aos registros vencidos
+	Purge string
+}
+
+var retentionDataClasses = map[string]retentionDataClass{
+	"interaction_content": {
+		Description: "Texto das interações (a pontuação de sentimento é mantida)",
+		Table:       "interactions",
+		Pending:     "(content IS NOT NULL OR content_enc IS NOT NULL)",
+		Purge:       "UPDATE interactions SET content = NULL, content_enc = NULL",
+	},
+	"interactions": {
+		Description: "Interações completas, incluindo a pontuação de sentimento",
+		Table:       "interactions",
+		Pending:     "TRUE",
+		Purge:       "DELETE FROM interactions",
+	},
+	"chatbot_transcripts": {
+		Description: "Transcrições do chatbot",
+		Table:       "chatbot_messages",
+		Pending:     "TRUE",
+		Purge:       "DELETE FROM chatbot_messages",
+	},
+	"customer_logs": {
+		Description:     "Registros de auditoria de ações sobre clientes, exceto consentimentos, solicitações de titulares e retenções legais, mantidos como prova",
+		Table:           "audit_log",
+		TextCustomerKey: "entity_id",
+		Pending: `entity_type = 'customer' AND action NOT IN ('consent_granted', 'consent_withdrawn',
+			'dsr_opened', 'dsr_completed', 'dsr_failed', 'legal_hold_placed', 'legal_hold_released')`,
+		Purge: "DELETE FROM audit_log",
+	},
+}
+
+type RetentionPolicy struct {
+	TenantID      string    `json:"tenant_id"`
+	DataClass     string    `json:"data_class"`
+	RetentionDays int       `json:"retention_days"`
+	Enabled       bool      `json:"enabled"`
+	UpdatedBy     string    `json:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type LegalHold struct {
+	ID         int64      `json:"id"`
+	CustomerID string     `json:"customer_id"`
+	Reason     string     `json:"reason"`
+	PlacedBy   string     `json:"placed_by"`
+	PlacedAt   time.Time  `json:"placed_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+}
+
+type RetentionPurgeResult struct {
+	DataClass     string    `json:"data_class"`
+	RetentionDays int       `json:"retention_days"`
+	Cutoff        time.Time `json:"cutoff"`
+	Affected      int64     `json:"affected"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func createRetentionTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS retention_policies (
+			tenant_id VARCHAR(50) NOT NULL,
+			data_class VARCHAR(50) NOT NULL,
+			retention_days INTEGER NOT NULL CHECK (retention_days > 0),
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_by VARCHAR(100) NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (tenant_id, data_class)
+		);
+
+		CREATE TABLE IF NOT EXISTS legal_holds (
+			id BIGSERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL,
+			customer_id INTEGER NOT NULL REFERENCES customers(id),
+			reason TEXT NOT NULL,
+			placed_by VARCHAR(100) NOT NULL,
+			placed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			released_by VARCHAR(100),
+			released_at TIMESTAMP
+		);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupRetentionRoutes(privacy *gin.RouterGroup) {
+	retention := privacy.Group("/retention")
+	retention.Use(roleAuthorization("admin"))
+	{
+		retention.GET("/policies", listRetentionPolicies)
+		retention.PUT("/policies/:data_class", upsertRetentionPolicy)
+		retention.GET("/dry-run", getRetentionDryRun)
+		retention.POST("/purge", runRetentionPurge)
+		retention.GET("/legal-holds", listLegalHolds)
+		retention.POST("/legal-holds", placeLegalHold)
+		retention.DELETE("/legal-holds/:hold_id", releaseLegalHold)
+	}
+}
+
+func listRetentionPolicies(c *gin.Context) {
+	policies, err := loadRetentionPolicies(tenantFromContext(c), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar políticas de retenção"})
+		return
+	}
+
+	classes := gin.H{}
+	for name, class := range retentionDataClasses {
+		classes[name] = class.Description
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies, "data_classes": classes})
+}
+
+func upsertRetentionPolicy(c *gin.Context) {
+	dataClass := c.Param("data_class")
+	if _, ok := retentionDataClasses[dataClass]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Classe de dados desconhecida"})
+		return
+	}
+
+	var request struct {
+		RetentionDays int   `json:"retention_days" binding:"required,min=1"`
+		Enabled       *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	enabled := request.Enabled == nil || *request.Enabled
+
+	tenantID := tenantFromContext(c)
+	actor := c.GetString("user_id")
+	_, err := db.Exec(`
+		INSERT INTO retention_policies (tenant_id, data_class, retention_days, enabled, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (tenant_id, data_class) DO UPDATE
+		SET retention_days = EXCLUDED.retention_days, enabled = EXCLUDED.enabled,
+			updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at`,
+		tenantID, dataClass, request.RetentionDays, enabled, actor,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao salvar política de retenção"})
+		return
+	}
+
+	recordAudit(tenantID, actor, "retention_policy_updated", "tenant", tenantID, gin.H{
+		"data_class":     dataClass,
+		"retention_days": request.RetentionDays,
+		"enabled":        enabled,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Política de retenção salva com sucesso"})
+}
+
+func getRetentionDryRun(c *gin.Context) {
+	results, err := purgeTenantData(c.Request.Context(), tenantFromContext(c), c.GetString("user_id"), true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao simular expurgo"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dry_run": true, "results": results})
+}
+
+func runRetentionPurge(c *gin.Context) {
+	results, err := purgeTenantData(c.Request.Context(), tenantFromContext(c), c.GetString("user_id"), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao executar expurgo"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dry_run": false, "results": results})
+}
+
+func runScheduledRetentionPurge(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT tenant_id FROM retention_policies WHERE enabled")
+	if err != nil {
+		return err
+	}
+	var tenants []string
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			rows.Close()
+			return err
+		}
+		tenants = append(tenants, tenantID)
+	}
+	rows.Close()
+
+	for _, tenantID := range tenants {
+		if _, err := purgeTenantData(ctx, tenantID, "system", false); err != nil {
+			logger.Errorf("Falha no expurgo do tenant %s: %v", tenantID, err)
+		}
+	}
+	return nil
+}
+
+func loadRetentionPolicies(tenantID string, onlyEnabled bool) ([]RetentionPolicy, error) {
+	rows, err := db.Query(`
+		SELECT tenant_id, data_class, retention_days, enabled, updated_by, updated_at
+		FROM retention_policies
+		WHERE tenant_id = $1 AND (enabled OR NOT $2)
+		ORDER BY data_class`,
+		tenantID, onlyEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []RetentionPolicy{}
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.TenantID, &p.DataClass, &p.RetentionDays, &p.Enabled, &p.UpdatedBy, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// Aplica (ou simula, se dryRun) todas as políticas ativas do tenant e registra
+// um resumo da execução no audit trail
+func purgeTenantData(ctx context.Context, tenantID, actor string, dryRun bool) ([]RetentionPurgeResult, error) {
+	policies, err := loadRetentionPolicies(tenantID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]RetentionPurgeResult, 0, len(policies))
+	var totalAffected int64
+	for _, policy := range policies {
+		class, ok := retentionDataClasses[policy.DataClass]
+		if !ok {
+			continue
+		}
+
+		result := RetentionPurgeResult{
+			DataClass:     policy.DataClass,
+			RetentionDays: policy.RetentionDays,
+			Cutoff:        time.Now().AddDate(0, 0, -policy.RetentionDays),
+		}
+		affected, err := purgeDataClass(ctx, class, tenantID, result.Cutoff, dryRun)
+		if err != nil {
+			result.Error = err.Error()
+			logger.Errorf("Falha no expurgo de %s (tenant %s): %v", policy.DataClass, tenantID, err)
+		}
+		result.Affected = affected
+		totalAffected += affected
+		results = append(results, result)
+	}
+
+	action := "retention_purge"
+	if dryRun {
+		action = "retention_dry_run"
+	}
+	recordAudit(tenantID, actor, action, "tenant", tenantID, gin.H{
+		"results":        results,
+		"total_affected": totalAffected,
+	})
+	return results, nil
+}
+
+func purgeDataClass(ctx context.Context, class retentionDataClass, tenantID string, cutoff time.Time, dryRun bool) (int64, error) {
+	key, customerID, heldID := "customer_id", "id", "customer_id"
+	if class.TextCustomerKey != "" {
+		key, customerID, heldID = class.TextCustomerKey, "id::text", "customer_id::text"
+	}
+	where := fmt.Sprintf(`
+		WHERE created_at < $1 AND %s
+		AND %s IN (SELECT %s FROM customers WHERE tenant_id = $2)
+		AND %s NOT IN (SELECT %s FROM legal_holds WHERE tenant_id = $2 AND released_at IS NULL)`,
+		class.Pending, key, customerID, key, heldID,
+	)
+
+	if dryRun {
+		var count int64
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+class.Table+where, cutoff, tenantID).Scan(&count)
+		return count, err
+	}
+
+	res, err := db.ExecContext(ctx, class.Purge+where, cutoff, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func customerUnderLegalHold(tenantID, customerID string) bool {
+	var held bool
+	err := db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM legal_holds WHERE tenant_id = $1 AND customer_id = $2 AND released_at IS NULL)",
+		tenantID, customerID,
+	).Scan(&held)
+	if err != nil {
+		logger.Errorf("Falha ao verificar retenção legal de %s: %v", customerID, err)
+		return true
+	}
+	return held
+}
+
+func listLegalHolds(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT id, customer_id, reason, placed_by, placed_at, released_at
+		FROM legal_holds
+		WHERE tenant_id = $1 AND (released_at IS NULL OR $2)
+		ORDER BY placed_at DESC`,
+		tenantFromContext(c), c.Query("include_released") == "true",
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar retenções legais"})
+		return
+	}
+	defer rows.Close()
+
+	holds := []LegalHold{}
+	for rows.Next() {
+		var h LegalHold
+		var releasedAt sql.NullTime
+		if err := rows.Scan(&h.ID, &h.CustomerID, &h.Reason, &h.PlacedBy, &h.PlacedAt, &releasedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler retenção legal"})
+			return
+		}
+		if releasedAt.Valid {
+			h.ReleasedAt = &releasedAt.Time
+		}
+		holds = append(holds, h)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"legal_holds": holds})
+}
+
+func placeLegalHold(c *gin.Context) {
+	var request struct {
+		CustomerID string `json:"customer_id" binding:"required"`
+		Reason     string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenantID := tenantFromContext(c)
+	actor := c.GetString("user_id")
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO legal_holds (tenant_id, customer_id, reason, placed_by)
+		SELECT $1, id, $3, $4 FROM customers WHERE id = $2 AND tenant_id = $1
+		RETURNING id`,
+		tenantID, request.CustomerID, request.Reason, actor,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar retenção legal"})
+		return
+	}
+
+	recordAudit(tenantID, actor, "legal_hold_placed", "customer", request.CustomerID, gin.H{"hold_id": id, "reason": request.Reason})
+	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Retenção legal registrada com sucesso"})
+}
+
+func releaseLegalHold(c *gin.Context) {
+	holdID := c.Param("hold_id")
+	actor := c.GetString("user_id")
+
+	var customerID string
+	err := db.QueryRow(`
+		UPDATE legal_holds SET released_at = CURRENT_TIMESTAMP, released_by = $3
+		WHERE id = $1 AND tenant_id = $2 AND released_at IS NULL
+		RETURNING customer_id`,
+		holdID, tenantFromContext(c), actor,
+	).Scan(&customerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Retenção legal não encontrada"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao liberar retenção legal"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), actor, "legal_hold_released", "customer", customerID, gin.H{"hold_id": holdID})
+	c.JSON(http.StatusOK, gin.H{"message": "Retenção legal liberada com sucesso"})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	salesForecastCacheDuration = time.Hour
+	salesForecastMaxSeries     = 20
+)
+
+// Configuração de cada granularidade: unidade do date_trunc, período
+// sazonal, histórico usado e limites de horizonte
+type forecastGranularity struct {
+	trunc          string
+	period         int
+	history        time.Duration
+	defaultHorizon int
+	maxHorizon     int
+	step           func(time.Time, int) time.Time
+}
+
+var forecastGranularities = map[string]forecastGranularity{
+	"day": {"day", 7, 2 * 365 * 24 * time.Hour, 30, 365, func(t time.Time, n int) time.Time {
+		return t.AddDate(0, 0, n)
+	}},
+	"week": {"week", 52, 4 * 365 * 24 * time.Hour, 12, 104, func(t time.Time, n int) time.Time {
+		return t.AddDate(0, 0, 7*n)
+	}},
+	"month": {"month", 12, 6 * 365 * 24 * time.Hour, 6, 24, func(t time.Time, n int) time.Time {
+		return t.AddDate(0, n, 0)
+	}},
+}
+
+// Expressão SQL de cada dimensão de quebra
+var forecastDimensions = map[string]string{
+	"":         "'total'",
+	"product":  "s.product_name",
+	"category": "COALESCE(s.category, 'sem categoria')",
+	"owner":    "COALESCE(c.owner_id::text, 'sem responsável')",
+	"region":   "COALESCE(s.region, 'sem região')",
+}
+
+func createSalesForecastTables() {
+	_, err := db.Exec(`
+		ALTER TABLE sales ADD COLUMN IF NOT EXISTS category VARCHAR(100);
+		ALTER TABLE sales ADD COLUMN IF NOT EXISTS region VARCHAR(50);
+
+		CREATE INDEX IF NOT EXISTS idx_sales_date ON sales (date);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type revenueSeries struct {
+	Key     string
+	Periods []time.Time
+	Values  []float64
+}
+
+// Receita por período e por grupo, com períodos sem venda preenchidos com
+// zero. Com maxSeries > 0, mantém apenas os maiores grupos por receita.
+func loadRevenueSeries(tenantID string, g forecastGranularity, by string, now time.Time, maxSeries int) ([]revenueSeries, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT %s AS key, date_trunc('%s', s.date) AS period, SUM(s.amount)
+		FROM sales s
+		JOIN customers c ON c.id = s.customer_id
+		WHERE c.tenant_id = $1 AND s.date >= $2 AND s.date < date_trunc('%s', $3::timestamp)
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, forecastDimensions[by], g.trunc, g.trunc),
+		tenantID, now.Add(-g.history), now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := map[string]float64{}
+	byKey := map[string]map[time.Time]float64{}
+	var first time.Time
+	for rows.Next() {
+		var key string
+		var period time.Time
+		var amount float64
+		if err := rows.Scan(&key, &period, &amount); err != nil {
+			return nil, err
+		}
+		if byKey[key] == nil {
+			byKey[key] = map[time.Time]float64{}
+		}
+		byKey[key][period] = amount
+		totals[key] += amount
+		if first.IsZero() || period.Before(first) {
+			first = period
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return totals[keys[i]] > totals[keys[j]] })
+	if maxSeries > 0 && len(keys) > maxSeries {
+		keys = keys[:maxSeries]
+	}
+
+	// Todas as séries compartilham o mesmo calendário, do primeiro período
+	// com venda até o último período completo
+	var periods []time.Time
+	end := truncatePeriod(now, g.trunc)
+	for t := first; !first.IsZero() && t.Before(end); t = g.step(t, 1) {
+		periods = append(periods, t)
+	}
+
+	series := make([]revenueSeries, len(keys))
+	for i, key := range keys {
+		values := make([]float64, len(periods))
+		for j, period := range periods {
+			values[j] = byKey[key][period]
+		}
+		series[i] = revenueSeries{Key: key, Periods: periods, Values: values}
+	}
+	return series, nil
+}
+
+// Equivalente em Go do date_trunc do Postgres para as granularidades usadas
+func truncatePeriod(t time.Time, unit string) time.Time {
+	y, m, d := t.Date()
+	switch unit {
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case "week":
+		day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		offset := (int(day.Weekday()) + 6) % 7 // semanas começam na segunda
+		return day.AddDate(0, 0, -offset)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+type forecastRequest struct {
+	granularity string
+	config      forecastGranularity
+	horizon     int
+	by          string
+}
+
+func parseForecastRequest(c *gin.Context) (forecastRequest, bool) {
+	req := forecastRequest{granularity: c.DefaultQuery("granularity", "month"), by: c.Query("by")}
+
+	config, ok := forecastGranularities[req.granularity]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity deve ser day, week ou month"})
+		return req, false
+	}
+	req.config = config
+
+	if _, ok := forecastDimensions[req.by]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "by deve ser product, category, owner ou region"})
+		return req, false
+	}
+
+	req.horizon = config.defaultHorizon
+	if value := c.Query("horizon"); value != "" {
+		horizon, err := strconv.Atoi(value)
+		if err != nil || horizon < 1 || horizon > config.maxHorizon {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("horizon deve estar entre 1 e %d", config.maxHorizon)})
+			return req, false
+		}
+		req.horizon = horizon
+	}
+	return req, true
+}
+
+// Calcula (ou busca no cache) as previsões de todas as séries do pedido
+func computeSalesForecast(tenantID string, req forecastRequest) ([]gin.H, error) {
+	key := fmt.Sprintf("sales_forecast:%s:%s:%d:%s", tenantID, req.granularity, req.horizon, req.by)
+	if value, found := globalCache.Get(key); found {
+		return value.([]gin.H), nil
+	}
+
+	series, err := loadRevenueSeries(tenantID, req.config, req.by, time.Now(), salesForecastMaxSeries)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []gin.H{}
+	for _, s := range series {
+		forecast, err := forecastSeries(s.Values, req.config.period, req.horizon)
+		if err != nil {
+			// Série sem histórico suficiente: não entra no resultado
+			continue
+		}
+
+		last := s.Periods[len(s.Periods)-1]
+		points := make([]gin.H, len(forecast.Forecast))
+		for i, p := range forecast.Forecast {
+			points[i] = gin.H{
+				"period":   req.config.step(last, i+1).Format("2006-01-02"),
+				"value":    p.Value,
+				"lower_80": p.Lower80,
+				"upper_80": p.Upper80,
+				"lower_95": p.Lower95,
+				"upper_95": p.Upper95,
+			}
+		}
+		results = append(results, gin.H{
+			"key":        s.Key,
+			"model":      forecast.Model,
+			"parameters": forecast.Parameters,
+			"backtest":   forecast.Backtest,
+			"history":    len(s.Values),
+			"forecast":   points,
+		})
+	}
+
+	globalCache.Set(key, results, salesForecastCacheDuration)
+	return results, nil
+}
+
+func getSalesForecast(c *gin.Context) {
+	req, ok := parseForecastRequest(c)
+	if !ok {
+		return
+	}
+
+	results, err := computeSalesForecast(tenantFromContext(c), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular previsão de vendas"})
+		return
+	}
+
+	series := make([]gin.H, len(results))
+	for i, r := range results {
+		series[i] = gin.H{"key": r["key"], "model": r["model"], "forecast": r["forecast"]}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"granularity": req.granularity,
+		"horizon":     req.horizon,
+		"by":          req.by,
+		"series":      series,
+	})
+}
+
+// Relatório de acurácia: métricas de backtest de todos os modelos
+// candidatos, por série, e o modelo escolhido
+func getSalesForecastBacktest(c *gin.Context) {
+	req, ok := parseForecastRequest(c)
+	if !ok {
+		return
+	}
+
+	results, err := computeSalesForecast(tenantFromContext(c), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao calcular backtest"})
+		return
+	}
+
+	series := make([]gin.H, len(results))
+	for i, r := range results {
+		series[i] = gin.H{
+			"key":            r["key"],
+			"selected_model": r["model"],
+			"parameters":     r["parameters"],
+			"history":        r["history"],
+			"candidates":     r["backtest"],
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"granularity": req.granularity,
+		"horizon":     req.horizon,
+		"by":          req.by,
+		"folds":       forecastBacktestFolds,
+		"series":      series,
+	})
+}
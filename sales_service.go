@@ -11,7 +11,6 @@ import (
 	"your-project/logger"
 	"your-project/validator"
 	"your-project/auth"
-	"math"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -32,39 +31,6 @@ type Sale struct {
 	Date        time.Time `json:"date"`
 }
 
-type ChurnModel struct{}
-
-func (m *ChurnModel) Predict(features []float64) float64 {
-	// Modelo simplificado de previsão de churn
-	// Baseado em: recência da última compra, frequência de compras e valor monetário
-	recency := features[0]
-	frequency := features[1]
-	monetary := features[2]
-
-	score := 0.3*recency + 0.3*frequency + 0.4*monetary
-	return 1 / (1 + math.Exp(-score))
-}
-
-var churnModel = &ChurnModel{}
-
-func predictCustomerChurn(customerID string) float64 {
-	// Obter dados do cliente
-	customerData := getCustomerData(customerID)
-
-	// Extrair features
-	recency := float64(customerData["days_since_last_purchase"].(int))
-	frequency := float64(customerData["total_purchases"].(int)) / float64(customerData["months_active"].(int))
-	monetary := customerData["average_purchase_value"].(float64)
-
-	// Normalizar features (simplificado)
-	recency = recency / 365 // Assumindo que 365 dias é o máximo
-	frequency = frequency / 10 // Assumindo que 10 compras por mês é o máximo
-	monetary = monetary / 1000 // Assumindo que $1000 é o valor máximo de compra
-
-	features := []float64{recency, frequency, monetary}
-	return churnModel.Predict(features)
-}
-
 func setupCustomerRoutes(r *gin.Engine) {
 	customerGroup := r.Group("/customers")
 	customerGroup.Use(AuthMiddleware())
@@ -171,11 +137,33 @@ func createSale(c *gin.Context) {
 		return
 	}
 
-	newSale.ID = uuid.New().String()
 	newSale.Date = time.Now()
+	tenantID := tenantFromContext(c)
+
+	// A venda só é aceita para clientes do tenant
+	err := db.QueryRow(`
+		INSERT INTO sales (customer_id, product_name, amount, date)
+		SELECT id, $3, $4, $5 FROM customers WHERE id::text = $1 AND tenant_id = $2
+		RETURNING id::text`,
+		newSale.CustomerID, tenantID, newSale.ProductName, newSale.Amount, newSale.Date,
+	).Scan(&newSale.ID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cliente não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar venda"})
+		return
+	}
 
-	// Aqui você deve salvar a venda no banco de dados
-	// Por enquanto, vamos apenas retornar a venda criada
+	// Só vendas gravadas entram nas métricas em tempo real
+	metricsAggregator.Record(DomainEvent{
+		Type:     eventSaleCreated,
+		TenantID: tenantID,
+		UserID:   c.GetString("user_id"),
+		Amount:   newSale.Amount,
+		At:       newSale.Date,
+	})
 	c.JSON(http.StatusCreated, newSale)
 }
 
@@ -220,61 +208,15 @@ func listSales(c *gin.Context) {
 
 func getChurnPrediction(c *gin.Context) {
 	customerID := c.Param("id")
-	churnProbability := predictCustomerChurn(customerID)
-
-	c.JSON(http.StatusOK, gin.H{
-		"customer_id":        customerID,
-		"churn_probability":  churnProbability,
-	})
-}
-
-func getCustomerData(customerID string) map[string]interface{} {
-	// Implementação simplificada para obter dados do cliente
-	// Aqui você deve buscar os dados do cliente no banco de dados
-	// Por enquanto, vamos retornar dados de exemplo
-	return map[string]interface{}{
-		"days_since_last_purchase": 30,
-		"total_purchases":          10,
-		"months_active":            12,
-		"average_purchase_value":  100.0,
-	}
-}
-
-func getTotalCustomers() int {
-	// Implementação real: consultar banco de dados
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count)
+	score, err := predictCustomerChurn(customerID)
 	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao contar clientes: %v", err)
-		return 0
-	}
-	return count
-}
-
-func getActiveCustomers() int {
-	// Implementação real: consultar banco de dados
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM customers WHERE last_activity > $1", time.Now().AddDate(0, -1, 0)).Scan(&count)
-	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao contar clientes ativos: %v", err)
-		return 0
+		c.JSON(churnErrorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
-	return count
-}
 
-func getTotalRevenue() float64 {
-	// Implementação real: consultar banco de dados
-	var total float64
-	err := db.QueryRow("SELECT SUM(amount) FROM sales").Scan(&total)
-	if err != nil {
-		logger.ErrorLogger.Printf("Erro ao calcular receita total: %v", err)
-		return 0
-	}
-	return total
+	c.JSON(http.StatusOK, score)
 }
 
-func getSalesTrend() []gin.H {
-	// Implementação real: consultar banco de dados
 package main
 
 import (
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	maxDashboardWidgets      = 24
+	maxParallelWidgets       = 6
+	defaultWidgetRangeDays   = 30
+	defaultWidgetTopN        = 5
+	maxWidgetTopN            = 50
+	widgetEvaluationErrorMsg = "Falha ao calcular widget"
+)
+
+// Métricas aceitas por tipo de widget. KPIs usam os indicadores do resumo
+// do dashboard e funis usam as definições de funnelDefinitions.
+var widgetMetrics = map[string]map[string]bool{
+	"kpi":           {},
+	"time_series":   {"revenue": true, "orders": true, "new_customers": true},
+	"top_n":         {"products": true, "customers": true},
+	"funnel":        {},
+	"segment_count": {"": true},
+}
+
+func init() {
+	for metric := range (dashboardMetrics{}).values() {
+		widgetMetrics["kpi"][metric] = true
+	}
+	for funnelType := range funnelDefinitions {
+		widgetMetrics["funnel"][funnelType] = true
+	}
+}
+
+// Séries temporais dos widgets. Parâmetros além do escopo: $7 granularidade
+var widgetSeriesQueries = map[string]string{
+	"revenue": `SELECT date_trunc($7, date), SUM(amount) FROM sales
+		WHERE customer_id IN (SELECT id FROM scope) AND date >= $2 AND date < $3 GROUP BY 1`,
+	"orders": `SELECT date_trunc($7, date), COUNT(*) FROM sales
+		WHERE customer_id IN (SELECT id FROM scope) AND date >= $2 AND date < $3 GROUP BY 1`,
+	"new_customers": `SELECT date_trunc($7, created_at), COUNT(*) FROM scope
+		WHERE created_at >= $2 AND created_at < $3 GROUP BY 1`,
+}
+
+type WidgetLayout struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// Widget salvo em um dashboard. O período é absoluto (from/to) ou relativo
+// aos últimos range_days dias; owner "me" se refere a quem visualiza.
+type DashboardWidget struct {
+	ID          string       `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Metric      string       `json:"metric"`
+	From        string       `json:"from,omitempty"`
+	To          string       `json:"to,omitempty"`
+	RangeDays   *int         `json:"range_days,omitempty" graphql:"rangeDays"`
+	Compare     string       `json:"compare,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+	Segment     string       `json:"segment,omitempty"`
+	Granularity string       `json:"granularity,omitempty"`
+	Limit       int          `json:"limit,omitempty"`
+	Layout      WidgetLayout `json:"layout"`
+}
+
+func (w DashboardWidget) filters(userID string) (DashboardFilters, error) {
+	from := w.From
+	if from == "" && w.RangeDays != nil {
+		to := truncatePeriod(time.Now(), "day")
+		if w.To != "" {
+			parsed, err := time.Parse("2006-01-02", w.To)
+			if err != nil {
+				return DashboardFilters{}, fmt.Errorf("to deve estar no formato AAAA-MM-DD")
+			}
+			to = parsed
+		}
+		from = to.AddDate(0, 0, -(*w.RangeDays - 1)).Format("2006-01-02")
+	}
+	return newDashboardFilters(from, w.To, w.Compare, w.Owner, w.Segment, userID)
+}
+
+func (w *DashboardWidget) validate() string {
+	metrics, ok := widgetMetrics[w.Type]
+	if !ok {
+		return "Tipo de widget inválido: " + w.Type
+	}
+	if !metrics[w.Metric] {
+		return fmt.Sprintf("Métrica inválida para widget %s: %s", w.Type, w.Metric)
+	}
+	// Ponteiro para distinguir range_days ausente (padrão) de zero (inválido)
+	if w.RangeDays != nil && (*w.RangeDays < 1 || *w.RangeDays > dashboardMaxDays) {
+		return fmt.Sprintf("range_days deve estar entre 1 e %d", dashboardMaxDays)
+	}
+	if w.From == "" && w.RangeDays == nil {
+		days := defaultWidgetRangeDays
+		w.RangeDays = &days
+	}
+	if _, err := w.filters(""); err != nil {
+		return err.Error()
+	}
+	if w.Type == "time_series" {
+		if w.Granularity == "" {
+			w.Granularity = "day"
+		}
+		if _, ok := forecastGranularities[w.Granularity]; !ok {
+			return "granularity deve ser day, week ou month"
+		}
+	}
+	if w.Type == "top_n" {
+		if w.Limit == 0 {
+			w.Limit = defaultWidgetTopN
+		}
+		if w.Limit < 1 || w.Limit > maxWidgetTopN {
+			return fmt.Sprintf("limit deve estar entre 1 e %d", maxWidgetTopN)
+		}
+	}
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return ""
+}
+
+func validateWidgets(widgets []DashboardWidget) string {
+	if len(widgets) > maxDashboardWidgets {
+		return fmt.Sprintf("Um dashboard pode ter no máximo %d widgets", maxDashboardWidgets)
+	}
+	seen := map[string]bool{}
+	for i := range widgets {
+		if problem := widgets[i].validate(); problem != "" {
+			return problem
+		}
+		if seen[widgets[i].ID] {
+			return "ID de widget repetido: " + widgets[i].ID
+		}
+		seen[widgets[i].ID] = true
+	}
+	return ""
+}
+
+type SavedDashboard struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	OwnerID     string            `json:"owner_id" graphql:"ownerId"`
+	TeamID      *int              `json:"team_id" graphql:"teamId"`
+	ClonedFrom  *int              `json:"cloned_from" graphql:"clonedFrom"`
+	Widgets     []DashboardWidget `json:"widgets"`
+	SharedWith  []string          `json:"shared_with" graphql:"sharedWith"`
+	Editable    bool              `json:"editable"`
+	CreatedAt   time.Time         `json:"created_at" graphql:"createdAt"`
+	UpdatedAt   time.Time         `json:"updated_at" graphql:"updatedAt"`
+}
+
+type WidgetResult struct {
+	Widget DashboardWidget `json:"widget"`
+	Data   interface{}     `json:"data"`
+	Error  string          `json:"error,omitempty"`
+}
+
+func createSavedDashboardTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS dashboards (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			name VARCHAR(100) NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			owner_id INTEGER NOT NULL,
+			team_id INTEGER REFERENCES teams(id) ON DELETE SET NULL,
+			cloned_from INTEGER REFERENCES dashboards(id) ON DELETE SET NULL,
+			widgets JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS dashboard_shares (
+			dashboard_id INTEGER NOT NULL REFERENCES dashboards(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (dashboard_id, user_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dashboards_tenant_owner ON dashboards (tenant_id, owner_id);
+		CREATE INDEX IF NOT EXISTS idx_dashboard_shares_user ON dashboard_shares (user_id);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupSavedDashboardRoutes(r *gin.Engine) {
+	dashboards := r.Group("/dashboards")
+	dashboards.Use(AuthMiddleware())
+	{
+		dashboards.POST("", createSavedDashboard)
+		dashboards.GET("", listSavedDashboards)
+		dashboards.GET("/:id", getSavedDashboard)
+		dashboards.PUT("/:id", updateSavedDashboard)
+		dashboards.DELETE("/:id", deleteSavedDashboard)
+		dashboards.GET("/:id/data", getSavedDashboardData)
+		dashboards.PUT("/:id/shares", setSavedDashboardShares)
+		dashboards.POST("/:id/clone", cloneSavedDashboard)
+	}
+}
+
+// Colunas e condição de acesso das consultas de dashboards; $1 é o tenant
+// e $2 o usuário. Um dashboard é visível ao dono, a quem ele foi
+// compartilhado e aos membros e gestor do time; só dono e gestor editam.
+const (
+	savedDashboardColumns = `d.id, d.name, d.description, d.owner_id::text, d.team_id, d.cloned_from, d.widgets,
+		COALESCE((SELECT array_agg(s.user_id::text ORDER BY s.user_id) FROM dashboard_shares s WHERE s.dashboard_id = d.id), '{}'),
+		d.owner_id::text = $2 OR EXISTS (SELECT 1 FROM teams t WHERE t.id = d.team_id AND t.manager_id::text = $2),
+		d.created_at, d.updated_at`
+	savedDashboardAccess = `d.tenant_id = $1 AND (
+		d.owner_id::text = $2
+		OR EXISTS (SELECT 1 FROM dashboard_shares s WHERE s.dashboard_id = d.id AND s.user_id::text = $2)
+		OR EXISTS (SELECT 1 FROM teams t WHERE t.id = d.team_id AND t.manager_id::text = $2)
+		OR EXISTS (SELECT 1 FROM team_members m WHERE m.team_id = d.team_id AND m.user_id::text = $2))`
+)
+
+func scanSavedDashboard(row interface{ Scan(...interface{}) error }) (SavedDashboard, error) {
+	var d SavedDashboard
+	var teamID, clonedFrom sql.NullInt64
+	var widgets []byte
+	err := row.Scan(&d.ID, &d.Name, &d.Description, &d.OwnerID, &teamID, &clonedFrom, &widgets,
+		pq.Array(&d.SharedWith), &d.Editable, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return d, err
+	}
+	if teamID.Valid {
+		id := int(teamID.Int64)
+		d.TeamID = &id
+	}
+	if clonedFrom.Valid {
+		id := int(clonedFrom.Int64)
+		d.ClonedFrom = &id
+	}
+	if err := json.Unmarshal(widgets, &d.Widgets); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+func listSavedDashboardsFor(tenantID, userID string) ([]SavedDashboard, error) {
+	rows, err := db.Query(
+		"SELECT "+savedDashboardColumns+" FROM dashboards d WHERE "+savedDashboardAccess+" ORDER BY d.name",
+		tenantID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dashboards := []SavedDashboard{}
+	for rows.Next() {
+		d, err := scanSavedDashboard(rows)
+		if err != nil {
+			return nil, err
+		}
+		dashboards = append(dashboards, d)
+	}
+	return dashboards, rows.Err()
+}
+
+// Dashboard visível ao usuário; sql.ErrNoRows se não existir ou não for
+// acessível
+func loadSavedDashboard(tenantID, userID, id string) (SavedDashboard, error) {
+	return scanSavedDashboard(db.QueryRow(
+		"SELECT "+savedDashboardColumns+" FROM dashboards d WHERE d.id::text = $3 AND "+savedDashboardAccess,
+		tenantID, userID, id,
+	))
+}
+
+func getTopCustomers(tenantID string, f DashboardFilters, segmentIDs []string, limit int) ([]gin.H, error) {
+	rows, err := db.Query(`
+		WITH`+dashboardScopeCTE+`
+		SELECT c.id::text, c.name, COUNT(*), SUM(s.amount)
+		FROM sales s
+		JOIN customers c ON c.id = s.customer_id
+		WHERE s.customer_id IN (SELECT id FROM scope) AND s.date >= $2 AND s.date < $3
+		GROUP BY c.id, c.name
+		ORDER BY 4 DESC
+		LIMIT $7`,
+		tenantID, f.From.UTC(), f.To.UTC(), f.Owner, f.Segment, pq.Array(segmentIDs), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []gin.H{}
+	for rows.Next() {
+		var id, name string
+		var sales int
+		var revenue float64
+		if err := rows.Scan(&id, &name, &sales, &revenue); err != nil {
+			return nil, err
+		}
+		customers = append(customers, gin.H{"id": id, "name": name, "sales": sales, "revenue": revenue})
+	}
+	return customers, rows.Err()
+}
+
+// Série da métrica agregada por granularidade, com períodos vazios zerados
+func getWidgetSeries(tenantID string, f DashboardFilters, segmentIDs []string, metric, granularity string) ([]gin.H, error) {
+	rows, err := db.Query(
+		"WITH"+dashboardScopeCTE+"\n"+widgetSeriesQueries[metric],
+		tenantID, f.From.UTC(), f.To.UTC(), f.Owner, f.Segment, pq.Array(segmentIDs), granularity,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byPeriod := map[string]float64{}
+	for rows.Next() {
+		var period time.Time
+		var value float64
+		if err := rows.Scan(&period, &value); err != nil {
+			return nil, err
+		}
+		byPeriod[period.Format("2006-01-02")] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	g := forecastGranularities[granularity]
+	series := []gin.H{}
+	for period := truncatePeriod(f.From, granularity); period.Before(f.To); period = g.step(period, 1) {
+		date := period.Format("2006-01-02")
+		series = append(series, gin.H{"date": date, "value": byPeriod[date]})
+	}
+	return series, nil
+}
+
+func evaluateWidget(tenantID, userID string, w DashboardWidget) (interface{}, error) {
+	f, err := w.filters(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch w.Type {
+	case "kpi":
+		summary, err := getDashboardSummary(tenantID, f)
+		if err != nil {
+			return nil, err
+		}
+		return summary[w.Metric], nil
+	case "funnel":
+		return computeTenantFunnel(tenantID, w.Metric, funnelScope{From: f.From, To: f.To, Owner: f.Owner})
+	case "segment_count":
+		analysis, err := computeRFMAnalysis(tenantID)
+		if err != nil {
+			return nil, err
+		}
+		segments := []RFMSegmentSummary{}
+		for _, s := range analysis.Segments {
+			if w.Segment == "" || s.Segment == w.Segment {
+				segments = append(segments, s)
+			}
+		}
+		return segments, nil
+	}
+
+	segmentIDs, err := segmentCustomerIDs(tenantID, f.Segment)
+	if err != nil {
+		return nil, err
+	}
+	if w.Type == "time_series" {
+		return getWidgetSeries(tenantID, f, segmentIDs, w.Metric, w.Granularity)
+	}
+	if w.Metric == "customers" {
+		return getTopCustomers(tenantID, f, segmentIDs, w.Limit)
+	}
+	return getTopProducts(tenantID, f, segmentIDs, w.Limit)
+}
+
+// Calcula os widgets em paralelo (no máximo maxParallelWidgets por vez).
+// A falha de um widget não impede os demais de serem retornados.
+func evaluateDashboard(tenantID, userID string, widgets []DashboardWidget) []WidgetResult {
+	results := make([]WidgetResult, len(widgets))
+	slots := make(chan struct{}, maxParallelWidgets)
+	var wg sync.WaitGroup
+
+	for i, widget := range widgets {
+		wg.Add(1)
+		go func(i int, widget DashboardWidget) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i].Widget = widget
+			data, err := evaluateWidget(tenantID, userID, widget)
+			if err != nil {
+				logger.Errorf("Erro ao calcular widget %s (%s): %v", widget.ID, widget.Type, err)
+				results[i].Error = widgetEvaluationErrorMsg
+				return
+			}
+			results[i].Data = data
+		}(i, widget)
+	}
+	wg.Wait()
+	return results
+}
+
+func savedDashboardError(c *gin.Context, err error, message string) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dashboard não encontrado"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+type savedDashboardInput struct {
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description"`
+	TeamID      *int              `json:"team_id"`
+	Widgets     []DashboardWidget `json:"widgets"`
+}
+
+// Valida os widgets e, para dashboards de time, se o usuário pertence ao
+// time. Retorna o status e a mensagem do problema encontrado.
+func (in *savedDashboardInput) validate(tenantID, userID string) (int, string) {
+	if in.Widgets == nil {
+		in.Widgets = []DashboardWidget{}
+	}
+	if problem := validateWidgets(in.Widgets); problem != "" {
+		return http.StatusBadRequest, problem
+	}
+	if in.TeamID != nil {
+		member, err := belongsToTeam(tenantID, *in.TeamID, userID)
+		if err != nil {
+			return http.StatusInternalServerError, "Falha ao verificar time"
+		}
+		if !member {
+			return http.StatusForbidden, "Você não faz parte desse time"
+		}
+	}
+	return 0, ""
+}
+
+func createSavedDashboard(c *gin.Context) {
+	var in savedDashboardInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+	if status, problem := in.validate(tenantID, userID); problem != "" {
+		c.JSON(status, gin.H{"error": problem})
+		return
+	}
+
+	widgets, _ := json.Marshal(in.Widgets)
+	var id int
+	err := db.QueryRow(
+		"INSERT INTO dashboards (tenant_id, name, description, owner_id, team_id, widgets) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tenantID, in.Name, in.Description, userID, in.TeamID, widgets,
+	).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar dashboard"})
+		return
+	}
+
+	dashboard, err := loadSavedDashboard(tenantID, userID, strconv.Itoa(id))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	c.JSON(http.StatusCreated, dashboard)
+}
+
+func listSavedDashboards(c *gin.Context) {
+	dashboards, err := listSavedDashboardsFor(tenantFromContext(c), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar dashboards"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dashboards": dashboards})
+}
+
+func getSavedDashboard(c *gin.Context) {
+	dashboard, err := loadSavedDashboard(tenantFromContext(c), c.GetString("user_id"), c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	c.JSON(http.StatusOK, dashboard)
+}
+
+func updateSavedDashboard(c *gin.Context) {
+	var in savedDashboardInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+
+	current, err := loadSavedDashboard(tenantID, userID, c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	if !current.Editable {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Apenas o dono ou o gestor do time podem editar o dashboard"})
+		return
+	}
+	if status, problem := in.validate(tenantID, userID); problem != "" {
+		c.JSON(status, gin.H{"error": problem})
+		return
+	}
+
+	widgets, _ := json.Marshal(in.Widgets)
+	if _, err := db.Exec(
+		"UPDATE dashboards SET name = $3, description = $4, team_id = $5, widgets = $6, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2",
+		current.ID, tenantID, in.Name, in.Description, in.TeamID, widgets,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar dashboard"})
+		return
+	}
+
+	dashboard, err := loadSavedDashboard(tenantID, userID, c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	c.JSON(http.StatusOK, dashboard)
+}
+
+func deleteSavedDashboard(c *gin.Context) {
+	result, err := db.Exec(
+		"DELETE FROM dashboards WHERE id::text = $1 AND tenant_id = $2 AND owner_id::text = $3",
+		c.Param("id"), tenantFromContext(c), c.GetString("user_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao excluir dashboard"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dashboard não encontrado"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Dashboard excluído com sucesso"})
+}
+
+// Dashboard com todos os widgets calculados em uma única resposta
+func getSavedDashboardData(c *gin.Context) {
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+	dashboard, err := loadSavedDashboard(tenantID, userID, c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard":    dashboard,
+		"widgets":      evaluateDashboard(tenantID, userID, dashboard.Widgets),
+		"evaluated_at": time.Now(),
+	})
+}
+
+// Substitui a lista de usuários com quem o dashboard é compartilhado.
+// Apenas o dono pode compartilhar; quem recebe tem acesso de leitura.
+func setSavedDashboardShares(c *gin.Context) {
+	var request struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+
+	current, err := loadSavedDashboard(tenantID, userID, c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	if current.OwnerID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Apenas o dono pode compartilhar o dashboard"})
+		return
+	}
+
+	exist, err := tenantUsersExist(tenantID, request.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao validar usuários"})
+		return
+	}
+	if !exist {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usuários não encontrados"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao compartilhar dashboard"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM dashboard_shares WHERE dashboard_id = $1", current.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao compartilhar dashboard"})
+		return
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO dashboard_shares (dashboard_id, user_id) SELECT $1, unnest($2::integer[]) ON CONFLICT DO NOTHING",
+		current.ID, pq.Array(request.UserIDs),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao compartilhar dashboard"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao compartilhar dashboard"})
+		return
+	}
+
+	recordAudit(tenantID, userID, "dashboard_shared", "dashboard", strconv.Itoa(current.ID), gin.H{"user_ids": request.UserIDs})
+	c.JSON(http.StatusOK, gin.H{"message": "Compartilhamento atualizado com sucesso"})
+}
+
+// Cria uma cópia privada de um dashboard visível ao usuário
+func cloneSavedDashboard(c *gin.Context) {
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tenantID, userID := tenantFromContext(c), c.GetString("user_id")
+
+	source, err := loadSavedDashboard(tenantID, userID, c.Param("id"))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	if request.Name == "" {
+		request.Name = source.Name + " (cópia)"
+	}
+	for i := range source.Widgets {
+		source.Widgets[i].ID = uuid.New().String()
+	}
+
+	widgets, _ := json.Marshal(source.Widgets)
+	var id int
+	err = db.QueryRow(
+		"INSERT INTO dashboards (tenant_id, name, description, owner_id, cloned_from, widgets) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		tenantID, request.Name, source.Description, userID, source.ID, widgets,
+	).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao clonar dashboard"})
+		return
+	}
+
+	dashboard, err := loadSavedDashboard(tenantID, userID, strconv.Itoa(id))
+	if err != nil {
+		savedDashboardError(c, err, "Falha ao buscar dashboard")
+		return
+	}
+	c.JSON(http.StatusCreated, dashboard)
+}
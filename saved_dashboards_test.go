@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func widgetRangeDays(days int) *int {
+	return &days
+}
+
+func TestValidateWidgets(t *testing.T) {
+	widgets := []DashboardWidget{
+		{Type: "kpi", Metric: "totalRevenue", Compare: "previous_period"},
+		{Type: "time_series", Metric: "orders", RangeDays: widgetRangeDays(90), Granularity: "week"},
+		{Type: "top_n", Metric: "customers"},
+		{Type: "funnel", Metric: "opportunity"},
+		{Type: "segment_count", Segment: "champions"},
+	}
+	assert.Empty(t, validateWidgets(widgets))
+
+	// Padrões preenchidos na validação
+	assert.NotEmpty(t, widgets[0].ID)
+	assert.Equal(t, defaultWidgetRangeDays, *widgets[0].RangeDays)
+	assert.Equal(t, defaultWidgetTopN, widgets[2].Limit)
+
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{{Type: "gauge", Metric: "totalRevenue"}}))
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{{Type: "kpi", Metric: "orders", RangeDays: widgetRangeDays(0)}}))
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{{Type: "kpi", Metric: "revenue"}}))
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{{Type: "time_series", Metric: "revenue", Granularity: "hour"}}))
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{{Type: "top_n", Metric: "products", Limit: 500}}))
+	assert.NotEmpty(t, validateWidgets([]DashboardWidget{
+		{ID: "a", Type: "kpi", Metric: "orders"},
+		{ID: "a", Type: "kpi", Metric: "churnRate"},
+	}))
+}
+
+func TestWidgetFilters(t *testing.T) {
+	w := DashboardWidget{Type: "kpi", Metric: "orders", RangeDays: widgetRangeDays(7), To: "2026-09-30", Owner: "me"}
+	f, err := w.filters("7")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 9, 24, 0, 0, 0, 0, time.UTC), f.From)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), f.To)
+	assert.Equal(t, "7", f.Owner)
+}
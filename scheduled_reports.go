@@ -0,0 +1,812 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	maxReportRecipients     = 50
+	defaultReportRangeDays  = 7
+	defaultReportListLimit  = 1000
+	maxReportListLimit      = 10000
+	reportDueBatchSize      = 50
+	reportDeliveryPageLimit = 100
+)
+
+var reportKinds = map[string]bool{
+	"metric":    true,
+	"dashboard": true,
+	"list":      true,
+}
+
+// Exportações de listas. Parâmetros: $1 tenant, $2 responsável (vazio para
+// todos) e $3 limite. Dados pessoais sensíveis (e-mail, telefone,
+// documento) não são exportados por e-mail.
+var reportListQueries = map[string]struct {
+	Columns []string
+	Query   string
+}{
+	"customers": {
+		[]string{"id", "name", "lifecycle_stage", "owner_id", "created_at"},
+		`SELECT id::text, name, lifecycle_stage, COALESCE(owner_id::text, ''), to_char(created_at, 'YYYY-MM-DD')
+		FROM customers
+		WHERE tenant_id = $1 AND ($2 = '' OR owner_id::text = $2)
+		ORDER BY id LIMIT $3`,
+	},
+	"opportunities": {
+		[]string{"id", "name", "customer_id", "owner_id", "amount", "stage", "forecast_category", "expected_close_date"},
+		`SELECT id::text, name, COALESCE(customer_id::text, ''), owner_id::text, amount::text, stage, forecast_category,
+			COALESCE(to_char(expected_close_date, 'YYYY-MM-DD'), '')
+		FROM opportunities
+		WHERE tenant_id = $1 AND ($2 = '' OR owner_id::text = $2)
+		ORDER BY expected_close_date NULLS LAST, id LIMIT $3`,
+	},
+	"sales": {
+		[]string{"id", "customer_id", "product_name", "amount", "date"},
+		`SELECT s.id::text, s.customer_id::text, s.product_name, s.amount::text, to_char(s.date, 'YYYY-MM-DD')
+		FROM sales s
+		JOIN customers c ON c.id = s.customer_id
+		WHERE c.tenant_id = $1 AND ($2 = '' OR c.owner_id::text = $2)
+		ORDER BY s.date DESC LIMIT $3`,
+	},
+}
+
+// Parâmetros de cada tipo de relatório. metric: indicadores do resumo do
+// dashboard nos últimos range_days dias fechados; dashboard: snapshot de um
+// dashboard salvo; list: exportação de customers, opportunities ou sales.
+type ReportConfig struct {
+	Metrics     []string `json:"metrics,omitempty"`
+	RangeDays   int      `json:"range_days,omitempty"`
+	Compare     string   `json:"compare,omitempty"`
+	Owner       string   `json:"owner,omitempty"`
+	Segment     string   `json:"segment,omitempty"`
+	DashboardID int      `json:"dashboard_id,omitempty"`
+	Entity      string   `json:"entity,omitempty"`
+	Limit       int      `json:"limit,omitempty"`
+}
+
+type ReportDefinition struct {
+	ID         int          `json:"id"`
+	OwnerID    string       `json:"owner_id"`
+	Name       string       `json:"name"`
+	Kind       string       `json:"kind"`
+	Config     ReportConfig `json:"config"`
+	Schedule   string       `json:"schedule"`
+	Timezone   string       `json:"timezone"`
+	Recipients []string     `json:"recipients"`
+	Format     string       `json:"format"`
+	Enabled    bool         `json:"enabled"`
+	NextRunAt  *time.Time   `json:"next_run_at"`
+	LastRunAt  *time.Time   `json:"last_run_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+	tenantID   string
+}
+
+type ReportDelivery struct {
+	ID              int        `json:"id"`
+	ReportID        int        `json:"report_id"`
+	Trigger         string     `json:"trigger"`
+	Status          string     `json:"status"`
+	Format          string     `json:"format"`
+	Recipients      []string   `json:"recipients"`
+	AttachmentBytes int        `json:"attachment_bytes"`
+	Error           *string    `json:"error"`
+	StartedAt       time.Time  `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+}
+
+func createScheduledReportTables() {
+	// Horários gravados em UTC
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS report_definitions (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			owner_id INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			kind VARCHAR(20) NOT NULL,
+			config JSONB NOT NULL DEFAULT '{}',
+			schedule VARCHAR(100) NOT NULL,
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			recipients TEXT[] NOT NULL,
+			format VARCHAR(10) NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			next_run_at TIMESTAMP,
+			last_run_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_report_definitions_due ON report_definitions (next_run_at) WHERE enabled;
+
+		CREATE TABLE IF NOT EXISTS report_deliveries (
+			id SERIAL PRIMARY KEY,
+			report_id INTEGER NOT NULL REFERENCES report_definitions(id) ON DELETE CASCADE,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			trigger VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			format VARCHAR(10) NOT NULL,
+			recipients TEXT[] NOT NULL,
+			attachment_bytes INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_report_deliveries_report ON report_deliveries (report_id, started_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_report_deliveries_tenant_status ON report_deliveries (tenant_id, status, started_at DESC);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupReportRoutes(r *gin.Engine) {
+	reports := r.Group("/reports")
+	reports.Use(AuthMiddleware())
+	{
+		reports.POST("", createReportDefinition)
+		reports.GET("", listReportDefinitions)
+		reports.GET("/deliveries", listReportDeliveries)
+		reports.POST("/query", postReportQuery)
+		reports.GET("/:id", getReportDefinition)
+		reports.PUT("/:id", updateReportDefinition)
+		reports.DELETE("/:id", deleteReportDefinition)
+		reports.POST("/:id/run", runReportNow)
+		reports.GET("/:id/deliveries", listReportDeliveries)
+	}
+}
+
+const reportDefinitionColumns = `id, tenant_id, owner_id::text, name, kind, config, schedule, timezone, recipients, format,
+	enabled, next_run_at, last_run_at, created_at, updated_at`
+
+func scanReportDefinition(row interface{ Scan(...interface{}) error }) (ReportDefinition, error) {
+	var def ReportDefinition
+	var config []byte
+	var nextRunAt, lastRunAt sql.NullTime
+	err := row.Scan(&def.ID, &def.tenantID, &def.OwnerID, &def.Name, &def.Kind, &config, &def.Schedule, &def.Timezone,
+		pq.Array(&def.Recipients), &def.Format, &def.Enabled, &nextRunAt, &lastRunAt, &def.CreatedAt, &def.UpdatedAt)
+	if err != nil {
+		return def, err
+	}
+	if nextRunAt.Valid {
+		def.NextRunAt = &nextRunAt.Time
+	}
+	if lastRunAt.Valid {
+		def.LastRunAt = &lastRunAt.Time
+	}
+	return def, json.Unmarshal(config, &def.Config)
+}
+
+const reportDeliveryColumns = `id, report_id, trigger, status, format, recipients, attachment_bytes, error, started_at, finished_at`
+
+func scanReportDelivery(row interface{ Scan(...interface{}) error }) (ReportDelivery, error) {
+	var d ReportDelivery
+	var finishedAt sql.NullTime
+	err := row.Scan(&d.ID, &d.ReportID, &d.Trigger, &d.Status, &d.Format, pq.Array(&d.Recipients),
+		&d.AttachmentBytes, &d.Error, &d.StartedAt, &finishedAt)
+	if finishedAt.Valid {
+		d.FinishedAt = &finishedAt.Time
+	}
+	return d, err
+}
+
+type reportDefinitionInput struct {
+	Name       string       `json:"name" binding:"required"`
+	Kind       string       `json:"kind" binding:"required"`
+	Config     ReportConfig `json:"config"`
+	Schedule   string       `json:"schedule" binding:"required"`
+	Timezone   string       `json:"timezone"`
+	Recipients []string     `json:"recipients" binding:"required"`
+	Format     string       `json:"format" binding:"required"`
+	Enabled    *bool        `json:"enabled"`
+}
+
+// Valida a definição e calcula a próxima execução a partir de now.
+// Retorna a mensagem do problema encontrado, se houver.
+func (in *reportDefinitionInput) validate(now time.Time) (time.Time, string) {
+	if !reportKinds[in.Kind] {
+		return time.Time{}, "kind deve ser metric, dashboard ou list"
+	}
+	if _, ok := reportFormats[in.Format]; !ok {
+		return time.Time{}, "format deve ser csv, html ou pdf"
+	}
+	if len(in.Recipients) == 0 || len(in.Recipients) > maxReportRecipients {
+		return time.Time{}, fmt.Sprintf("Informe entre 1 e %d destinatários", maxReportRecipients)
+	}
+	for _, recipient := range in.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return time.Time{}, "Destinatário inválido: " + recipient
+		}
+	}
+	if problem := in.Config.validate(in.Kind); problem != "" {
+		return time.Time{}, problem
+	}
+
+	if in.Timezone == "" {
+		in.Timezone = "UTC"
+	}
+	location, err := time.LoadLocation(in.Timezone)
+	if err != nil {
+		return time.Time{}, "Fuso horário inválido: " + in.Timezone
+	}
+	schedule, err := parseCron(in.Schedule, location)
+	if err != nil {
+		return time.Time{}, err.Error()
+	}
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return time.Time{}, "A agenda não tem nenhuma ocorrência futura"
+	}
+	return next, ""
+}
+
+func (cfg *ReportConfig) validate(kind string) string {
+	switch kind {
+	case "metric":
+		kpis := (dashboardMetrics{}).values()
+		for _, metric := range cfg.Metrics {
+			if _, ok := kpis[metric]; !ok {
+				return "Métrica inválida: " + metric
+			}
+		}
+		if cfg.RangeDays == 0 {
+			cfg.RangeDays = defaultReportRangeDays
+		}
+		if cfg.RangeDays < 1 || cfg.RangeDays > dashboardMaxDays {
+			return fmt.Sprintf("range_days deve estar entre 1 e %d", dashboardMaxDays)
+		}
+		if _, err := cfg.filters(time.Now(), ""); err != nil {
+			return err.Error()
+		}
+	case "dashboard":
+		if cfg.DashboardID == 0 {
+			return "dashboard_id é obrigatório"
+		}
+	case "list":
+		if _, ok := reportListQueries[cfg.Entity]; !ok {
+			return "entity deve ser customers, opportunities ou sales"
+		}
+		if cfg.Limit == 0 {
+			cfg.Limit = defaultReportListLimit
+		}
+		if cfg.Limit < 1 || cfg.Limit > maxReportListLimit {
+			return fmt.Sprintf("limit deve estar entre 1 e %d", maxReportListLimit)
+		}
+	}
+	return ""
+}
+
+// Últimos range_days dias completos antes de runAt, no fuso do relatório:
+// um relatório semanal na segunda-feira cobre a semana anterior.
+func (cfg ReportConfig) filters(runAt time.Time, ownerID string) (DashboardFilters, error) {
+	to := truncatePeriod(runAt, "day")
+	f := DashboardFilters{
+		From:    to.AddDate(0, 0, -cfg.RangeDays),
+		To:      to,
+		Compare: cfg.Compare,
+		Owner:   cfg.Owner,
+		Segment: cfg.Segment,
+	}
+	return f.resolve(ownerID)
+}
+
+func formatReportNumber(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 2, 64)
+}
+
+func formatReportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatReportNumber(&v)
+	case *float64:
+		return formatReportNumber(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func kpiReportRow(name string, kpi KPI) []string {
+	row := []string{name, formatReportNumber(&kpi.Value), formatReportNumber(kpi.Previous), formatReportNumber(kpi.Delta), ""}
+	if kpi.PercentChange != nil {
+		row[4] = strconv.FormatFloat(*kpi.PercentChange*100, 'f', 1, 64) + "%"
+	}
+	return row
+}
+
+var kpiReportColumns = []string{"metric", "value", "previous", "delta", "percent_change"}
+
+// Converte o resultado de um widget em tabela
+func widgetReportTable(result WidgetResult) ReportTable {
+	table := ReportTable{Title: result.Widget.Title}
+	if table.Title == "" {
+		table.Title = result.Widget.Type + " " + result.Widget.Metric
+	}
+	if result.Error != "" {
+		table.Columns = []string{"error"}
+		table.Rows = [][]string{{result.Error}}
+		return table
+	}
+
+	switch data := result.Data.(type) {
+	case KPI:
+		table.Columns = kpiReportColumns
+		table.Rows = [][]string{kpiReportRow(result.Widget.Metric, data)}
+	case FunnelResult:
+		table.Columns = []string{"stage", "entered", "conversion_rate", "drop_offs"}
+		for _, step := range data.Steps {
+			table.Rows = append(table.Rows, []string{
+				step.Stage, strconv.Itoa(step.Entered), formatReportNumber(step.ConversionRate), strconv.Itoa(step.DropOffs),
+			})
+		}
+	case []RFMSegmentSummary:
+		table.Columns = []string{"segment", "label", "customers", "revenue"}
+		for _, s := range data {
+			table.Rows = append(table.Rows, []string{s.Segment, s.Label, strconv.Itoa(s.Customers), formatReportValue(s.Revenue)})
+		}
+	case []gin.H:
+		if len(data) > 0 {
+			for key := range data[0] {
+				table.Columns = append(table.Columns, key)
+			}
+			sort.Strings(table.Columns)
+		}
+		for _, item := range data {
+			row := make([]string, len(table.Columns))
+			for i, column := range table.Columns {
+				row[i] = formatReportValue(item[column])
+			}
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	return table
+}
+
+func generateReport(def ReportDefinition, runAt time.Time) (ReportDocument, error) {
+	doc := ReportDocument{Title: def.Name, GeneratedAt: runAt}
+
+	switch def.Kind {
+	case "metric":
+		f, err := def.Config.filters(runAt, def.OwnerID)
+		if err != nil {
+			return doc, err
+		}
+		summary, err := getDashboardSummary(def.tenantID, f)
+		if err != nil {
+			return doc, err
+		}
+		metrics := def.Config.Metrics
+		if len(metrics) == 0 {
+			for metric := range (dashboardMetrics{}).values() {
+				metrics = append(metrics, metric)
+			}
+			sort.Strings(metrics)
+		}
+		table := ReportTable{
+			Title:   fmt.Sprintf("%s a %s", f.From.Format("02/01/2006"), f.To.AddDate(0, 0, -1).Format("02/01/2006")),
+			Columns: kpiReportColumns,
+		}
+		for _, metric := range metrics {
+			table.Rows = append(table.Rows, kpiReportRow(metric, summary[metric].(KPI)))
+		}
+		doc.Tables = append(doc.Tables, table)
+
+	case "dashboard":
+		dashboard, err := loadSavedDashboard(def.tenantID, def.OwnerID, strconv.Itoa(def.Config.DashboardID))
+		if err != nil {
+			return doc, fmt.Errorf("dashboard %d indisponível: %w", def.Config.DashboardID, err)
+		}
+		for _, result := range evaluateDashboard(def.tenantID, def.OwnerID, dashboard.Widgets) {
+			doc.Tables = append(doc.Tables, widgetReportTable(result))
+		}
+
+	case "list":
+		list := reportListQueries[def.Config.Entity]
+		rows, err := db.Query(list.Query, def.tenantID, def.Config.Owner, def.Config.Limit)
+		if err != nil {
+			return doc, err
+		}
+		defer rows.Close()
+
+		table := ReportTable{Title: def.Config.Entity, Columns: list.Columns}
+		for rows.Next() {
+			row := make([]string, len(list.Columns))
+			dest := make([]interface{}, len(row))
+			for i := range row {
+				dest[i] = &row[i]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return doc, err
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		if err := rows.Err(); err != nil {
+			return doc, err
+		}
+		doc.Tables = append(doc.Tables, table)
+	}
+	return doc, nil
+}
+
+var reportEmailTemplate = template.Must(template.New("email").Parse(
+	`<p>Segue em anexo o relatório <strong>{{.Name}}</strong>, gerado em {{.GeneratedAt}}.</p>`,
+))
+
+// Gera, envia e registra a entrega do relatório. A entrega é registrada
+// mesmo em caso de falha, com a mensagem de erro.
+func deliverReport(ctx context.Context, def ReportDefinition, trigger string, now time.Time) (ReportDelivery, error) {
+	delivery := ReportDelivery{
+		ReportID: def.ID, Trigger: trigger, Status: "sent", Format: def.Format,
+		Recipients: def.Recipients, StartedAt: now.UTC(),
+	}
+
+	sendErr := func() error {
+		location, err := time.LoadLocation(def.Timezone)
+		if err != nil {
+			return err
+		}
+		runAt := now.In(location)
+		doc, err := generateReport(def, runAt)
+		if err != nil {
+			return err
+		}
+		format := reportFormats[def.Format]
+		data, err := format.Render(doc)
+		if err != nil {
+			return err
+		}
+		delivery.AttachmentBytes = len(data)
+
+		email := Email{To: def.Recipients, Subject: fmt.Sprintf("%s - %s", def.Name, runAt.Format("02/01/2006"))}
+		if def.Format == "html" {
+			email.HTMLBody = string(data)
+		} else {
+			var body strings.Builder
+			if err := reportEmailTemplate.Execute(&body, gin.H{"Name": def.Name, "GeneratedAt": runAt.Format("02/01/2006 15:04 MST")}); err != nil {
+				return err
+			}
+			email.HTMLBody = body.String()
+			email.Attachments = []EmailAttachment{{
+				Filename:    fmt.Sprintf("%s-%s.%s", strings.ReplaceAll(strings.ToLower(def.Name), " ", "-"), runAt.Format("2006-01-02"), format.Extension),
+				ContentType: format.ContentType,
+				Data:        data,
+			}}
+		}
+		return mailer.Send(ctx, email)
+	}()
+
+	if sendErr != nil {
+		message := sendErr.Error()
+		delivery.Status, delivery.Error = "failed", &message
+	}
+	finishedAt := time.Now().UTC()
+	delivery.FinishedAt = &finishedAt
+
+	err := db.QueryRow(`
+		INSERT INTO report_deliveries (report_id, tenant_id, trigger, status, format, recipients, attachment_bytes, error, started_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		def.ID, def.tenantID, delivery.Trigger, delivery.Status, delivery.Format, pq.Array(delivery.Recipients),
+		delivery.AttachmentBytes, delivery.Error, delivery.StartedAt, finishedAt,
+	).Scan(&delivery.ID)
+	if err != nil {
+		return delivery, err
+	}
+	return delivery, sendErr
+}
+
+// Executa os relatórios com próxima execução vencida e agenda a seguinte
+func runDueReports(ctx context.Context) error {
+	now := time.Now().UTC()
+	rows, err := db.QueryContext(ctx,
+		"SELECT "+reportDefinitionColumns+" FROM report_definitions WHERE enabled AND next_run_at <= $1 ORDER BY next_run_at LIMIT $2",
+		now, reportDueBatchSize,
+	)
+	if err != nil {
+		return err
+	}
+	var due []ReportDefinition
+	for rows.Next() {
+		def, err := scanReportDefinition(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, def)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, def := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		delivery, err := deliverReport(ctx, def, "schedule", now)
+		if err != nil {
+			logger.Errorf("Relatório %d (%s): entrega %d falhou: %v", def.ID, def.Name, delivery.ID, err)
+		}
+
+		var next *time.Time
+		if location, err := time.LoadLocation(def.Timezone); err == nil {
+			if schedule, err := parseCron(def.Schedule, location); err == nil {
+				if t := schedule.Next(now); !t.IsZero() {
+					utc := t.UTC()
+					next = &utc
+				}
+			}
+		}
+		if _, err := db.ExecContext(ctx,
+			"UPDATE report_definitions SET next_run_at = $2, last_run_at = $3 WHERE id = $1",
+			def.ID, next, now,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadReportDefinition(c *gin.Context) (ReportDefinition, bool) {
+	def, err := scanReportDefinition(db.QueryRow(
+		"SELECT "+reportDefinitionColumns+" FROM report_definitions WHERE id::text = $1 AND tenant_id = $2 AND owner_id::text = $3",
+		c.Param("id"), tenantFromContext(c), c.GetString("user_id"),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Relatório não encontrado"})
+		return def, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar relatório"})
+		return def, false
+	}
+	return def, true
+}
+
+func bindReportDefinition(c *gin.Context) (reportDefinitionInput, time.Time, bool) {
+	var in reportDefinitionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return in, time.Time{}, false
+	}
+	next, problem := in.validate(time.Now())
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return in, time.Time{}, false
+	}
+
+	// Listas levam dados pessoais de clientes: só admins e gestores as configuram
+	if role := c.GetString("role"); in.Kind == "list" && role != "admin" && role != "manager" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Apenas administradores e gestores podem agendar listas"})
+		return in, time.Time{}, false
+	}
+	rejected, err := unauthorizedReportRecipients(tenantFromContext(c), in.Recipients)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao validar destinatários"})
+		return in, time.Time{}, false
+	}
+	if len(rejected) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Destinatários devem ser usuários do tenant ou de um domínio permitido", "rejected": rejected})
+		return in, time.Time{}, false
+	}
+	return in, next.UTC(), true
+}
+
+// Domínios externos autorizados a receber relatórios, separados por vírgula
+func reportRecipientDomains() map[string]bool {
+	domains := map[string]bool{}
+	for _, domain := range strings.Split(os.Getenv("REPORT_RECIPIENT_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains[domain] = true
+		}
+	}
+	return domains
+}
+
+// Destinatários que não são usuários do tenant nem pertencem a um domínio
+// permitido. Os endereços já foram validados por validate.
+func unauthorizedReportRecipients(tenantID string, recipients []string) ([]string, error) {
+	domains := reportRecipientDomains()
+	addresses := make([]string, 0, len(recipients))
+	var pending []string
+	for _, recipient := range recipients {
+		parsed, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, err
+		}
+		address := strings.ToLower(parsed.Address)
+		if domains[address[strings.LastIndex(address, "@")+1:]] {
+			continue
+		}
+		addresses = append(addresses, address)
+		pending = append(pending, recipient)
+	}
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+
+	var users []string
+	err := db.QueryRow(
+		"SELECT COALESCE(array_agg(LOWER(email)), '{}') FROM users WHERE tenant_id = $1 AND LOWER(email) = ANY($2)",
+		tenantID, pq.Array(addresses),
+	).Scan(pq.Array(&users))
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, email := range users {
+		known[email] = true
+	}
+
+	var rejected []string
+	for i, address := range addresses {
+		if !known[address] {
+			rejected = append(rejected, pending[i])
+		}
+	}
+	return rejected, nil
+}
+
+func createReportDefinition(c *gin.Context) {
+	in, next, ok := bindReportDefinition(c)
+	if !ok {
+		return
+	}
+	enabled := in.Enabled == nil || *in.Enabled
+	config, _ := json.Marshal(in.Config)
+
+	def, err := scanReportDefinition(db.QueryRow(`
+		INSERT INTO report_definitions (tenant_id, owner_id, name, kind, config, schedule, timezone, recipients, format, enabled, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+reportDefinitionColumns,
+		tenantFromContext(c), c.GetString("user_id"), in.Name, in.Kind, config, in.Schedule, in.Timezone,
+		pq.Array(in.Recipients), in.Format, enabled, next,
+	))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar relatório"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "report_created", "report", strconv.Itoa(def.ID), gin.H{"name": def.Name, "recipients": def.Recipients})
+	c.JSON(http.StatusCreated, def)
+}
+
+func listReportDefinitions(c *gin.Context) {
+	rows, err := db.Query(
+		"SELECT "+reportDefinitionColumns+" FROM report_definitions WHERE tenant_id = $1 AND owner_id::text = $2 ORDER BY name",
+		tenantFromContext(c), c.GetString("user_id"),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar relatórios"})
+		return
+	}
+	defer rows.Close()
+
+	reports := []ReportDefinition{}
+	for rows.Next() {
+		def, err := scanReportDefinition(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler relatórios"})
+			return
+		}
+		reports = append(reports, def)
+	}
+	c.JSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+func getReportDefinition(c *gin.Context) {
+	if def, ok := loadReportDefinition(c); ok {
+		c.JSON(http.StatusOK, def)
+	}
+}
+
+func updateReportDefinition(c *gin.Context) {
+	if _, ok := loadReportDefinition(c); !ok {
+		return
+	}
+	in, next, ok := bindReportDefinition(c)
+	if !ok {
+		return
+	}
+	enabled := in.Enabled == nil || *in.Enabled
+	config, _ := json.Marshal(in.Config)
+
+	def, err := scanReportDefinition(db.QueryRow(`
+		UPDATE report_definitions SET
+			name = $3, kind = $4, config = $5, schedule = $6, timezone = $7, recipients = $8, format = $9,
+			enabled = $10, next_run_at = $11, updated_at = CURRENT_TIMESTAMP
+		WHERE id::text = $1 AND tenant_id = $2
+		RETURNING `+reportDefinitionColumns,
+		c.Param("id"), tenantFromContext(c), in.Name, in.Kind, config, in.Schedule, in.Timezone,
+		pq.Array(in.Recipients), in.Format, enabled, next,
+	))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar relatório"})
+		return
+	}
+	c.JSON(http.StatusOK, def)
+}
+
+func deleteReportDefinition(c *gin.Context) {
+	def, ok := loadReportDefinition(c)
+	if !ok {
+		return
+	}
+	if _, err := db.Exec("DELETE FROM report_definitions WHERE id = $1", def.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao excluir relatório"})
+		return
+	}
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "report_deleted", "report", strconv.Itoa(def.ID), gin.H{"name": def.Name})
+	c.JSON(http.StatusOK, gin.H{"message": "Relatório excluído com sucesso"})
+}
+
+// Gera e envia o relatório imediatamente, sem alterar a agenda
+func runReportNow(c *gin.Context) {
+	def, ok := loadReportDefinition(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := deliverReport(c.Request.Context(), def, "manual", time.Now())
+	if err != nil && delivery.ID == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao registrar entrega"})
+		return
+	}
+	status := http.StatusOK
+	if delivery.Status == "failed" {
+		status = http.StatusBadGateway
+	}
+	c.JSON(status, delivery)
+}
+
+// Histórico de entregas de um relatório (/reports/:id/deliveries) ou de
+// todos os relatórios do usuário (/reports/deliveries), com filtro
+// opcional ?status=failed
+func listReportDeliveries(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT `+reportDeliveryColumns+` FROM report_deliveries
+		WHERE tenant_id = $1
+			AND report_id IN (SELECT id FROM report_definitions WHERE tenant_id = $1 AND owner_id::text = $2)
+			AND ($3 = '' OR report_id::text = $3)
+			AND ($4 = '' OR status = $4)
+		ORDER BY started_at DESC
+		LIMIT $5`,
+		tenantFromContext(c), c.GetString("user_id"), c.Param("id"), c.Query("status"), reportDeliveryPageLimit,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar entregas"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []ReportDelivery{}
+	for rows.Next() {
+		delivery, err := scanReportDelivery(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler entregas"})
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
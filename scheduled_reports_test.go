@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	assert.NoError(t, err)
+
+	// Segunda-feira às 8h no horário de Brasília
+	schedule, err := parseCron("0 8 * * 1", saoPaulo)
+	assert.NoError(t, err)
+	after := time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC) // segunda, 8h em São Paulo
+	assert.Equal(t, time.Date(2026, 10, 26, 11, 0, 0, 0, time.UTC), schedule.Next(after).UTC())
+	assert.Equal(t, time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC), schedule.Next(after.Add(-time.Minute)).UTC())
+
+	schedule, _ = parseCron("*/15 9-17 * * 1-5", time.UTC)
+	assert.Equal(t, time.Date(2026, 10, 19, 9, 15, 0, 0, time.UTC), schedule.Next(time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC), schedule.Next(time.Date(2026, 10, 23, 17, 45, 0, 0, time.UTC)))
+
+	// Dia do mês e dia da semana restritos: basta um coincidir
+	schedule, _ = parseCron("0 0 1 * 0", time.UTC)
+	assert.Equal(t, time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), schedule.Next(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)))
+
+	schedule, _ = parseCron("@monthly", time.UTC)
+	assert.Equal(t, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), schedule.Next(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)))
+
+	schedule, _ = parseCron("0 0 31 2 *", time.UTC)
+	assert.True(t, schedule.Next(time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)).IsZero())
+
+	for _, spec := range []string{"0 8 * *", "60 * * * *", "0 8 * * 1-9", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCron(spec, time.UTC)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRenderReports(t *testing.T) {
+	rows := make([][]string, 120)
+	for i := range rows {
+		rows[i] = []string{"Produto (ação)", "1234.50"}
+	}
+	doc := ReportDocument{
+		Title:       "Resumo semanal de vendas",
+		GeneratedAt: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		Tables: []ReportTable{
+			{Title: "Indicadores", Columns: []string{"metric", "value"}, Rows: [][]string{{"totalRevenue", "10.00"}}},
+			{Title: "Produtos", Columns: []string{"name", "revenue"}, Rows: rows},
+		},
+	}
+
+	csvData, err := renderReportCSV(doc)
+	assert.NoError(t, err)
+	assert.Contains(t, string(csvData), "Indicadores\nmetric,value\ntotalRevenue,10.00\n\nProdutos\n")
+
+	htmlData, err := renderReportHTML(doc)
+	assert.NoError(t, err)
+	assert.Contains(t, string(htmlData), "<td>Produto (ação)</td>")
+
+	pdfData, err := renderReportPDF(doc)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdfData, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(pdfData, []byte("%%EOF\n")))
+	assert.Contains(t, string(pdfData), "/Count 3")
+	assert.Contains(t, string(pdfData), `(Produto \(a`+"\xe7\xe3"+`o\))`)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "CRMind <no-reply@crmind.local>"}
+	err := m.Send(context.Background(), Email{
+		To:          []string{"gestor@example.com"},
+		Subject:     "Relatório semanal",
+		HTMLBody:    "<p>Olá</p>",
+		Attachments: []EmailAttachment{{Filename: "vendas.csv", ContentType: "text/csv", Data: []byte("a,b\n1,2\n")}},
+	})
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Len(t, files, 1)
+	message, _ := os.ReadFile(files[0])
+	assert.Contains(t, string(message), "To: gestor@example.com")
+	assert.Contains(t, string(message), `filename=vendas.csv`)
+}
+
+func TestReportFiltersUseReportTimezone(t *testing.T) {
+	location, err := time.LoadLocation("America/Sao_Paulo")
+	assert.NoError(t, err)
+	runAt := time.Date(2026, 10, 19, 8, 0, 0, 0, location)
+
+	f, err := ReportConfig{RangeDays: 7}.filters(runAt, "")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 12, 0, 0, 0, 0, location), f.From)
+	assert.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, location), f.To)
+	assert.Equal(t, location, f.From.Location())
+}
+
+func TestReportRecipientDomains(t *testing.T) {
+	t.Setenv("REPORT_RECIPIENT_DOMAINS", " Parceiro.com.br, ,contabil.io")
+	assert.Equal(t, map[string]bool{"parceiro.com.br": true, "contabil.io": true}, reportRecipientDomains())
+}
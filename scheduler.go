@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Tarefa executada periodicamente em segundo plano
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+var (
+	scheduledJobs      []ScheduledJob
+	scheduledJobsMutex sync.Mutex
+)
+
+func registerJob(job ScheduledJob) {
+	scheduledJobsMutex.Lock()
+	defer scheduledJobsMutex.Unlock()
+
+	scheduledJobs = append(scheduledJobs, job)
+}
+
+// Inicia todas as tarefas registradas. Elas param quando o contexto é cancelado.
+func startScheduler(ctx context.Context) *sync.WaitGroup {
+	scheduledJobsMutex.Lock()
+	jobs := append([]ScheduledJob(nil), scheduledJobs...)
+	scheduledJobsMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job ScheduledJob) {
+			defer wg.Done()
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					runScheduledJob(ctx, job)
+				}
+			}
+		}(job)
+	}
+	return &wg
+}
+
+// Executa a tarefa sob um advisory lock do Postgres, para que apenas uma
+// réplica da API a execute por vez
+func runScheduledJob(ctx context.Context, job ScheduledJob) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		logger.Errorf("Tarefa %s: falha ao obter conexão: %v", job.Name, err)
+		return
+	}
+	defer conn.Close()
+
+	lockKey := jobLockKey(job.Name)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&acquired); err != nil {
+		logger.Errorf("Tarefa %s: falha ao obter lock: %v", job.Name, err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		logger.Errorf("Tarefa %s falhou após %v: %v", job.Name, time.Since(start), err)
+		return
+	}
+	logger.Infof("Tarefa %s concluída em %v", job.Name, time.Since(start))
+}
+
+func jobLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("crmind-job:" + name))
+	return int64(h.Sum64())
+}
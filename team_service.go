@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+type Team struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	ManagerID string   `json:"manager_id"`
+	MemberIDs []string `json:"member_ids"`
+}
+
+func createTeamTables() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS teams (
+			id SERIAL PRIMARY KEY,
+			tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+			name VARCHAR(100) NOT NULL,
+			manager_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (tenant_id, name)
+		);
+
+		CREATE TABLE IF NOT EXISTS team_members (
+			team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (team_id, user_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_team_members_user ON team_members (user_id);
+	`)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func setupTeamRoutes(r *gin.Engine) {
+	teams := r.Group("/teams")
+	teams.Use(AuthMiddleware())
+	{
+		teams.POST("", roleAuthorization("admin", "manager"), createTeam)
+		teams.GET("", listTeams)
+		teams.PUT("/:id/members", roleAuthorization("admin", "manager"), setTeamMembers)
+	}
+}
+
+// Indica se todos os ids são usuários do tenant
+func tenantUsersExist(tenantID string, userIDs []int) (bool, error) {
+	var exist bool
+	err := db.QueryRow(`
+		SELECT NOT EXISTS (
+			SELECT 1 FROM unnest($2::integer[]) AS u(id)
+			WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = u.id AND users.tenant_id = $1)
+		)`, tenantID, pq.Array(userIDs),
+	).Scan(&exist)
+	return exist, err
+}
+
+// Indica se managerID gerencia algum time do qual userID faz parte
+func managesUser(tenantID, managerID, userID string) (bool, error) {
+	var manages bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM teams t
+			JOIN team_members m ON m.team_id = t.id
+			WHERE t.tenant_id = $1 AND t.manager_id::text = $2 AND m.user_id::text = $3
+		)`, tenantID, managerID, userID,
+	).Scan(&manages)
+	return manages, err
+}
+
+// Indica se userID é gestor ou membro do time
+func belongsToTeam(tenantID string, teamID int, userID string) (bool, error) {
+	var belongs bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM teams t
+			LEFT JOIN team_members m ON m.team_id = t.id AND m.user_id::text = $3
+			WHERE t.tenant_id = $1 AND t.id = $2 AND (t.manager_id::text = $3 OR m.user_id IS NOT NULL)
+		)`, tenantID, teamID, userID,
+	).Scan(&belongs)
+	return belongs, err
+}
+
+// Usuários dos times gerenciados por managerID
+func managedUserIDs(tenantID, managerID string) ([]string, error) {
+	var ids []string
+	err := db.QueryRow(`
+		SELECT COALESCE(array_agg(DISTINCT m.user_id::text), '{}')
+		FROM teams t
+		JOIN team_members m ON m.team_id = t.id
+		WHERE t.tenant_id = $1 AND t.manager_id::text = $2`,
+		tenantID, managerID,
+	).Scan(pq.Array(&ids))
+	return ids, err
+}
+
+func createTeam(c *gin.Context) {
+	var request struct {
+		Name      string `json:"name" binding:"required"`
+		ManagerID int    `json:"manager_id"`
+		MemberIDs []int  `json:"member_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var team Team
+	manager := c.GetString("user_id")
+	users := request.MemberIDs
+	if request.ManagerID != 0 {
+		manager = strconv.Itoa(request.ManagerID)
+		users = append([]int{request.ManagerID}, users...)
+	}
+	exist, err := tenantUsersExist(tenantFromContext(c), users)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao validar usuários"})
+		return
+	}
+	if !exist {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gestor ou membros não encontrados"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar time"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(
+		"INSERT INTO teams (tenant_id, name, manager_id) VALUES ($1, $2, $3) RETURNING id, name, manager_id::text",
+		tenantFromContext(c), request.Name, manager,
+	).Scan(&team.ID, &team.Name, &team.ManagerID)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Já existe um time com esse nome"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar time"})
+		return
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO team_members (team_id, user_id) SELECT $1, unnest($2::integer[]) ON CONFLICT DO NOTHING",
+		team.ID, pq.Array(request.MemberIDs),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao adicionar membros"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao criar time"})
+		return
+	}
+
+	team.MemberIDs = []string{}
+	for _, id := range request.MemberIDs {
+		team.MemberIDs = append(team.MemberIDs, strconv.Itoa(id))
+	}
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "team_created", "team", strconv.Itoa(team.ID), gin.H{"name": team.Name, "manager_id": team.ManagerID})
+	c.JSON(http.StatusCreated, team)
+}
+
+func listTeams(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, t.manager_id::text, COALESCE(array_agg(m.user_id::text ORDER BY m.user_id) FILTER (WHERE m.user_id IS NOT NULL), '{}')
+		FROM teams t
+		LEFT JOIN team_members m ON m.team_id = t.id
+		WHERE t.tenant_id = $1
+		GROUP BY t.id
+		ORDER BY t.name`, tenantFromContext(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao listar times"})
+		return
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var team Team
+		if err := rows.Scan(&team.ID, &team.Name, &team.ManagerID, pq.Array(&team.MemberIDs)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao ler times"})
+			return
+		}
+		teams = append(teams, team)
+	}
+	c.JSON(http.StatusOK, gin.H{"teams": teams})
+}
+
+// Substitui os membros do time. Apenas o gestor do time ou um admin pode
+// alterá-lo.
+func setTeamMembers(c *gin.Context) {
+	var request struct {
+		MemberIDs []int `json:"member_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var managerID string
+	err := db.QueryRow(
+		"SELECT manager_id::text FROM teams WHERE id::text = $1 AND tenant_id = $2",
+		c.Param("id"), tenantFromContext(c),
+	).Scan(&managerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Time não encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao buscar time"})
+		return
+	}
+	if managerID != c.GetString("user_id") && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Apenas o gestor do time pode alterar seus membros"})
+		return
+	}
+	exist, err := tenantUsersExist(tenantFromContext(c), request.MemberIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao validar usuários"})
+		return
+	}
+	if !exist {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Membros não encontrados"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar membros"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM team_members WHERE team_id::text = $1", c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar membros"})
+		return
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO team_members (team_id, user_id) SELECT $1::integer, unnest($2::integer[]) ON CONFLICT DO NOTHING",
+		c.Param("id"), pq.Array(request.MemberIDs),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar membros"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao atualizar membros"})
+		return
+	}
+
+	recordAudit(tenantFromContext(c), c.GetString("user_id"), "team_members_updated", "team", c.Param("id"), gin.H{"member_ids": request.MemberIDs})
+	c.JSON(http.StatusOK, gin.H{"message": "Membros atualizados com sucesso"})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const defaultTenantID = "default"
+
+// Retorna o tenant do usuário autenticado (definido pelo AuthMiddleware)
+func tenantFromContext(c *gin.Context) string {
+	if tenantID := c.GetString("tenant_id"); tenantID != "" {
+		return tenantID
+	}
+	return defaultTenantID
+}
+
+// Tenants com pelo menos um cliente, para as tarefas agendadas por tenant
+func listTenants() ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT tenant_id FROM customers")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, rows.Err()
+}
@@ -13,3 +13,50 @@ func IsValidPhone(phone string) bool {
 	phoneRegex := regexp.MustCompile(`^\+?[1-9]\d{1,14}$`)
 	return phoneRegex.MatchString(phone)
 }
+
+// Valida CPF (11 dígitos) ou CNPJ (14 dígitos), com ou sem pontuação
+func IsValidTaxID(taxID string) bool {
+	digits := make([]int, 0, 14)
+	for _, r := range taxID {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, int(r-'0'))
+		case r == '.' || r == '-' || r == '/' || r == ' ':
+		default:
+			return false
+		}
+	}
+
+	switch len(digits) {
+	case 11:
+		return !allSameDigit(digits) &&
+			checkDigit(digits[:9], []int{10, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[9] &&
+			checkDigit(digits[:10], []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[10]
+	case 14:
+		return !allSameDigit(digits) &&
+			checkDigit(digits[:12], []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[12] &&
+			checkDigit(digits[:13], []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}) == digits[13]
+	default:
+		return false
+	}
+}
+
+func checkDigit(digits, weights []int) int {
+	sum := 0
+	for i, d := range digits {
+		sum += d * weights[i]
+	}
+	if rest := sum % 11; rest >= 2 {
+		return 11 - rest
+	}
+	return 0
+}
+
+func allSameDigit(digits []int) bool {
+	for _, d := range digits[1:] {
+		if d != digits[0] {
+			return false
+		}
+	}
+	return true
+}